@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+)
+
+// Llave publica en formato JWK (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`           // Tipo de llave: RSA u OKP
+	Kid string `json:"kid"`           // Identificador de la llave
+	Use string `json:"use"`           // Siempre "sig", las llaves solo se usan para firmar
+	Alg string `json:"alg"`           // Algoritmo: RS256 o EdDSA
+	N   string `json:"n,omitempty"`   // Modulo de la llave RSA
+	E   string `json:"e,omitempty"`   // Exponente de la llave RSA
+	Crv string `json:"crv,omitempty"` // Curva de la llave OKP
+	X   string `json:"x,omitempty"`   // Llave publica Ed25519
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Devuelve las llaves publicas para que otros servicios puedan verificar los tokens sin conocer ningun secreto
+func (k *KeyManager) JWKS() JWKSet {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		switch public := key.PublicKey().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
@@ -0,0 +1,300 @@
+package auth
+
+/*
+	Administrador de las llaves con las que se firman y verifican los JWT
+	Cada llave se identifica con un kid que viaja en la cabecera del token, asi se pueden tener
+	varias llaves activas al mismo tiempo y rotarlas sin invalidar los tokens ya emitidos
+
+	Algoritmos soportados:
+		RS256 -> llaves RSA
+		EdDSA -> llaves Ed25519
+		HS256 -> secreto compartido, solo para compatibilidad con JWT_SECRET y nunca se publica en el JWKS
+*/
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrUnknownKey        = errors.New("unknown signing key")
+	ErrNoSigningKey      = errors.New("no signing key configured")
+	ErrAlgorithmMismatch = errors.New("token algorithm does not match key")
+)
+
+type Key struct {
+	Id         string            // Identificador de la llave (kid)
+	Method     jwt.SigningMethod // Algoritmo con el que se firma y verifica
+	signingKey interface{}       // Llave privada o secreto, nil si la llave solo sirve para verificar
+	verifyKey  interface{}       // Llave publica o secreto
+}
+
+// Indica si la llave puede firmar tokens o solo verificarlos
+func (k *Key) CanSign() bool {
+	return k.signingKey != nil
+}
+
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{Id: id, Method: jwt.SigningMethodHS256, signingKey: secret, verifyKey: secret}
+}
+
+func NewRSAKey(id string, private *rsa.PrivateKey) *Key {
+	return &Key{Id: id, Method: jwt.SigningMethodRS256, signingKey: private, verifyKey: &private.PublicKey}
+}
+
+func NewRSAPublicKey(id string, public *rsa.PublicKey) *Key {
+	return &Key{Id: id, Method: jwt.SigningMethodRS256, verifyKey: public}
+}
+
+func NewEd25519Key(id string, private ed25519.PrivateKey) *Key {
+	return &Key{Id: id, Method: jwt.SigningMethodEdDSA, signingKey: private, verifyKey: private.Public()}
+}
+
+func NewEd25519PublicKey(id string, public ed25519.PublicKey) *Key {
+	return &Key{Id: id, Method: jwt.SigningMethodEdDSA, verifyKey: public}
+}
+
+type KeyManager struct {
+	mutex  sync.RWMutex    // Mutex para poder rotar llaves mientras se atienden peticiones
+	keys   map[string]*Key // Llaves indexadas por kid
+	active string          // kid de la llave con la que se firman los nuevos tokens
+}
+
+func NewKeyManager() *KeyManager {
+	return &KeyManager{
+		keys: map[string]*Key{},
+	}
+}
+
+// Agrega una llave, si active es true los nuevos tokens se firman con ella
+func (k *KeyManager) AddKey(key *Key, active bool) error {
+	if key.Id == "" {
+		return errors.New("key id is required")
+	}
+	if active && !key.CanSign() {
+		return fmt.Errorf("key %s cannot sign tokens", key.Id)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.keys[key.Id] = key
+	if active {
+		k.active = key.Id
+	}
+	return nil
+}
+
+// Quita una llave, los tokens firmados con ella dejan de ser validos
+func (k *KeyManager) RemoveKey(id string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	delete(k.keys, id)
+	if k.active == id {
+		k.active = ""
+	}
+}
+
+// Cambia la llave con la que se firman los nuevos tokens
+func (k *KeyManager) SetActive(id string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("key %s cannot sign tokens", id)
+	}
+	k.active = id
+	return nil
+}
+
+// Firma las claims con la llave activa e incluye su kid en la cabecera del token
+func (k *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	k.mutex.RLock()
+	key, ok := k.keys[k.active]
+	k.mutex.RUnlock()
+
+	if !ok {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.signingKey)
+}
+
+// Parsea y valida un token
+// Solo se aceptan los algoritmos de las llaves configuradas y el algoritmo del token debe ser
+// el de la llave indicada por su kid, asi no se puede usar una llave publica como secreto HMAC
+func (k *KeyManager) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	parser := &jwt.Parser{ValidMethods: k.methods()}
+
+	return parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		key, err := k.keyFor(token)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, ErrAlgorithmMismatch
+		}
+		return key.verifyKey, nil
+	})
+}
+
+// Busca la llave del token por su kid
+// Los tokens sin kid solo se aceptan si hay una unica llave HMAC, para no romper los tokens ya emitidos con JWT_SECRET
+func (k *KeyManager) keyFor(token *jwt.Token) (*Key, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return key, nil
+	}
+
+	var found *Key
+	for _, key := range k.keys {
+		if key.Method == jwt.SigningMethodHS256 {
+			if found != nil {
+				return nil, ErrUnknownKey
+			}
+			found = key
+		}
+	}
+	if found == nil {
+		return nil, ErrUnknownKey
+	}
+	return found, nil
+}
+
+// Devuelve los algoritmos de las llaves configuradas
+func (k *KeyManager) methods() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	seen := map[string]bool{}
+	methods := []string{}
+	for _, key := range k.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// Carga todas las llaves PEM (*.pem) de un directorio, el nombre del archivo sin extension es el kid
+// Los archivos con llaves privadas sirven para firmar y verificar, los que tienen llaves publicas solo para verificar
+// Si active esta vacio se firma con la llave privada de mayor kid en orden alfabetico,
+// de modo que nombrar las llaves por fecha (2024-01, 2024-06, ...) rota automaticamente a la mas reciente
+func (k *KeyManager) LoadDir(dir string, active string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no keys found in %s", dir)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		key, err := LoadKeyFile(path)
+		if err != nil {
+			return err
+		}
+		if err := k.AddKey(key, false); err != nil {
+			return err
+		}
+		if active == "" && key.CanSign() {
+			k.mutex.Lock()
+			k.active = key.Id
+			k.mutex.Unlock()
+		}
+	}
+
+	if active != "" {
+		return k.SetActive(active)
+	}
+	return nil
+}
+
+// Carga una llave RSA o Ed25519 desde un archivo PEM, el kid es el nombre del archivo sin extension
+func LoadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	key, err := ParseKeyPEM(id, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// Parsea una llave RSA o Ed25519 en formato PEM (PKCS1, PKCS8 o PKIX)
+func ParseKeyPEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return NewRSAKey(id, key), nil
+	case *rsa.PublicKey:
+		return NewRSAPublicKey(id, key), nil
+	case ed25519.PrivateKey:
+		return NewEd25519Key(id, key), nil
+	case ed25519.PublicKey:
+		return NewEd25519PublicKey(id, key), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// Devuelve la llave publica, nil para las llaves HMAC que no se pueden publicar
+func (k *Key) PublicKey() crypto.PublicKey {
+	if k.Method == jwt.SigningMethodHS256 {
+		return nil
+	}
+	return k.verifyKey
+}
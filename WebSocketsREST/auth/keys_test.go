@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func newClaims() *jwt.StandardClaims {
+	return &jwt.StandardClaims{Subject: "user", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+}
+
+func newRSAKey(t *testing.T, id string) *Key {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRSAKey(id, private)
+}
+
+func newEd25519Key(t *testing.T, id string) *Key {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEd25519Key(id, private)
+}
+
+func TestSignAndParse(t *testing.T) {
+	tables := []struct {
+		name string
+		key  *Key
+	}{
+		{"HS256", NewHMACKey("hs", []byte("secret"))},
+		{"RS256", newRSAKey(t, "rs")},
+		{"EdDSA", newEd25519Key(t, "ed")},
+	}
+
+	for _, item := range tables {
+		keys := NewKeyManager()
+		if err := keys.AddKey(item.key, true); err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := keys.Sign(newClaims())
+		if err != nil {
+			t.Fatalf("%s: %v", item.name, err)
+		}
+
+		token, err := keys.Parse(signed, &jwt.StandardClaims{})
+		if err != nil {
+			t.Errorf("%s: Parse failed: %v", item.name, err)
+			continue
+		}
+		if token.Header["kid"] != item.key.Id || token.Method.Alg() != item.name {
+			t.Errorf("%s: got kid %v alg %s", item.name, token.Header["kid"], token.Method.Alg())
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	keys := NewKeyManager()
+	old := newEd25519Key(t, "2024-01")
+	if err := keys.AddKey(old, true); err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := keys.Sign(newClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Los tokens firmados con la llave anterior siguen siendo validos despues de rotar
+	if err := keys.AddKey(newRSAKey(t, "2024-06"), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(oldToken, &jwt.StandardClaims{}); err != nil {
+		t.Errorf("token signed with the previous key was rejected: %v", err)
+	}
+
+	newToken, err := keys.Sign(newClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := keys.Parse(newToken, &jwt.StandardClaims{})
+	if token == nil || token.Header["kid"] != "2024-06" {
+		t.Errorf("new tokens should be signed with the active key")
+	}
+
+	// Al retirar la llave sus tokens dejan de ser validos
+	keys.RemoveKey(old.Id)
+	if _, err := keys.Parse(oldToken, &jwt.StandardClaims{}); err == nil {
+		t.Errorf("token signed with a removed key was accepted")
+	}
+}
+
+func TestAlgorithmPinning(t *testing.T) {
+	rsaKey := newRSAKey(t, "rs")
+	keys := NewKeyManager()
+	if err := keys.AddKey(rsaKey, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ataque clasico: firmar con HS256 usando la llave publica RSA como secreto
+	public, err := x509.MarshalPKIXPublicKey(rsaKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims())
+	forged.Header["kid"] = "rs"
+	signed, err := forged.SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(signed, &jwt.StandardClaims{}); err == nil {
+		t.Errorf("HS256 token signed with the RSA public key was accepted")
+	}
+
+	// Un algoritmo aceptado pero distinto al de la llave indicada por el kid tampoco se acepta
+	if err := keys.AddKey(NewHMACKey("hs", []byte("secret")), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(signed, &jwt.StandardClaims{}); err == nil {
+		t.Errorf("token with an algorithm different from its key was accepted")
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims())
+	unknown.Header["kid"] = "missing"
+	signed, err = unknown.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(signed, &jwt.StandardClaims{}); err == nil {
+		t.Errorf("token with an unknown kid was accepted")
+	}
+}
+
+func TestLoadDirAndJWKS(t *testing.T) {
+	dir := t.TempDir()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "2024-01.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(private))
+
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "2024-06.pem"), "PRIVATE KEY", pkcs8)
+
+	// Llave publica de otro servicio, solo sirve para verificar
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(otherPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "9999-other.pem"), "PUBLIC KEY", pkix)
+
+	keys := NewKeyManager()
+	if err := keys.LoadDir(dir, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := keys.Sign(newClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := keys.Parse(signed, &jwt.StandardClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != "2024-06" || token.Method.Alg() != "EdDSA" {
+		t.Errorf("expected the greatest private kid to be active, got %v %s", token.Header["kid"], token.Method.Alg())
+	}
+
+	if err := keys.SetActive("9999-other"); err == nil {
+		t.Errorf("a public key should not be able to become the active key")
+	}
+
+	set := keys.JWKS()
+	if len(set.Keys) != 3 {
+		t.Fatalf("JWKS was incorrect, got %d keys expected 3", len(set.Keys))
+	}
+	expected := []struct {
+		kid string
+		kty string
+		alg string
+	}{
+		{"2024-01", "RSA", "RS256"},
+		{"2024-06", "OKP", "EdDSA"},
+		{"9999-other", "OKP", "EdDSA"},
+	}
+	for i, item := range expected {
+		jwk := set.Keys[i]
+		if jwk.Kid != item.kid || jwk.Kty != item.kty || jwk.Alg != item.alg || jwk.Use != "sig" {
+			t.Errorf("JWKS key %d was incorrect, got %+v expected %+v", i, jwk, item)
+		}
+	}
+	if set.Keys[0].N == "" || set.Keys[0].E != "AQAB" {
+		t.Errorf("RSA JWK was incorrect, got %+v", set.Keys[0])
+	}
+}
+
+func TestJWKSExcludesHMAC(t *testing.T) {
+	keys := NewKeyManager()
+	if err := keys.AddKey(NewHMACKey("hs", []byte("secret")), true); err != nil {
+		t.Fatal(err)
+	}
+	if set := keys.JWKS(); len(set.Keys) != 0 {
+		t.Errorf("HMAC secrets must not be published, got %+v", set.Keys)
+	}
+}
+
+func writePEM(t *testing.T, path string, kind string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
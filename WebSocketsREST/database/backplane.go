@@ -0,0 +1,151 @@
+package database
+
+/*
+	Backplane del hub de websockets sobre LISTEN/NOTIFY de PostgresSQL
+	Cada instancia del servidor escucha el canal hub_events y publica ahi sus mensajes,
+	asi un mensaje publicado en un nodo llega a los clientes conectados a cualquier otro nodo
+
+	NOTIFY solo acepta payloads de hasta 8000 bytes, los mensajes mas grandes se guardan
+	en la tabla hub_messages y el NOTIFY solo lleva su id
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	backplaneChannel = "hub_events"
+	// Tamaño maximo que se envia directamente en el NOTIFY, con margen bajo el limite de 8000 bytes
+	maxNotifyPayload = 7900
+	// Prefijo de los payloads que solo llevan el id de un mensaje guardado en hub_messages
+	messageRefPrefix = "ref:"
+	// Tiempo que se guardan los mensajes grandes, suficiente para que todos los nodos los lean
+	messageRetention = time.Minute
+)
+
+type PostgresBackplane struct {
+	db       *sql.DB
+	listener *pq.Listener
+	done     chan struct{}
+	closed   sync.Once
+	closeErr error
+
+	mutex      sync.Mutex
+	subscribed bool
+}
+
+func NewPostgresBackplane(url string) (*PostgresBackplane, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := pq.NewListener(url, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("Backplane listener error: ", err)
+		}
+	})
+	if err := listener.Listen(backplaneChannel); err != nil {
+		listener.Close()
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresBackplane{db: db, listener: listener, done: make(chan struct{})}, nil
+}
+
+func (p *PostgresBackplane) Publish(ctx context.Context, data []byte) error {
+	payload := string(data)
+
+	if len(data) > maxNotifyPayload {
+		id := ksuid.New().String()
+		if _, err := p.db.ExecContext(ctx, "INSERT INTO hub_messages (id, data) VALUES ($1, $2)", id, payload); err != nil {
+			return err
+		}
+		// Se aprovecha para borrar los mensajes que ya todos los nodos leyeron
+		if _, err := p.db.ExecContext(ctx, "DELETE FROM hub_messages WHERE created_at < $1", time.Now().UTC().Add(-messageRetention)); err != nil {
+			log.Println("Could not clean hub messages: ", err)
+		}
+		payload = messageRefPrefix + id
+	}
+
+	_, err := p.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", backplaneChannel, payload)
+	return err
+}
+
+// Entrega cada notificacion recibida a handler, solo se admite un handler por backplane
+func (p *PostgresBackplane) Subscribe(handler func(data []byte)) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.subscribed {
+		return errors.New("backplane already has a subscriber")
+	}
+	p.subscribed = true
+
+	go p.listen(handler)
+	return nil
+}
+
+func (p *PostgresBackplane) listen(handler func(data []byte)) {
+	for {
+		select {
+		case <-p.done:
+			return
+		case notification, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			// pq envia nil despues de reconectar, los mensajes publicados mientras tanto se pierden
+			if notification == nil {
+				log.Println("Backplane listener reconnected")
+				continue
+			}
+
+			data, err := p.resolve(notification.Extra)
+			if err != nil {
+				log.Println("Could not read backplane message: ", err)
+				continue
+			}
+			handler(data)
+		}
+	}
+}
+
+// Devuelve el mensaje de una notificacion, leyendolo de hub_messages si no cabia en el NOTIFY
+func (p *PostgresBackplane) resolve(payload string) ([]byte, error) {
+	if !strings.HasPrefix(payload, messageRefPrefix) {
+		return []byte(payload), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var data string
+	err := p.db.QueryRowContext(ctx, "SELECT data FROM hub_messages WHERE id = $1", strings.TrimPrefix(payload, messageRefPrefix)).Scan(&data)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return []byte(data), nil
+}
+
+// Cerrar mas de una vez devuelve el resultado del primer cierre
+func (p *PostgresBackplane) Close() error {
+	p.closed.Do(func() {
+		close(p.done)
+		p.closeErr = p.listener.Close()
+		if err := p.db.Close(); p.closeErr == nil {
+			p.closeErr = err
+		}
+	})
+	return p.closeErr
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPostgresBackplaneClose(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	backplane, err := NewPostgresBackplane(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// El segundo Close no entra en panico y devuelve el resultado del primero
+	first := backplane.Close()
+	if second := backplane.Close(); second != first {
+		t.Errorf("second Close was incorrect, got %v expected %v", second, first)
+	}
+}
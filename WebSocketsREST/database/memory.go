@@ -0,0 +1,957 @@
+package database
+
+/*
+	Implementacion especifica del repositorio en memoria
+	Se utiliza para pruebas y desarrollo local, ya que no necesita una base de datos de PostgresSQL
+	Los datos se guardan en mapas protegidos por un mutex y se pierden al cerrar el servidor
+*/
+
+import (
+	"context"
+	"rest_ws/models"
+	"rest_ws/repository"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MemoryRepository struct {
+	mutex         sync.RWMutex                          // Mutex para proteger los mapas de lectura y escritura concurrente
+	users         map[string]*models.User               // Usuarios indexados por id
+	userOrder     []string                              // Ids de los usuarios en orden de creacion
+	posts         map[string]*models.Post               // Posts indexados por id
+	comments      map[string]*models.Comment            // Comentarios indexados por id
+	reactions     map[reactionKey]models.ReactionKind   // Reaccion de cada usuario a cada post
+	reactionCount map[string]models.ReactionCounts      // Totales de reacciones indexados por id del post
+	follows       map[followKey]*models.Follow          // Relaciones entre usuarios indexadas por seguidor y seguido
+	refreshTokens map[string]*models.RefreshToken       // Tokens de refresco indexados por id
+	revokedTokens map[string]time.Time                  // Expiracion de los access tokens revocados indexados por jti
+	resetTokens   map[string]*models.PasswordResetToken // Tokens de restablecimiento de contraseña indexados por id
+	outbox        map[string]*models.OutboxMessage      // Eventos del outbox indexados por id
+	outboxOrder   []string                              // Ids de los eventos del outbox en orden de creacion
+	loginAttempts map[string]*models.LoginAttempt       // Intentos fallidos de login indexados por llave
+	loginAudit    []*models.LoginAudit                  // Registros de auditoria de login en orden de creacion
+}
+
+// Imita la llave primaria (post_id, user_id) de la tabla reactions
+type reactionKey struct {
+	postId string
+	userId string
+}
+
+// Imita la llave primaria (follower_id, followee_id) de la tabla follows
+type followKey struct {
+	followerId string
+	followeeId string
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		users:         map[string]*models.User{},
+		posts:         map[string]*models.Post{},
+		comments:      map[string]*models.Comment{},
+		reactions:     map[reactionKey]models.ReactionKind{},
+		reactionCount: map[string]models.ReactionCounts{},
+		follows:       map[followKey]*models.Follow{},
+		refreshTokens: map[string]*models.RefreshToken{},
+		revokedTokens: map[string]time.Time{},
+		resetTokens:   map[string]*models.PasswordResetToken{},
+		outbox:        map[string]*models.OutboxMessage{},
+		loginAttempts: map[string]*models.LoginAttempt{},
+	}
+}
+
+func (m *MemoryRepository) InsertUser(ctx context.Context, user *models.User, events ...*models.OutboxMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkOutbox(events); err != nil {
+		return err
+	}
+
+	// Se imitan la llave primaria y el indice unico sobre LOWER(email) de PostgresSQL
+	if _, ok := m.users[user.Id]; ok {
+		return repository.ErrConflict
+	}
+	for _, stored := range m.users {
+		if strings.EqualFold(stored.Email, user.Email) {
+			return repository.ErrConflict
+		}
+	}
+
+	// Se guarda una copia para que el llamador no pueda modificar el estado del repositorio
+	clone := *user
+	clone.VerifiedAt = cloneTime(user.VerifiedAt)
+	clone.PasswordChangedAt = cloneTime(user.PasswordChangedAt)
+	if clone.Role == "" {
+		clone.Role = models.RoleUser
+	}
+	m.users[user.Id] = &clone
+	m.userOrder = append(m.userOrder, user.Id)
+	m.insertOutbox(events)
+	return nil
+}
+
+func (m *MemoryRepository) FindUserById(ctx context.Context, id string) (*models.User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	// Igual que en PostgresSQL, la contraseña no se devuelve al buscar por id
+	return &models.User{
+		Id:                user.Id,
+		Email:             user.Email,
+		Role:              user.Role,
+		VerifiedAt:        cloneTime(user.VerifiedAt),
+		PasswordChangedAt: cloneTime(user.PasswordChangedAt),
+	}, nil
+}
+
+func (m *MemoryRepository) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, user := range m.users {
+		if user.Email == email {
+			clone := *user
+			clone.VerifiedAt = cloneTime(user.VerifiedAt)
+			clone.PasswordChangedAt = cloneTime(user.PasswordChangedAt)
+			return &clone, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+func (m *MemoryRepository) UpdateUserRole(ctx context.Context, id string, role models.Role) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	user.Role = role
+	return nil
+}
+
+func (m *MemoryRepository) VerifyUserEmail(ctx context.Context, id string, at time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if user.VerifiedAt == nil {
+		verifiedAt := at.UTC()
+		user.VerifiedAt = &verifiedAt
+	}
+	return nil
+}
+
+// Copia una fecha opcional para que el llamador no pueda modificar la del repositorio
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	clone := *t
+	return &clone
+}
+
+func (m *MemoryRepository) ListUsers(ctx context.Context, page uint64) ([]*models.User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var users []*models.User
+
+	start := page * 10
+	for i := start; i < start+10 && i < uint64(len(m.userOrder)); i++ {
+		user := m.users[m.userOrder[i]]
+		users = append(users, &models.User{Id: user.Id, Email: user.Email, Role: user.Role, VerifiedAt: cloneTime(user.VerifiedAt)})
+	}
+
+	return users, nil
+}
+
+func (m *MemoryRepository) InsertPost(ctx context.Context, post *models.Post, events ...*models.OutboxMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.posts[post.Id]; ok {
+		return repository.ErrConflict
+	}
+	// Se imita la llave foranea hacia users
+	if _, ok := m.users[post.UserID]; !ok {
+		return repository.ErrNotFound
+	}
+	if err := m.checkOutbox(events); err != nil {
+		return err
+	}
+
+	clone := *post
+	// Se imita el valor por defecto de la columna created_at
+	if clone.CreatedAt.IsZero() {
+		clone.CreatedAt = time.Now()
+	}
+	m.posts[post.Id] = &clone
+	m.insertOutbox(events)
+	return nil
+}
+
+func (m *MemoryRepository) GetPostById(ctx context.Context, id string) (*models.Post, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	post, ok := m.posts[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	clone := *post
+	clone.Reactions = m.counts(id)
+	return &clone, nil
+}
+
+func (m *MemoryRepository) UpdatePost(ctx context.Context, post *models.Post, events ...*models.OutboxMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.posts[post.Id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if err := m.checkOutbox(events); err != nil {
+		return err
+	}
+
+	// Al igual que en PostgresSQL, solo se actualiza el contenido
+	stored.Content = post.Content
+	m.insertOutbox(events)
+	return nil
+}
+
+func (m *MemoryRepository) DeletePost(ctx context.Context, id string, events ...*models.OutboxMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.posts[id]; !ok {
+		return repository.ErrNotFound
+	}
+	if err := m.checkOutbox(events); err != nil {
+		return err
+	}
+
+	// Se imita el ON DELETE CASCADE de los comentarios y las reacciones
+	delete(m.posts, id)
+	for commentId, comment := range m.comments {
+		if comment.PostId == id {
+			delete(m.comments, commentId)
+		}
+	}
+	for key := range m.reactions {
+		if key.postId == id {
+			delete(m.reactions, key)
+		}
+	}
+	delete(m.reactionCount, id)
+	m.insertOutbox(events)
+	return nil
+}
+
+func (m *MemoryRepository) ListPosts(ctx context.Context, filter models.PostFilter, after *models.Cursor, limit int) ([]*models.Post, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ascending := filter.Order == models.SortAsc
+
+	var all []*models.Post
+	for _, post := range m.posts {
+		if filter.UserId != "" && post.UserID != filter.UserId {
+			continue
+		}
+		if filter.Since != nil && post.CreatedAt.Before(*filter.Since) {
+			continue
+		}
+		if filter.Until != nil && !post.CreatedAt.Before(*filter.Until) {
+			continue
+		}
+		if after != nil && !ascending && !before(post.CreatedAt, post.Id, after) {
+			continue
+		}
+		if after != nil && ascending && !newer(post.CreatedAt, post.Id, after.CreatedAt, after.Id) {
+			continue
+		}
+		all = append(all, post)
+	}
+
+	// Los mapas no tienen orden, se ordena por (created_at, id) igual que en PostgresSQL
+	sort.Slice(all, func(i, j int) bool {
+		if ascending {
+			return newer(all[j].CreatedAt, all[j].Id, all[i].CreatedAt, all[i].Id)
+		}
+		return newer(all[i].CreatedAt, all[i].Id, all[j].CreatedAt, all[j].Id)
+	})
+
+	var posts []*models.Post
+	for i := 0; i < limit && i < len(all); i++ {
+		clone := *all[i]
+		clone.Reactions = m.counts(clone.Id)
+		posts = append(posts, &clone)
+	}
+
+	return posts, nil
+}
+
+// Copia de los totales de reacciones de un post, debe llamarse con el mutex tomado
+func (m *MemoryRepository) counts(postId string) models.ReactionCounts {
+	return cloneCounts(m.reactionCount[postId])
+}
+
+func cloneCounts(counts models.ReactionCounts) models.ReactionCounts {
+	clone := models.ReactionCounts{}
+	for kind, count := range counts {
+		clone[kind] = count
+	}
+	return clone
+}
+
+func (m *MemoryRepository) SetReaction(ctx context.Context, postId string, userId string, reaction models.ReactionKind, event repository.ReactionEvent) (models.ReactionCounts, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Se imita la llave foranea hacia users, quitar una reaccion no la necesita
+	if _, ok := m.users[userId]; !ok {
+		return nil, repository.ErrNotFound
+	}
+	return m.changeReaction(postId, userId, reaction, event)
+}
+
+func (m *MemoryRepository) DeleteReaction(ctx context.Context, postId string, userId string, event repository.ReactionEvent) (models.ReactionCounts, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.changeReaction(postId, userId, "", event)
+}
+
+// Cambia la reaccion del usuario, una reaccion vacia la quita. Debe llamarse con el mutex tomado
+func (m *MemoryRepository) changeReaction(postId string, userId string, reaction models.ReactionKind, event repository.ReactionEvent) (models.ReactionCounts, error) {
+	if _, ok := m.posts[postId]; !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	key := reactionKey{postId: postId, userId: userId}
+	previous := m.reactions[key]
+	if previous == reaction {
+		return m.counts(postId), nil
+	}
+
+	// Los totales se calculan antes de modificar nada, si el evento falla no se guarda el cambio
+	counts := m.counts(postId)
+	if previous != "" {
+		counts[previous]--
+		if counts[previous] == 0 {
+			delete(counts, previous)
+		}
+	}
+	if reaction != "" {
+		counts[reaction]++
+	}
+
+	message, err := event(cloneCounts(counts))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkOutbox([]*models.OutboxMessage{message}); err != nil {
+		return nil, err
+	}
+
+	if reaction == "" {
+		delete(m.reactions, key)
+	} else {
+		m.reactions[key] = reaction
+	}
+	m.reactionCount[postId] = counts
+	m.insertOutbox([]*models.OutboxMessage{message})
+	return m.counts(postId), nil
+}
+
+func (m *MemoryRepository) Follow(ctx context.Context, follow *models.Follow) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Se imitan las llaves foraneas hacia users y el CHECK que impide seguirse a si mismo
+	if _, ok := m.users[follow.FollowerId]; !ok {
+		return repository.ErrNotFound
+	}
+	if _, ok := m.users[follow.FolloweeId]; !ok {
+		return repository.ErrNotFound
+	}
+	if follow.FollowerId == follow.FolloweeId {
+		return repository.ErrConflict
+	}
+
+	key := followKey{followerId: follow.FollowerId, followeeId: follow.FolloweeId}
+	if _, ok := m.follows[key]; ok {
+		return nil
+	}
+
+	clone := *follow
+	if clone.CreatedAt.IsZero() {
+		clone.CreatedAt = time.Now()
+	}
+	m.follows[key] = &clone
+	return nil
+}
+
+func (m *MemoryRepository) Unfollow(ctx context.Context, followerId string, followeeId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.follows, followKey{followerId: followerId, followeeId: followeeId})
+	return nil
+}
+
+func (m *MemoryRepository) ListFollowers(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error) {
+	return m.listFollows(after, limit, func(follow *models.Follow) (string, bool) {
+		return follow.FollowerId, follow.FolloweeId == userId
+	})
+}
+
+func (m *MemoryRepository) ListFollowing(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error) {
+	return m.listFollows(after, limit, func(follow *models.Follow) (string, bool) {
+		return follow.FolloweeId, follow.FollowerId == userId
+	})
+}
+
+// Lista las relaciones que cumplen match, ordenadas por fecha y por el id del otro usuario
+func (m *MemoryRepository) listFollows(after *models.Cursor, limit int, match func(follow *models.Follow) (string, bool)) ([]*models.Follow, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var all []*models.Follow
+	for _, follow := range m.follows {
+		if id, ok := match(follow); ok && before(follow.CreatedAt, id, after) {
+			all = append(all, follow)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		idI, _ := match(all[i])
+		idJ, _ := match(all[j])
+		return newer(all[i].CreatedAt, idI, all[j].CreatedAt, idJ)
+	})
+
+	var follows []*models.Follow
+	for i := 0; i < limit && i < len(all); i++ {
+		clone := *all[i]
+		follows = append(follows, &clone)
+	}
+	return follows, nil
+}
+
+func (m *MemoryRepository) ListFeed(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Post, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var all []*models.Post
+	for _, post := range m.posts {
+		if _, ok := m.follows[followKey{followerId: userId, followeeId: post.UserID}]; ok && before(post.CreatedAt, post.Id, after) {
+			all = append(all, post)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return newer(all[i].CreatedAt, all[i].Id, all[j].CreatedAt, all[j].Id)
+	})
+
+	var posts []*models.Post
+	for i := 0; i < limit && i < len(all); i++ {
+		clone := *all[i]
+		clone.Reactions = m.counts(clone.Id)
+		posts = append(posts, &clone)
+	}
+	return posts, nil
+}
+
+// Indica si (a, idA) va antes que (b, idB) en un listado del mas reciente al mas antiguo
+func newer(a time.Time, idA string, b time.Time, idB string) bool {
+	if a.Equal(b) {
+		return idA > idB
+	}
+	return a.After(b)
+}
+
+// Indica si el elemento va despues del cursor en un listado del mas reciente al mas antiguo
+// Sin cursor se incluyen todos los elementos
+func before(createdAt time.Time, id string, after *models.Cursor) bool {
+	return after == nil || newer(after.CreatedAt, after.Id, createdAt, id)
+}
+
+func cloneComment(comment *models.Comment) *models.Comment {
+	clone := *comment
+	clone.UpdatedAt = cloneTime(comment.UpdatedAt)
+	return &clone
+}
+
+func (m *MemoryRepository) InsertComment(ctx context.Context, comment *models.Comment, events ...*models.OutboxMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.comments[comment.Id]; ok {
+		return repository.ErrConflict
+	}
+	// Se imitan las llaves foraneas hacia posts y users
+	if _, ok := m.posts[comment.PostId]; !ok {
+		return repository.ErrNotFound
+	}
+	if _, ok := m.users[comment.UserId]; !ok {
+		return repository.ErrNotFound
+	}
+	if err := m.checkOutbox(events); err != nil {
+		return err
+	}
+
+	clone := cloneComment(comment)
+	if clone.CreatedAt.IsZero() {
+		clone.CreatedAt = time.Now()
+	}
+	m.comments[comment.Id] = clone
+	m.insertOutbox(events)
+	return nil
+}
+
+func (m *MemoryRepository) GetCommentById(ctx context.Context, id string) (*models.Comment, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	comment, ok := m.comments[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	return cloneComment(comment), nil
+}
+
+func (m *MemoryRepository) UpdateComment(ctx context.Context, comment *models.Comment, events ...*models.OutboxMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.comments[comment.Id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if err := m.checkOutbox(events); err != nil {
+		return err
+	}
+
+	stored.Content = comment.Content
+	stored.UpdatedAt = cloneTime(comment.UpdatedAt)
+	m.insertOutbox(events)
+	return nil
+}
+
+func (m *MemoryRepository) DeleteComment(ctx context.Context, id string, events ...*models.OutboxMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.comments[id]; !ok {
+		return repository.ErrNotFound
+	}
+	if err := m.checkOutbox(events); err != nil {
+		return err
+	}
+
+	delete(m.comments, id)
+	m.insertOutbox(events)
+	return nil
+}
+
+func (m *MemoryRepository) ListComments(ctx context.Context, postId string, after *models.Cursor, limit int) ([]*models.Comment, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	// Los comentarios van del mas antiguo al mas reciente, el siguiente es el mas reciente que el cursor
+	var all []*models.Comment
+	for _, comment := range m.comments {
+		if comment.PostId == postId && (after == nil || newer(comment.CreatedAt, comment.Id, after.CreatedAt, after.Id)) {
+			all = append(all, comment)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return newer(all[j].CreatedAt, all[j].Id, all[i].CreatedAt, all[i].Id)
+	})
+
+	var comments []*models.Comment
+	for i := 0; i < limit && i < len(all); i++ {
+		comments = append(comments, cloneComment(all[i]))
+	}
+
+	return comments, nil
+}
+
+func (m *MemoryRepository) InsertRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.insertRefreshToken(token)
+}
+
+// Debe llamarse con el mutex tomado
+func (m *MemoryRepository) insertRefreshToken(token *models.RefreshToken) error {
+	if _, ok := m.refreshTokens[token.Id]; ok {
+		return repository.ErrConflict
+	}
+	for _, stored := range m.refreshTokens {
+		if stored.TokenHash == token.TokenHash {
+			return repository.ErrConflict
+		}
+	}
+
+	clone := *token
+	clone.CreatedAt = time.Now()
+	clone.RevokedAt = nil
+	clone.ReplacedBy = ""
+	m.refreshTokens[token.Id] = &clone
+	return nil
+}
+
+func (m *MemoryRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, token := range m.refreshTokens {
+		if token.TokenHash == hash {
+			clone := *token
+			return &clone, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+func (m *MemoryRepository) RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	old, ok := m.refreshTokens[oldId]
+	if !ok || old.RevokedAt != nil {
+		return repository.ErrConflict
+	}
+
+	if err := m.insertRefreshToken(next); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	old.RevokedAt = &now
+	old.ReplacedBy = next.Id
+	return nil
+}
+
+func (m *MemoryRepository) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for _, token := range m.refreshTokens {
+		if token.FamilyId == familyId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.revokedTokens[jti]; !ok {
+		m.revokedTokens[jti] = expiresAt
+	}
+	return nil
+}
+
+func (m *MemoryRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	expiresAt, ok := m.revokedTokens[jti]
+	return ok && expiresAt.After(time.Now()), nil
+}
+
+func (m *MemoryRepository) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	attempt, ok := m.loginAttempts[key]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	clone := *attempt
+	return &clone, nil
+}
+
+// El chequeo y el conteo se hacen con el mutex tomado, check no debe usar el repositorio
+func (m *MemoryRepository) ReserveLoginAttempt(ctx context.Context, keys []string, at time.Time, since time.Time, check repository.LoginAttemptCheck) ([]*models.LoginAttempt, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	current := make([]*models.LoginAttempt, len(keys))
+	for i, key := range keys {
+		if attempt, ok := m.loginAttempts[key]; ok && attempt.Failures > 0 && !attempt.LastFailedAt.Before(since) {
+			clone := *attempt
+			current[i] = &clone
+		}
+	}
+
+	if err := check(current); err != nil {
+		return nil, err
+	}
+
+	reserved := make([]*models.LoginAttempt, len(keys))
+	for i, key := range keys {
+		attempt := &models.LoginAttempt{Key: key, Failures: 1, LastFailedAt: at.UTC()}
+		if current[i] != nil {
+			attempt.Failures = current[i].Failures + 1
+		}
+		m.loginAttempts[key] = attempt
+
+		clone := *attempt
+		reserved[i] = &clone
+	}
+
+	return reserved, nil
+}
+
+func (m *MemoryRepository) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if attempt, ok := m.loginAttempts[key]; ok && attempt.Failures > 0 {
+		attempt.Failures--
+	}
+	return nil
+}
+
+func (m *MemoryRepository) ResetLoginAttempt(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.loginAttempts, key)
+	return nil
+}
+
+func (m *MemoryRepository) InsertLoginAudit(ctx context.Context, audit *models.LoginAudit) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, stored := range m.loginAudit {
+		if stored.Id == audit.Id {
+			return repository.ErrConflict
+		}
+	}
+
+	clone := *audit
+	if clone.CreatedAt.IsZero() {
+		clone.CreatedAt = time.Now()
+	}
+	m.loginAudit = append(m.loginAudit, &clone)
+	return nil
+}
+
+// Los registros mas recientes primero
+func (m *MemoryRepository) ListLoginAudit(ctx context.Context, page uint64) ([]*models.LoginAudit, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var audits []*models.LoginAudit
+
+	start := page * 10
+	for i := start; i < start+10 && i < uint64(len(m.loginAudit)); i++ {
+		clone := *m.loginAudit[uint64(len(m.loginAudit))-1-i]
+		audits = append(audits, &clone)
+	}
+
+	return audits, nil
+}
+
+func (m *MemoryRepository) InsertPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.resetTokens[token.Id]; ok {
+		return repository.ErrConflict
+	}
+	for _, stored := range m.resetTokens {
+		if stored.TokenHash == token.TokenHash {
+			return repository.ErrConflict
+		}
+	}
+
+	clone := *token
+	clone.UsedAt = cloneTime(token.UsedAt)
+	if clone.CreatedAt.IsZero() {
+		clone.CreatedAt = time.Now()
+	}
+	m.resetTokens[token.Id] = &clone
+	return nil
+}
+
+func (m *MemoryRepository) ResetPassword(ctx context.Context, tokenHash string, password string, at time.Time) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var token *models.PasswordResetToken
+	for _, stored := range m.resetTokens {
+		if stored.TokenHash == tokenHash {
+			token = stored
+			break
+		}
+	}
+	if token == nil || token.UsedAt != nil || !token.ExpiresAt.After(at) {
+		return "", repository.ErrNotFound
+	}
+
+	user, ok := m.users[token.UserId]
+	if !ok {
+		return "", repository.ErrNotFound
+	}
+
+	at = at.UTC()
+	for _, stored := range m.resetTokens {
+		if stored.UserId == user.Id && stored.UsedAt == nil {
+			stored.UsedAt = cloneTime(&at)
+		}
+	}
+	for _, stored := range m.refreshTokens {
+		if stored.UserId == user.Id && stored.RevokedAt == nil {
+			stored.RevokedAt = cloneTime(&at)
+		}
+	}
+	user.Password = password
+	user.PasswordChangedAt = cloneTime(&at)
+
+	return user.Id, nil
+}
+
+// Verifica que los eventos se puedan guardar antes de modificar nada, asi el cambio y sus eventos
+// se guardan juntos o no se guarda ninguno. Debe llamarse con el mutex tomado
+func (m *MemoryRepository) checkOutbox(events []*models.OutboxMessage) error {
+	ids := map[string]bool{}
+	for _, event := range events {
+		if _, ok := m.outbox[event.Id]; ok || ids[event.Id] {
+			return repository.ErrConflict
+		}
+		ids[event.Id] = true
+	}
+	return nil
+}
+
+// Debe llamarse con el mutex tomado y despues de checkOutbox
+func (m *MemoryRepository) insertOutbox(events []*models.OutboxMessage) {
+	now := time.Now()
+	for _, event := range events {
+		clone := cloneOutboxMessage(event)
+		// Se imitan los valores por defecto de las columnas
+		if clone.CreatedAt.IsZero() {
+			clone.CreatedAt = now
+		}
+		if clone.NextAttemptAt.IsZero() {
+			clone.NextAttemptAt = now
+		}
+		clone.Attempts = 0
+		clone.LastError = ""
+		clone.PublishedAt = nil
+		m.outbox[event.Id] = clone
+		m.outboxOrder = append(m.outboxOrder, event.Id)
+	}
+}
+
+// Copia profunda, los eventos tienen slices que el llamador podria modificar
+func cloneOutboxMessage(event *models.OutboxMessage) *models.OutboxMessage {
+	clone := *event
+	clone.Topics = append([]string(nil), event.Topics...)
+	clone.Event = append([]byte(nil), event.Event...)
+	if event.PublishedAt != nil {
+		publishedAt := *event.PublishedAt
+		clone.PublishedAt = &publishedAt
+	}
+	return &clone
+}
+
+func (m *MemoryRepository) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxMessage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	var events []*models.OutboxMessage
+
+	for _, id := range m.outboxOrder {
+		if len(events) >= limit {
+			break
+		}
+		event, ok := m.outbox[id]
+		if !ok || event.PublishedAt != nil || event.NextAttemptAt.After(now) {
+			continue
+		}
+		event.NextAttemptAt = now.Add(lease)
+		events = append(events, cloneOutboxMessage(event))
+	}
+
+	return events, nil
+}
+
+func (m *MemoryRepository) MarkOutboxPublished(ctx context.Context, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	event, ok := m.outbox[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	now := time.Now()
+	event.PublishedAt = &now
+	return nil
+}
+
+func (m *MemoryRepository) MarkOutboxFailed(ctx context.Context, id string, reason string, retryAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	event, ok := m.outbox[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	event.Attempts++
+	event.LastError = reason
+	event.NextAttemptAt = retryAt
+	return nil
+}
+
+func (m *MemoryRepository) DeletePublishedOutboxMessages(ctx context.Context, before time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	order := m.outboxOrder[:0]
+	for _, id := range m.outboxOrder {
+		event := m.outbox[id]
+		if event.PublishedAt != nil && event.PublishedAt.Before(before) {
+			delete(m.outbox, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	m.outboxOrder = order
+	return nil
+}
+
+func (m *MemoryRepository) Close() error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Las versiones deben ser consecutivas para que el orden sea evidente
+	for i, migration := range migrations {
+		if migration.Version != uint64(i+1) {
+			t.Errorf("migration %s has version %d, expected %d", migration.Name, migration.Version, i+1)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tables := []struct {
+		name  string
+		files fstest.MapFS
+		valid bool
+	}{
+		{"ordered", fstest.MapFS{
+			"m/0002_b.up.sql":   {Data: []byte("B")},
+			"m/0002_b.down.sql": {Data: []byte("b")},
+			"m/0001_a.up.sql":   {Data: []byte("A")},
+			"m/0001_a.down.sql": {Data: []byte("a")},
+		}, true},
+		{"missing down", fstest.MapFS{
+			"m/0001_a.up.sql": {Data: []byte("A")},
+		}, false},
+		{"bad suffix", fstest.MapFS{
+			"m/0001_a.sql": {Data: []byte("A")},
+		}, false},
+		{"bad version", fstest.MapFS{
+			"m/first_a.up.sql":   {Data: []byte("A")},
+			"m/first_a.down.sql": {Data: []byte("a")},
+		}, false},
+		{"conflicting names", fstest.MapFS{
+			"m/0001_a.up.sql":   {Data: []byte("A")},
+			"m/0001_b.down.sql": {Data: []byte("b")},
+		}, false},
+	}
+
+	for _, item := range tables {
+		migrations, err := loadMigrations(item.files, "m")
+		if item.valid != (err == nil) {
+			t.Errorf("%s: got error %v, expected valid %t", item.name, err, item.valid)
+			continue
+		}
+		if item.valid && (migrations[0].Up != "A" || migrations[1].Down != "b") {
+			t.Errorf("%s: migrations were loaded out of order: %+v", item.name, migrations)
+		}
+	}
+}
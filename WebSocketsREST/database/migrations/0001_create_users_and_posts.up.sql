@@ -1,19 +1,17 @@
-DROP TABLE IF EXISTS users;
-
-CREATE TABLE users (
+-- Esquema inicial, equivalente al antiguo init.sql
+-- Se utiliza IF NOT EXISTS para adoptar bases de datos creadas con init.sql sin perder datos
+CREATE TABLE IF NOT EXISTS users (
   id VARCHAR(32) PRIMARY KEY,
   password varchar(255) NOT NULL,
   email varchar(255) NOT NULL,
   created_at timestamp NOT NULL DEFAULT NOW()
-); 
-
-DROP TABLE IF EXISTS posts;
+);
 
-CREATE TABLE posts (
+CREATE TABLE IF NOT EXISTS posts (
   id VARCHAR(32) PRIMARY KEY,
   title varchar(255) NOT NULL,
   content text NOT NULL,
   created_at timestamp NOT NULL DEFAULT NOW(),
   user_id VARCHAR(32) NOT NULL,
   FOREIGN KEY (user_id) REFERENCES users(id)
-);
\ No newline at end of file
+);
@@ -0,0 +1 @@
+ALTER TABLE posts ADD COLUMN title varchar(255) NOT NULL DEFAULT '';
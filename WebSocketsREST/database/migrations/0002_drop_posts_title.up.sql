@@ -0,0 +1,3 @@
+-- models.Post no tiene titulo y PostgresRepository.InsertPost nunca lo escribe,
+-- por lo que la restriccion NOT NULL hacia fallar todas las inserciones
+ALTER TABLE posts DROP COLUMN IF EXISTS title;
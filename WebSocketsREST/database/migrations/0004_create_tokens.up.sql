@@ -0,0 +1,19 @@
+CREATE TABLE refresh_tokens (
+  id VARCHAR(32) PRIMARY KEY,
+  user_id VARCHAR(32) NOT NULL,
+  family_id VARCHAR(32) NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  expires_at timestamp NOT NULL,
+  created_at timestamp NOT NULL DEFAULT NOW(),
+  revoked_at timestamp,
+  replaced_by VARCHAR(32),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- Lista de access tokens revocados (jti) hasta que expiran
+CREATE TABLE revoked_tokens (
+  jti VARCHAR(32) PRIMARY KEY,
+  expires_at timestamp NOT NULL
+);
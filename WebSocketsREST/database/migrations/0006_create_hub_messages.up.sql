@@ -0,0 +1,8 @@
+-- Mensajes del hub que no caben en un NOTIFY, el NOTIFY solo lleva su id
+CREATE TABLE hub_messages (
+  id VARCHAR(32) PRIMARY KEY,
+  data TEXT NOT NULL,
+  created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX hub_messages_created_at_idx ON hub_messages (created_at);
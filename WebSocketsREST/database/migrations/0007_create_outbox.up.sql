@@ -0,0 +1,13 @@
+-- Eventos pendientes de publicar, se escriben en la misma transaccion que el cambio que los genera
+CREATE TABLE outbox (
+  id VARCHAR(32) PRIMARY KEY,
+  topics TEXT[] NOT NULL,
+  event TEXT NOT NULL,
+  created_at timestamp NOT NULL DEFAULT NOW(),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  next_attempt_at timestamp NOT NULL DEFAULT NOW(),
+  published_at timestamp
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (next_attempt_at) WHERE published_at IS NULL;
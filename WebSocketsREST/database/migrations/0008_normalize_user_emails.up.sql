@@ -0,0 +1,7 @@
+-- Los emails se guardan sin espacios y en minusculas, asi "Ana@Example.com" y "ana@example.com" son la misma cuenta
+-- Si dos cuentas solo difieren en mayusculas no se modifican y la creacion del indice falla, se deben unir a mano
+UPDATE users SET email = LOWER(TRIM(email))
+WHERE email <> LOWER(TRIM(email))
+  AND NOT EXISTS (SELECT 1 FROM users other WHERE other.id <> users.id AND LOWER(TRIM(other.email)) = LOWER(TRIM(users.email)));
+
+CREATE UNIQUE INDEX users_email_lower_key ON users (LOWER(email));
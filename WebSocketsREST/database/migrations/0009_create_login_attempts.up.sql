@@ -0,0 +1,19 @@
+-- Intentos fallidos de login por cuenta ("email:<email>") y por IP ("ip:<ip>")
+CREATE TABLE login_attempts (
+  key VARCHAR(320) PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  last_failed_at timestamp NOT NULL
+);
+
+-- Auditoria de logins sospechosos: bloqueos, intentos durante un bloqueo y logins correctos despues de varios fallos
+CREATE TABLE login_audit (
+  id VARCHAR(32) PRIMARY KEY,
+  email VARCHAR(255) NOT NULL,
+  user_id VARCHAR(32) NOT NULL DEFAULT '',
+  ip VARCHAR(64) NOT NULL,
+  reason VARCHAR(32) NOT NULL,
+  failures INTEGER NOT NULL,
+  created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX login_audit_created_at_idx ON login_audit (created_at);
@@ -0,0 +1,5 @@
+-- Fecha en la que el usuario verifico su email, NULL mientras no lo verifique
+ALTER TABLE users ADD COLUMN verified_at timestamp;
+
+-- Las cuentas anteriores a la verificacion se consideran verificadas
+UPDATE users SET verified_at = created_at;
@@ -0,0 +1,15 @@
+-- Tokens de un solo uso para restablecer la contraseña, solo se guarda el hash
+CREATE TABLE password_reset_tokens (
+  id VARCHAR(32) PRIMARY KEY,
+  user_id VARCHAR(32) NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  expires_at timestamp NOT NULL,
+  created_at timestamp NOT NULL DEFAULT NOW(),
+  used_at timestamp,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
+
+-- Los access tokens emitidos antes del ultimo cambio de contraseña se rechazan
+ALTER TABLE users ADD COLUMN password_changed_at timestamp;
@@ -0,0 +1,14 @@
+-- Comentarios de los posts, se borran junto con el post
+CREATE TABLE comments (
+  id VARCHAR(32) PRIMARY KEY,
+  post_id VARCHAR(32) NOT NULL,
+  user_id VARCHAR(32) NOT NULL,
+  content text NOT NULL,
+  created_at timestamp NOT NULL DEFAULT NOW(),
+  updated_at timestamp,
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Los comentarios siempre se listan por post en orden de creacion
+CREATE INDEX comments_post_id_created_at_idx ON comments (post_id, created_at, id);
@@ -0,0 +1,19 @@
+-- Reaccion de cada usuario a cada post, la llave primaria impide reaccionar dos veces al mismo post
+CREATE TABLE reactions (
+  post_id VARCHAR(32) NOT NULL,
+  user_id VARCHAR(32) NOT NULL,
+  reaction VARCHAR(16) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (post_id, user_id),
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Totales por post y tipo de reaccion, se actualizan en la misma transaccion que reactions
+CREATE TABLE post_reaction_counts (
+  post_id VARCHAR(32) NOT NULL,
+  reaction VARCHAR(16) NOT NULL,
+  count INTEGER NOT NULL CHECK (count >= 0),
+  PRIMARY KEY (post_id, reaction),
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
@@ -0,0 +1,17 @@
+-- El usuario follower_id sigue al usuario followee_id
+CREATE TABLE follows (
+  follower_id VARCHAR(32) NOT NULL,
+  followee_id VARCHAR(32) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (follower_id, followee_id),
+  CHECK (follower_id <> followee_id),
+  FOREIGN KEY (follower_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (followee_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Listados de seguidores y seguidos del mas reciente al mas antiguo
+CREATE INDEX follows_followee_created_at_idx ON follows (followee_id, created_at DESC, follower_id DESC);
+CREATE INDEX follows_follower_created_at_idx ON follows (follower_id, created_at DESC, followee_id DESC);
+
+-- El feed busca los posts mas recientes de cada usuario seguido
+CREATE INDEX posts_user_id_created_at_idx ON posts (user_id, created_at DESC, id DESC);
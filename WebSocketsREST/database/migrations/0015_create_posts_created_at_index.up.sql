@@ -0,0 +1,3 @@
+-- El listado de posts pagina por (created_at, id) en los dos sentidos, el indice se puede recorrer al reves
+-- Los listados filtrados por autor usan posts_user_id_created_at_idx
+CREATE INDEX posts_created_at_idx ON posts (created_at DESC, id DESC);
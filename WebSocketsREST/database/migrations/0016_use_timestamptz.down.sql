@@ -0,0 +1,40 @@
+-- Se vuelve a timestamp guardando los valores en UTC
+ALTER TABLE users
+  ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN verified_at TYPE timestamp USING verified_at AT TIME ZONE 'UTC',
+  ALTER COLUMN password_changed_at TYPE timestamp USING password_changed_at AT TIME ZONE 'UTC';
+
+ALTER TABLE posts ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE refresh_tokens
+  ALTER COLUMN expires_at TYPE timestamp USING expires_at AT TIME ZONE 'UTC',
+  ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN revoked_at TYPE timestamp USING revoked_at AT TIME ZONE 'UTC';
+
+ALTER TABLE revoked_tokens ALTER COLUMN expires_at TYPE timestamp USING expires_at AT TIME ZONE 'UTC';
+
+ALTER TABLE hub_messages ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE outbox
+  ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN next_attempt_at TYPE timestamp USING next_attempt_at AT TIME ZONE 'UTC',
+  ALTER COLUMN published_at TYPE timestamp USING published_at AT TIME ZONE 'UTC';
+
+ALTER TABLE login_attempts ALTER COLUMN last_failed_at TYPE timestamp USING last_failed_at AT TIME ZONE 'UTC';
+
+ALTER TABLE login_audit ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE password_reset_tokens
+  ALTER COLUMN expires_at TYPE timestamp USING expires_at AT TIME ZONE 'UTC',
+  ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN used_at TYPE timestamp USING used_at AT TIME ZONE 'UTC';
+
+ALTER TABLE comments
+  ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN updated_at TYPE timestamp USING updated_at AT TIME ZONE 'UTC';
+
+ALTER TABLE reactions ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE follows ALTER COLUMN created_at TYPE timestamp USING created_at AT TIME ZONE 'UTC';
+
+CREATE UNIQUE INDEX users_email_key ON users (email);
@@ -0,0 +1,42 @@
+-- users_email_lower_key (0008) ya garantiza emails unicos, el indice de 0003 sobra
+DROP INDEX IF EXISTS users_email_key;
+
+-- Las columnas pasan a timestamptz para que iat y las expiraciones no dependan de la zona horaria del servidor
+-- La aplicacion escribe en UTC y la imagen de postgres corre en UTC, los valores guardados se interpretan en UTC
+ALTER TABLE users
+  ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN verified_at TYPE timestamptz USING verified_at AT TIME ZONE 'UTC',
+  ALTER COLUMN password_changed_at TYPE timestamptz USING password_changed_at AT TIME ZONE 'UTC';
+
+ALTER TABLE posts ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE refresh_tokens
+  ALTER COLUMN expires_at TYPE timestamptz USING expires_at AT TIME ZONE 'UTC',
+  ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN revoked_at TYPE timestamptz USING revoked_at AT TIME ZONE 'UTC';
+
+ALTER TABLE revoked_tokens ALTER COLUMN expires_at TYPE timestamptz USING expires_at AT TIME ZONE 'UTC';
+
+ALTER TABLE hub_messages ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE outbox
+  ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN next_attempt_at TYPE timestamptz USING next_attempt_at AT TIME ZONE 'UTC',
+  ALTER COLUMN published_at TYPE timestamptz USING published_at AT TIME ZONE 'UTC';
+
+ALTER TABLE login_attempts ALTER COLUMN last_failed_at TYPE timestamptz USING last_failed_at AT TIME ZONE 'UTC';
+
+ALTER TABLE login_audit ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE password_reset_tokens
+  ALTER COLUMN expires_at TYPE timestamptz USING expires_at AT TIME ZONE 'UTC',
+  ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN used_at TYPE timestamptz USING used_at AT TIME ZONE 'UTC';
+
+ALTER TABLE comments
+  ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+  ALTER COLUMN updated_at TYPE timestamptz USING updated_at AT TIME ZONE 'UTC';
+
+ALTER TABLE reactions ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE follows ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC';
@@ -9,10 +9,21 @@ package database
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
 	"rest_ws/models"
+	"rest_ws/repository"
+	"sort"
+	"strconv"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+)
+
+// Codigos de error de PostgresSQL para las violaciones de restricciones de unicidad, de llaves foraneas y CHECK
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+	checkViolation      = "23514"
 )
 
 type PostgresRepository struct {
@@ -27,127 +38,787 @@ func NewPostgresRepository(url string) (*PostgresRepository, error) {
 	return &PostgresRepository{db: db}, nil
 }
 
-func (p *PostgresRepository) InsertUser(ctx context.Context, user *models.User) error {
-	_, err := p.db.ExecContext(ctx, "INSERT INTO users (id, email, password) VALUES ($1, $2, $3)",
-		user.Id, user.Email, user.Password)
+// Devuelve el encargado de aplicar las migraciones sobre esta base de datos
+func (p *PostgresRepository) Migrator() (*Migrator, error) {
+	return NewMigrator(p.db)
+}
+
+// Traduce los errores de PostgresSQL a los errores generales del repositorio
+func translateError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return repository.ErrConflict
+	}
+	// Los datos no cumplen una restriccion CHECK, por ejemplo un usuario que se sigue a si mismo
+	if errors.As(err, &pqErr) && pqErr.Code == checkViolation {
+		return repository.ErrConflict
+	}
+	// El registro al que se hace referencia no existe, por ejemplo un comentario de un post borrado
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return repository.ErrNotFound
+	}
+
 	return err
 }
 
-func (p *PostgresRepository) FindUserById(ctx context.Context, id string) (*models.User, error) {
-	rows, err := p.db.QueryContext(ctx, "SELECT id, email FROM users WHERE id = $1", id)
+// Verifica que una sentencia haya modificado al menos una fila
+func checkAffected(result sql.Result, err error) error {
+	if err != nil {
+		return translateError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
 
-	defer func() {
-		err = rows.Close()
+// Ejecuta fn dentro de una transaccion y guarda los eventos del outbox en la misma transaccion
+// Si fn o alguno de los eventos falla no se guarda nada
+func (p *PostgresRepository) withOutbox(ctx context.Context, events []*models.OutboxMessage, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := insertOutbox(ctx, tx, events); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Guarda los eventos del outbox dentro de una transaccion ya iniciada
+func insertOutbox(ctx context.Context, tx *sql.Tx, events []*models.OutboxMessage) error {
+	for _, event := range events {
+		// pq guarda un slice nil como NULL, un evento sin topicos se guarda con un arreglo vacio
+		topics := event.Topics
+		if topics == nil {
+			topics = []string{}
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO outbox (id, topics, event, created_at, next_attempt_at) VALUES ($1, $2, $3, $4, $5)",
+			event.Id, pq.Array(topics), string(event.Event), event.CreatedAt.UTC(), event.NextAttemptAt.UTC())
 		if err != nil {
-			log.Fatal(err)
+			return translateError(err)
 		}
-	}()
+	}
+	return nil
+}
+
+func (p *PostgresRepository) InsertUser(ctx context.Context, user *models.User, events ...*models.OutboxMessage) error {
+	role := user.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+
+	return p.withOutbox(ctx, events, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO users (id, email, password, role, verified_at) VALUES ($1, $2, $3, $4, $5)",
+			user.Id, user.Email, user.Password, role, user.VerifiedAt)
+		return translateError(err)
+	})
+}
+
+func (p *PostgresRepository) FindUserById(ctx context.Context, id string) (*models.User, error) {
+	var user = models.User{}
+
+	err := p.db.QueryRowContext(ctx, "SELECT id, email, role, verified_at, password_changed_at FROM users WHERE id = $1", id).
+		Scan(&user.Id, &user.Email, &user.Role, &user.VerifiedAt, &user.PasswordChangedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &user, nil
+}
 
+func (p *PostgresRepository) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user = models.User{}
 
+	err := p.db.QueryRowContext(ctx, "SELECT id, email, password, role, verified_at, password_changed_at FROM users WHERE email = $1", email).
+		Scan(&user.Id, &user.Email, &user.Password, &user.Role, &user.VerifiedAt, &user.PasswordChangedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &user, nil
+}
+
+func (p *PostgresRepository) UpdateUserRole(ctx context.Context, id string, role models.Role) error {
+	return checkAffected(p.db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, id))
+}
+
+func (p *PostgresRepository) VerifyUserEmail(ctx context.Context, id string, at time.Time) error {
+	return checkAffected(p.db.ExecContext(ctx, "UPDATE users SET verified_at = COALESCE(verified_at, $1) WHERE id = $2", at.UTC(), id))
+}
+
+func (p *PostgresRepository) ListUsers(ctx context.Context, page uint64) ([]*models.User, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT id, email, role, verified_at FROM users ORDER BY created_at, id LIMIT $1 OFFSET $2", 10, page*10)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+
 	for rows.Next() {
-		if err = rows.Scan(&user.Id, &user.Email); err == nil {
-			return &user, nil
+		var user = models.User{}
+		if err = rows.Scan(&user.Id, &user.Email, &user.Role, &user.VerifiedAt); err != nil {
+			return nil, err
 		}
+		users = append(users, &user)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	return users, nil
 }
 
-func (p *PostgresRepository) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	rows, err := p.db.QueryContext(ctx, "SELECT id, email, password FROM users WHERE email = $1", email)
+func (p *PostgresRepository) InsertPost(ctx context.Context, post *models.Post, events ...*models.OutboxMessage) error {
+	return p.withOutbox(ctx, events, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO posts (id, content, created_at, user_id) VALUES ($1, $2, $3, $4)",
+			post.Id, post.Content, post.CreatedAt, post.UserID)
+		return translateError(err)
+	})
+}
 
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
+func (p *PostgresRepository) GetPostById(ctx context.Context, id string) (*models.Post, error) {
+	var post = models.Post{}
 
-	var user = models.User{}
+	err := p.db.QueryRowContext(ctx, "SELECT id, content, created_at, user_id FROM posts WHERE id = $1", id).
+		Scan(&post.Id, &post.Content, &post.CreatedAt, &post.UserID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	counts, err := reactionCounts(ctx, p.db, post.Id)
+	if err != nil {
+		return nil, err
+	}
+	post.Reactions = counts[post.Id]
+
+	return &post, nil
+}
+
+func (p *PostgresRepository) UpdatePost(ctx context.Context, post *models.Post, events ...*models.OutboxMessage) error {
+	return p.withOutbox(ctx, events, func(tx *sql.Tx) error {
+		return checkAffected(tx.ExecContext(ctx, "UPDATE posts SET content = $1 WHERE id = $2", post.Content, post.Id))
+	})
+}
+
+func (p *PostgresRepository) DeletePost(ctx context.Context, id string, events ...*models.OutboxMessage) error {
+	return p.withOutbox(ctx, events, func(tx *sql.Tx) error {
+		return checkAffected(tx.ExecContext(ctx, "DELETE FROM posts WHERE id = $1", id))
+	})
+}
+
+func (p *PostgresRepository) ListPosts(ctx context.Context, filter models.PostFilter, after *models.Cursor, limit int) ([]*models.Post, error) {
+	descending := filter.Order != models.SortAsc
+
+	args := []interface{}{}
+	query := "SELECT id, content, created_at, user_id FROM posts WHERE TRUE"
+	if filter.UserId != "" {
+		args = append(args, filter.UserId)
+		query += " AND user_id = $" + strconv.Itoa(len(args))
+	}
+	if filter.Since != nil {
+		args = append(args, filter.Since.UTC())
+		query += " AND created_at >= $" + strconv.Itoa(len(args))
+	}
+	if filter.Until != nil {
+		args = append(args, filter.Until.UTC())
+		query += " AND created_at < $" + strconv.Itoa(len(args))
+	}
+	query += keysetCondition("created_at", "id", after, descending, &args)
+	if descending {
+		query += " ORDER BY created_at DESC, id DESC"
+	} else {
+		query += " ORDER BY created_at, id"
+	}
+	args = append(args, limit)
+	query += " LIMIT $" + strconv.Itoa(len(args))
+
+	return p.queryPosts(ctx, query, args...)
+}
+
+// Ejecuta una consulta que devuelve id, content, created_at y user_id, y agrega los totales de reacciones
+func (p *PostgresRepository) queryPosts(ctx context.Context, query string, args ...interface{}) ([]*models.Post, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []*models.Post
+	var ids []string
 
 	for rows.Next() {
-		if err = rows.Scan(&user.Id, &user.Email, &user.Password); err == nil {
-			return &user, nil
+		var post = models.Post{}
+		if err = rows.Scan(&post.Id, &post.Content, &post.CreatedAt, &post.UserID); err != nil {
+			return nil, err
 		}
+		posts = append(posts, &post)
+		ids = append(ids, post.Id)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	// Los totales de toda la pagina se cargan en una sola consulta
+	counts, err := reactionCounts(ctx, p.db, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for _, post := range posts {
+		post.Reactions = counts[post.Id]
+	}
+
+	return posts, nil
 }
 
-func (p *PostgresRepository) InsertPost(ctx context.Context, post *models.Post) error {
-	_, err := p.db.ExecContext(ctx, "INSERT INTO posts (id, content, created_at, user_id) VALUES ($1, $2, $3, $4)",
-		post.Id, post.Content, post.CreatedAt, post.UserID)
-	return err
+// Permite leer los totales con la conexion o dentro de una transaccion
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
 
-func (p *PostgresRepository) GetPostById(ctx context.Context, id string) (*models.Post, error) {
-	rows, err := p.db.QueryContext(ctx, "SELECT id, content, created_at, user_id FROM posts WHERE id = $1", id)
+// Devuelve los totales de reacciones de los posts indexados por id, cada post tiene un mapa aunque no tenga reacciones
+func reactionCounts(ctx context.Context, db queryer, postIds ...string) (map[string]models.ReactionCounts, error) {
+	result := map[string]models.ReactionCounts{}
+	if len(postIds) == 0 {
+		return result, nil
+	}
+	for _, id := range postIds {
+		result[id] = models.ReactionCounts{}
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT post_id, reaction, count FROM post_reaction_counts WHERE post_id = ANY($1) AND count > 0",
+		pq.Array(postIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId string
+		var reaction models.ReactionKind
+		var count int
+		if err := rows.Scan(&postId, &reaction, &count); err != nil {
+			return nil, err
+		}
+		result[postId][reaction] = count
+	}
 
-	defer func() {
-		err = rows.Close()
+	return result, rows.Err()
+}
+
+func (p *PostgresRepository) SetReaction(ctx context.Context, postId string, userId string, reaction models.ReactionKind, event repository.ReactionEvent) (models.ReactionCounts, error) {
+	return p.changeReaction(ctx, postId, userId, reaction, event)
+}
+
+func (p *PostgresRepository) DeleteReaction(ctx context.Context, postId string, userId string, event repository.ReactionEvent) (models.ReactionCounts, error) {
+	return p.changeReaction(ctx, postId, userId, "", event)
+}
+
+// Cambia la reaccion del usuario, una reaccion vacia la quita
+func (p *PostgresRepository) changeReaction(ctx context.Context, postId string, userId string, reaction models.ReactionKind, event repository.ReactionEvent) (models.ReactionCounts, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Bloquear el post ordena los cambios de reaccion del mismo post, asi dos cambios simultaneos no pueden
+	// leer la misma reaccion anterior. NO KEY UPDATE no bloquea las llaves foraneas de comentarios y reacciones
+	var locked string
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM posts WHERE id = $1 FOR NO KEY UPDATE", postId).Scan(&locked); err != nil {
+		return nil, translateError(err)
+	}
+
+	var previous models.ReactionKind
+	err = tx.QueryRowContext(ctx, "SELECT reaction FROM reactions WHERE post_id = $1 AND user_id = $2", postId, userId).Scan(&previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if previous != reaction {
+		if previous != "" {
+			if _, err := tx.ExecContext(ctx, "UPDATE post_reaction_counts SET count = count - 1 WHERE post_id = $1 AND reaction = $2",
+				postId, previous); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case reaction == "":
+			_, err = tx.ExecContext(ctx, "DELETE FROM reactions WHERE post_id = $1 AND user_id = $2", postId, userId)
+		case previous == "":
+			_, err = tx.ExecContext(ctx, "INSERT INTO reactions (post_id, user_id, reaction) VALUES ($1, $2, $3)", postId, userId, reaction)
+		default:
+			_, err = tx.ExecContext(ctx, "UPDATE reactions SET reaction = $3, created_at = NOW() WHERE post_id = $1 AND user_id = $2", postId, userId, reaction)
+		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, translateError(err)
 		}
-	}()
 
-	var post = models.Post{}
+		if reaction != "" {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO post_reaction_counts (post_id, reaction, count) VALUES ($1, $2, 1)
+				ON CONFLICT (post_id, reaction) DO UPDATE SET count = post_reaction_counts.count + 1`, postId, reaction); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	counts, err := reactionCounts(ctx, tx, postId)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != reaction {
+		message, err := event(counts[postId])
+		if err != nil {
+			return nil, err
+		}
+		if err := insertOutbox(ctx, tx, []*models.OutboxMessage{message}); err != nil {
+			return nil, err
+		}
+	}
+
+	return counts[postId], tx.Commit()
+}
+
+func (p *PostgresRepository) Follow(ctx context.Context, follow *models.Follow) error {
+	createdAt := follow.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	// Seguir dos veces al mismo usuario no es un error
+	_, err := p.db.ExecContext(ctx, `INSERT INTO follows (follower_id, followee_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (follower_id, followee_id) DO NOTHING`, follow.FollowerId, follow.FolloweeId, createdAt.UTC())
+	return translateError(err)
+}
+
+func (p *PostgresRepository) Unfollow(ctx context.Context, followerId string, followeeId string) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2", followerId, followeeId)
+	return err
+}
+
+func (p *PostgresRepository) ListFollowers(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error) {
+	return p.listFollows(ctx, "followee_id", "follower_id", userId, after, limit)
+}
+
+func (p *PostgresRepository) ListFollowing(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error) {
+	return p.listFollows(ctx, "follower_id", "followee_id", userId, after, limit)
+}
+
+// Lista las relaciones donde column es userId, ordenadas por fecha y por el id del otro usuario (otherColumn)
+// Los nombres de las columnas son constantes del codigo, nunca datos del cliente
+func (p *PostgresRepository) listFollows(ctx context.Context, column string, otherColumn string, userId string, after *models.Cursor, limit int) ([]*models.Follow, error) {
+	args := []interface{}{userId}
+	query := "SELECT follower_id, followee_id, created_at FROM follows WHERE " + column + " = $1"
+	query += keysetCondition("created_at", otherColumn, after, true, &args)
+	query += " ORDER BY created_at DESC, " + otherColumn + " DESC"
+	args = append(args, limit)
+	query += " LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var follows []*models.Follow
 
 	for rows.Next() {
-		if err = rows.Scan(&post.Id, &post.Content, &post.CreatedAt, &post.UserID); err == nil {
-			return &post, nil
+		var follow = models.Follow{}
+		if err = rows.Scan(&follow.FollowerId, &follow.FolloweeId, &follow.CreatedAt); err != nil {
+			return nil, err
 		}
+		follows = append(follows, &follow)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return &post, nil
+	return follows, nil
+}
+
+func (p *PostgresRepository) ListFeed(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Post, error) {
+	args := []interface{}{userId}
+	query := `SELECT posts.id, posts.content, posts.created_at, posts.user_id FROM posts
+		JOIN follows ON follows.followee_id = posts.user_id WHERE follows.follower_id = $1`
+	query += keysetCondition("posts.created_at", "posts.id", after, true, &args)
+	query += " ORDER BY posts.created_at DESC, posts.id DESC"
+	args = append(args, limit)
+	query += " LIMIT $" + strconv.Itoa(len(args))
+
+	return p.queryPosts(ctx, query, args...)
+}
+
+// Condicion para continuar un listado ordenado por (fecha, id) despues del cursor
+// Agrega los valores del cursor a args, sin cursor no agrega nada
+func keysetCondition(timeColumn string, idColumn string, after *models.Cursor, descending bool, args *[]interface{}) string {
+	if after == nil {
+		return ""
+	}
+
+	operator := ">"
+	if descending {
+		operator = "<"
+	}
+
+	*args = append(*args, after.CreatedAt.UTC(), after.Id)
+	return " AND (" + timeColumn + ", " + idColumn + ") " + operator + " ($" + strconv.Itoa(len(*args)-1) + ", $" + strconv.Itoa(len(*args)) + ")"
+}
+
+func (p *PostgresRepository) InsertComment(ctx context.Context, comment *models.Comment, events ...*models.OutboxMessage) error {
+	return p.withOutbox(ctx, events, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO comments (id, post_id, user_id, content, created_at) VALUES ($1, $2, $3, $4, $5)",
+			comment.Id, comment.PostId, comment.UserId, comment.Content, comment.CreatedAt)
+		return translateError(err)
+	})
+}
+
+func (p *PostgresRepository) GetCommentById(ctx context.Context, id string) (*models.Comment, error) {
+	var comment = models.Comment{}
+
+	err := p.db.QueryRowContext(ctx, "SELECT id, post_id, user_id, content, created_at, updated_at FROM comments WHERE id = $1", id).
+		Scan(&comment.Id, &comment.PostId, &comment.UserId, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &comment, nil
+}
+
+func (p *PostgresRepository) UpdateComment(ctx context.Context, comment *models.Comment, events ...*models.OutboxMessage) error {
+	return p.withOutbox(ctx, events, func(tx *sql.Tx) error {
+		return checkAffected(tx.ExecContext(ctx, "UPDATE comments SET content = $1, updated_at = $2 WHERE id = $3",
+			comment.Content, comment.UpdatedAt, comment.Id))
+	})
+}
+
+func (p *PostgresRepository) DeleteComment(ctx context.Context, id string, events ...*models.OutboxMessage) error {
+	return p.withOutbox(ctx, events, func(tx *sql.Tx) error {
+		return checkAffected(tx.ExecContext(ctx, "DELETE FROM comments WHERE id = $1", id))
+	})
+}
+
+func (p *PostgresRepository) ListComments(ctx context.Context, postId string, after *models.Cursor, limit int) ([]*models.Comment, error) {
+	args := []interface{}{postId}
+	query := "SELECT id, post_id, user_id, content, created_at, updated_at FROM comments WHERE post_id = $1"
+	query += keysetCondition("created_at", "id", after, false, &args)
+	query += " ORDER BY created_at, id"
+	args = append(args, limit)
+	query += " LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*models.Comment
+
+	for rows.Next() {
+		var comment = models.Comment{}
+		if err = rows.Scan(&comment.Id, &comment.PostId, &comment.UserId, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, &comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+func (p *PostgresRepository) InsertRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	_, err := p.db.ExecContext(ctx, "INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		token.Id, token.UserId, token.FamilyId, token.TokenHash, token.ExpiresAt.UTC())
+	return translateError(err)
+}
+
+func (p *PostgresRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token = models.RefreshToken{}
+	var replacedBy sql.NullString
+
+	err := p.db.QueryRowContext(ctx, "SELECT id, user_id, family_id, token_hash, expires_at, created_at, revoked_at, replaced_by FROM refresh_tokens WHERE token_hash = $1", hash).
+		Scan(&token.Id, &token.UserId, &token.FamilyId, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.RevokedAt, &replacedBy)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	token.ReplacedBy = replacedBy.String
+
+	return &token, nil
+}
+
+func (p *PostgresRepository) RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Solo se revoca si sigue activo, asi dos rotaciones concurrentes del mismo token no pueden tener exito
+	result, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1, replaced_by = $2 WHERE id = $3 AND revoked_at IS NULL",
+		time.Now().UTC(), next.Id, oldId)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return repository.ErrConflict
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		next.Id, next.UserId, next.FamilyId, next.TokenHash, next.ExpiresAt.UTC())
+	if err != nil {
+		return translateError(err)
+	}
+
+	return tx.Commit()
 }
 
-func (p *PostgresRepository) UpdatePost(ctx context.Context, post *models.Post) error {
-	_, err := p.db.ExecContext(ctx, "UPDATE posts SET content = $1 WHERE id = $2", post.Content, post.Id)
+func (p *PostgresRepository) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	_, err := p.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL",
+		time.Now().UTC(), familyId)
 	return err
 }
 
-func (p *PostgresRepository) DeletePost(ctx context.Context, id string) error {
-	_, err := p.db.ExecContext(ctx, "DELETE FROM posts WHERE id = $1", id)
+func (p *PostgresRepository) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := p.db.ExecContext(ctx, "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING",
+		jti, expiresAt.UTC())
 	return err
 }
 
-func (p *PostgresRepository) ListPosts(ctx context.Context, page uint64) ([]*models.Post, error) {
-	rows, err := p.db.QueryContext(ctx, "SELECT id, content, created_at, user_id FROM posts LIMIT $1 OFFSET $2", 10, page*10)
+func (p *PostgresRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := p.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expires_at > $2)",
+		jti, time.Now().UTC()).Scan(&revoked)
+	return revoked, err
+}
+
+func (p *PostgresRepository) InsertPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	_, err := p.db.ExecContext(ctx, "INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		token.Id, token.UserId, token.TokenHash, token.ExpiresAt.UTC())
+	return translateError(err)
+}
+
+func (p *PostgresRepository) ResetPassword(ctx context.Context, tokenHash string, password string, at time.Time) (string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	at = at.UTC()
+
+	// Solo se usa si sigue activo, asi dos peticiones con el mismo token no pueden tener exito
+	var userId string
+	err = tx.QueryRowContext(ctx, `UPDATE password_reset_tokens SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1 RETURNING user_id`, at, tokenHash).Scan(&userId)
+	if err != nil {
+		return "", translateError(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL", at, userId); err != nil {
+		return "", err
+	}
+	if err := checkAffected(tx.ExecContext(ctx, "UPDATE users SET password = $1, password_changed_at = $2 WHERE id = $3", password, at, userId)); err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", at, userId); err != nil {
+		return "", err
+	}
+
+	return userId, tx.Commit()
+}
+
+func (p *PostgresRepository) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	var attempt = models.LoginAttempt{}
+
+	err := p.db.QueryRowContext(ctx, "SELECT key, failures, last_failed_at FROM login_attempts WHERE key = $1", key).
+		Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &attempt, nil
+}
+
+// Cada llave se bloquea con FOR UPDATE antes de leerla, asi los intentos de la misma llave se chequean de uno en uno
+// La fila se crea antes con cero fallos para poder bloquearla aunque la llave todavia no tenga fallos
+func (p *PostgresRepository) ReserveLoginAttempt(ctx context.Context, keys []string, at time.Time, since time.Time, check repository.LoginAttemptCheck) ([]*models.LoginAttempt, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current := make([]*models.LoginAttempt, len(keys))
+	for i, key := range keys {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO login_attempts (key, failures, last_failed_at) VALUES ($1, 0, $2) ON CONFLICT (key) DO NOTHING",
+			key, at.UTC()); err != nil {
+			return nil, err
+		}
 
-	defer func() {
-		err = rows.Close()
+		var attempt = models.LoginAttempt{}
+		err := tx.QueryRowContext(ctx, "SELECT key, failures, last_failed_at FROM login_attempts WHERE key = $1 FOR UPDATE", key).
+			Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailedAt)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-	}()
+		if attempt.Failures > 0 && !attempt.LastFailedAt.Before(since) {
+			current[i] = &attempt
+		}
+	}
 
-	var posts []*models.Post
+	if err := check(current); err != nil {
+		return nil, err
+	}
+
+	reserved := make([]*models.LoginAttempt, len(keys))
+	for i, key := range keys {
+		attempt := &models.LoginAttempt{Key: key, Failures: 1, LastFailedAt: at.UTC()}
+		if current[i] != nil {
+			attempt.Failures = current[i].Failures + 1
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE login_attempts SET failures = $2, last_failed_at = $3 WHERE key = $1",
+			key, attempt.Failures, attempt.LastFailedAt); err != nil {
+			return nil, err
+		}
+		reserved[i] = attempt
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return reserved, nil
+}
+
+func (p *PostgresRepository) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, "UPDATE login_attempts SET failures = failures - 1 WHERE key = $1 AND failures > 0", key)
+	return err
+}
+
+func (p *PostgresRepository) ResetLoginAttempt(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
+
+func (p *PostgresRepository) InsertLoginAudit(ctx context.Context, audit *models.LoginAudit) error {
+	createdAt := audit.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	_, err := p.db.ExecContext(ctx, `INSERT INTO login_audit (id, email, user_id, ip, reason, failures, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		audit.Id, audit.Email, audit.UserId, audit.IP, audit.Reason, audit.Failures, createdAt.UTC())
+	return translateError(err)
+}
+
+func (p *PostgresRepository) ListLoginAudit(ctx context.Context, page uint64) ([]*models.LoginAudit, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT id, email, user_id, ip, reason, failures, created_at FROM login_audit
+		ORDER BY created_at DESC, id DESC LIMIT $1 OFFSET $2`, 10, page*10)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var audits []*models.LoginAudit
 
 	for rows.Next() {
-		var post = models.Post{}
-		if err = rows.Scan(&post.Id, &post.Content, &post.CreatedAt, &post.UserID); err == nil {
-			posts = append(posts, &post)
+		var audit = models.LoginAudit{}
+		if err = rows.Scan(&audit.Id, &audit.Email, &audit.UserId, &audit.IP, &audit.Reason, &audit.Failures, &audit.CreatedAt); err != nil {
+			return nil, err
 		}
+		audits = append(audits, &audit)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return posts, nil
+	return audits, nil
+}
+
+func (p *PostgresRepository) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxMessage, error) {
+	now := time.Now().UTC()
+
+	// SKIP LOCKED evita que dos instancias reserven el mismo evento al mismo tiempo
+	rows, err := p.db.QueryContext(ctx, `UPDATE outbox SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM outbox WHERE published_at IS NULL AND next_attempt_at <= $2
+			ORDER BY created_at, id LIMIT $3 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, topics, event, created_at, attempts, last_error, next_attempt_at`,
+		now.Add(lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.OutboxMessage
+
+	for rows.Next() {
+		var event = models.OutboxMessage{}
+		var data string
+		if err = rows.Scan(&event.Id, pq.Array(&event.Topics), &data, &event.CreatedAt, &event.Attempts, &event.LastError, &event.NextAttemptAt); err != nil {
+			return nil, err
+		}
+		event.Event = []byte(data)
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING no garantiza el orden
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].Id < events[j].Id
+		}
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+
+	return events, nil
+}
+
+func (p *PostgresRepository) MarkOutboxPublished(ctx context.Context, id string) error {
+	return checkAffected(p.db.ExecContext(ctx, "UPDATE outbox SET published_at = $1 WHERE id = $2", time.Now().UTC(), id))
+}
+
+func (p *PostgresRepository) MarkOutboxFailed(ctx context.Context, id string, reason string, retryAt time.Time) error {
+	return checkAffected(p.db.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2 WHERE id = $3",
+		reason, retryAt.UTC(), id))
+}
+
+func (p *PostgresRepository) DeletePublishedOutboxMessages(ctx context.Context, before time.Time) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM outbox WHERE published_at IS NOT NULL AND published_at < $1", before.UTC())
+	return err
 }
 
 func (p *PostgresRepository) Close() error {
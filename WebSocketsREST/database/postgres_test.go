@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest_ws/repository"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestTranslateError(t *testing.T) {
+	other := errors.New("other")
+
+	tables := []struct {
+		err      error
+		expected error
+	}{
+		{sql.ErrNoRows, repository.ErrNotFound},
+		{&pq.Error{Code: uniqueViolation}, repository.ErrConflict},
+		{fmt.Errorf("insert: %w", &pq.Error{Code: uniqueViolation}), repository.ErrConflict},
+		{&pq.Error{Code: foreignKeyViolation}, repository.ErrNotFound},
+		{&pq.Error{Code: checkViolation}, repository.ErrConflict},
+		{other, other},
+		{nil, nil},
+	}
+
+	for _, item := range tables {
+		if got := translateError(item.err); !errors.Is(got, item.expected) || (item.expected == nil && got != nil) {
+			t.Errorf("translateError(%v) was incorrect, got %v expected %v", item.err, got, item.expected)
+		}
+	}
+}
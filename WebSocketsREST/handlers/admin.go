@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"rest_ws/middleware"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+	Handlers para administrar usuarios
+	Las rutas de este archivo deben protegerse con middleware.RequireRole(models.RoleAdmin)
+*/
+
+type UpdateUserRoleRequest struct {
+	Role models.Role `json:"role"`
+}
+
+type UpdateUserRoleResponse struct {
+	Message string `json:"message"`
+}
+
+func ListUsersHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		page, ok := pageParam(w, r)
+		if !ok {
+			return
+		}
+
+		users, err := repository.ListUsers(r.Context(), page)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+		if users == nil {
+			users = []*models.User{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	}
+}
+
+// Lista los logins sospechosos, los mas recientes primero
+func ListLoginAuditHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		page, ok := pageParam(w, r)
+		if !ok {
+			return
+		}
+
+		audits, err := repository.ListLoginAudit(r.Context(), page)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+		if audits == nil {
+			audits = []*models.LoginAudit{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(audits)
+	}
+}
+
+// Lee el parametro opcional page de la url, si no es valido responde con el error y devuelve false
+func pageParam(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	value := r.URL.Query().Get("page")
+	if value == "" {
+		return 0, true
+	}
+
+	page, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid page")
+		return 0, false
+	}
+	return page, true
+}
+
+func UpdateUserRoleHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if id == "" {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid id")
+			return
+		}
+
+		// Un admin no puede quitarse su propio rol y dejar al sistema sin administradores
+		if id == user.Id {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Cannot change your own role")
+			return
+		}
+
+		var request = UpdateUserRoleRequest{}
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+
+		if !request.Role.Valid() {
+			problem.Validation(w, r, []problem.FieldError{
+				{Field: "role", Code: "invalid", Message: "Role must be user, moderator or admin"},
+			})
+			return
+		}
+
+		err := repository.UpdateUserRole(r.Context(), id, request.Role)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UpdateUserRoleResponse{
+			Message: "Role updated",
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rest_ws/database"
+	"rest_ws/repository"
+	"strings"
+	"testing"
+)
+
+func TestAdminEmptyLists(t *testing.T) {
+	repository.SetRepository(database.NewMemoryRepository())
+	s := newTestServer(t)
+
+	tables := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+	}{
+		{"users", ListUsersHandler(s), "/admin/users?page=5"},
+		{"login audit", ListLoginAuditHandler(s), "/admin/login-audit"},
+	}
+
+	for _, item := range tables {
+		w := httptest.NewRecorder()
+		item.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, item.path, nil))
+
+		if body := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || body != "[]" {
+			t.Errorf("%s was incorrect, got %d %s expected %d []", item.name, w.Code, body, http.StatusOK)
+		}
+	}
+}
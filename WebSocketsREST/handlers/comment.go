@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"rest_ws/middleware"
+	"rest_ws/models"
+	"rest_ws/policy"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/ksuid"
+)
+
+/*
+	Comentarios de los posts, en /api/v1/posts/{id}/comments
+	Los eventos se publican en el topico del post, asi quienes lo estan viendo reciben los comentarios en vivo
+*/
+
+type UpdateInsertCommentRequest struct {
+	Content string `json:"content"`
+}
+
+type UpdateCommentResponse struct {
+	Message string `json:"message"`
+}
+
+// Carga el post de la url, si no existe responde con el error y devuelve false
+func postFromPath(w http.ResponseWriter, r *http.Request) (*models.Post, bool) {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid id")
+		return nil, false
+	}
+
+	post, err := repository.GetPostById(r.Context(), id)
+	if err != nil {
+		RepositoryError(w, r, err)
+		return nil, false
+	}
+	return post, true
+}
+
+// Carga el post y el comentario de la url, un comentario de otro post se trata como inexistente
+func commentFromPath(w http.ResponseWriter, r *http.Request) (*models.Post, *models.Comment, bool) {
+	post, ok := postFromPath(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	comment, err := repository.GetCommentById(r.Context(), mux.Vars(r)["commentId"])
+	if err == nil && comment.PostId != post.Id {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		RepositoryError(w, r, err)
+		return nil, nil, false
+	}
+	return post, comment, true
+}
+
+func InsertCommentHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		post, ok := postFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var request = UpdateInsertCommentRequest{}
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
+			return
+		}
+
+		id, err := ksuid.NewRandom()
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		comment := models.Comment{
+			Id:        id.String(),
+			PostId:    post.Id,
+			UserId:    user.Id,
+			Content:   request.Content,
+			CreatedAt: time.Now().UTC(),
+		}
+
+		event, err := outboxEvent(user.Id, models.CommentCreated(comment), commentTopics(post.Id)...)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		// Si el post se borro mientras tanto el repositorio devuelve ErrNotFound
+		err = repository.InsertComment(r.Context(), &comment, event)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+		s.Outbox().Wake()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(comment)
+	}
+}
+
+// Los comentarios van del mas antiguo al mas reciente, el cursor queda ligado al post
+func ListCommentsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		post, ok := postFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		query := models.CursorQuery("comments", post.Id)
+		after, limit, ok := cursorParams(w, r, s, query)
+		if !ok {
+			return
+		}
+
+		// Se pide un elemento de mas para saber si hay otra pagina
+		comments, err := repository.ListComments(r.Context(), post.Id, after, limit+1)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		var next *models.Cursor
+		if len(comments) > limit {
+			comments = comments[:limit]
+			last := comments[limit-1]
+			next = models.NewCursor(last.CreatedAt, last.Id)
+			next.Query = query
+		}
+		if comments == nil {
+			comments = []*models.Comment{}
+		}
+
+		writeCursorPage(w, r, s, comments, next)
+	}
+}
+
+func UpdateCommentHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		post, comment, ok := commentFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		if !policy.CanComment(user, policy.ActionUpdate, post, comment) {
+			forbidden(w, r)
+			return
+		}
+
+		var request = UpdateInsertCommentRequest{}
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
+			return
+		}
+
+		updatedAt := time.Now().UTC()
+		comment.Content = request.Content
+		comment.UpdatedAt = &updatedAt
+
+		event, err := outboxEvent(user.Id, models.CommentUpdated(*comment), commentTopics(post.Id)...)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		err = repository.UpdateComment(r.Context(), comment, event)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+		s.Outbox().Wake()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UpdateCommentResponse{
+			Message: "Comment updated",
+		})
+	}
+}
+
+func DeleteCommentHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		post, comment, ok := commentFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		if !policy.CanComment(user, policy.ActionDelete, post, comment) {
+			forbidden(w, r)
+			return
+		}
+
+		event, err := outboxEvent(user.Id, models.CommentDeleted{Id: comment.Id, PostId: post.Id, UserId: comment.UserId}, commentTopics(post.Id)...)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		err = repository.DeleteComment(r.Context(), comment.Id, event)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+		s.Outbox().Wake()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UpdateCommentResponse{
+			Message: "Comment deleted",
+		})
+	}
+}
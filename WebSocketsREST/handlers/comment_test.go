@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rest_ws/database"
+	"rest_ws/middleware"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/websockets"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func commentRouter(s *testServer) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/posts/{id}/comments", ListCommentsHandler(s)).Methods("GET")
+	r.HandleFunc("/posts/{id}/comments", InsertCommentHandler(s)).Methods("POST")
+	r.HandleFunc("/posts/{id}/comments/{commentId}", UpdateCommentHandler(s)).Methods("PUT")
+	r.HandleFunc("/posts/{id}/comments/{commentId}", DeleteCommentHandler(s)).Methods("DELETE")
+	return r
+}
+
+// Hace la peticion como si el middleware de autenticacion ya hubiera cargado al usuario
+func requestAs(handler http.Handler, user *models.User, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	reader := strings.NewReader("")
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = strings.NewReader(string(data))
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	r = r.WithContext(middleware.WithUser(r.Context(), user))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestComments(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	router := commentRouter(s)
+
+	owner := insertTestUser(t, repo, "owner@example.com", "correct-password")
+	author := insertTestUser(t, repo, "author@example.com", "correct-password")
+	other := insertTestUser(t, repo, "other@example.com", "correct-password")
+	post := &models.Post{Id: "post", Content: "post", CreatedAt: time.Now().UTC(), UserID: owner.Id}
+	if err := repo.InsertPost(context.Background(), post); err != nil {
+		t.Fatal(err)
+	}
+
+	w := requestAs(router, author, http.MethodPost, "/posts/post/comments", UpdateInsertCommentRequest{Content: "first"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("insert comment was incorrect, got %d", w.Code)
+	}
+	var comment models.Comment
+	if err := json.NewDecoder(w.Body).Decode(&comment); err != nil {
+		t.Fatal(err)
+	}
+	if comment.Id == "" || comment.PostId != post.Id || comment.UserId != author.Id {
+		t.Fatalf("inserted comment was incorrect, got %+v", comment)
+	}
+	path := "/posts/post/comments/" + comment.Id
+
+	tables := []struct {
+		name   string
+		user   *models.User
+		method string
+		path   string
+		body   interface{}
+		status int
+		code   string
+	}{
+		{"insert on missing post", author, http.MethodPost, "/posts/missing/comments", UpdateInsertCommentRequest{Content: "hi"}, http.StatusNotFound, problem.CodeNotFound},
+		{"insert empty content", author, http.MethodPost, "/posts/post/comments", UpdateInsertCommentRequest{Content: " "}, http.StatusUnprocessableEntity, problem.CodeValidationFailed},
+		{"list missing post", author, http.MethodGet, "/posts/missing/comments", nil, http.StatusNotFound, problem.CodeNotFound},
+		{"invalid cursor", author, http.MethodGet, "/posts/post/comments?cursor=x", nil, http.StatusBadRequest, problem.CodeInvalidRequest},
+		{"invalid limit", author, http.MethodGet, "/posts/post/comments?limit=0", nil, http.StatusBadRequest, problem.CodeInvalidRequest},
+		{"update by other user", other, http.MethodPut, path, UpdateInsertCommentRequest{Content: "edited"}, http.StatusForbidden, problem.CodeForbidden},
+		{"update by post owner", owner, http.MethodPut, path, UpdateInsertCommentRequest{Content: "edited"}, http.StatusForbidden, problem.CodeForbidden},
+		{"update through another post", author, http.MethodPut, "/posts/missing/comments/" + comment.Id, UpdateInsertCommentRequest{Content: "edited"}, http.StatusNotFound, problem.CodeNotFound},
+		{"update by author", author, http.MethodPut, path, UpdateInsertCommentRequest{Content: "edited"}, http.StatusOK, ""},
+		{"delete by other user", other, http.MethodDelete, path, nil, http.StatusForbidden, problem.CodeForbidden},
+		{"delete by post owner", owner, http.MethodDelete, path, nil, http.StatusOK, ""},
+		{"delete again", author, http.MethodDelete, path, nil, http.StatusNotFound, problem.CodeNotFound},
+	}
+
+	for _, item := range tables {
+		w := requestAs(router, item.user, item.method, item.path, item.body)
+		if code := errorCode(t, w); w.Code != item.status || code != item.code {
+			t.Errorf("%s: got %d %s expected %d %s", item.name, w.Code, code, item.status, item.code)
+		}
+		if item.name == "update by author" {
+			stored, err := repo.GetCommentById(context.Background(), comment.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Content != "edited" || stored.UpdatedAt == nil {
+				t.Errorf("update by author was incorrect, got %+v", stored)
+			}
+		}
+	}
+
+	// Cada cambio genera un evento en el topico del post y no en el topico general
+	messages, err := repo.ClaimOutboxMessages(context.Background(), 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, message := range messages {
+		var event struct {
+			Type models.EventType `json:"type"`
+		}
+		if err := json.Unmarshal(message.Event, &event); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, string(event.Type))
+		if strings.Join(message.Topics, ",") != websockets.PostTopic(post.Id) {
+			t.Errorf("%s topics were incorrect, got %v expected %s", event.Type, message.Topics, websockets.PostTopic(post.Id))
+		}
+	}
+	expected := []string{string(models.EventCommentCreated), string(models.EventCommentUpdated), string(models.EventCommentDeleted)}
+	if strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Errorf("comment events were incorrect, got %v expected %v", types, expected)
+	}
+}
+
+func TestListComments(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	router := commentRouter(s)
+
+	user := insertTestUser(t, repo, "user@example.com", "correct-password")
+	if err := repo.InsertPost(context.Background(), &models.Post{Id: "post", Content: "post", UserID: user.Id}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		if w := requestAs(router, user, http.MethodPost, "/posts/post/comments", UpdateInsertCommentRequest{Content: "comment"}); w.Code != http.StatusOK {
+			t.Fatalf("insert comment %d was incorrect, got %d", i, w.Code)
+		}
+	}
+
+	// Se recorren las paginas siguiendo next_cursor, del mas antiguo al mas reciente
+	tables := []struct {
+		expected int
+		more     bool
+	}{
+		{5, true},
+		{5, true},
+		{2, false},
+	}
+
+	var seen []*models.Comment
+	cursor := ""
+	for i, item := range tables {
+		w := requestAs(router, user, http.MethodGet, "/posts/post/comments?limit=5&cursor="+cursor, nil)
+		var comments []*models.Comment
+		next := decodePage(t, w.Body, &comments)
+		if w.Code != http.StatusOK || comments == nil || len(comments) != item.expected || (next != "") != item.more {
+			t.Errorf("list comments page %d was incorrect, got %d with %d comments and cursor %q expected %d", i, w.Code, len(comments), next, item.expected)
+		}
+		seen = append(seen, comments...)
+		cursor = next
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i].CreatedAt.Before(seen[i-1].CreatedAt) || seen[i].Id == seen[i-1].Id {
+			t.Errorf("list comments order was incorrect at %d, got %v after %v", i, seen[i].CreatedAt, seen[i-1].CreatedAt)
+		}
+	}
+
+	// El cursor queda ligado al post
+	if err := repo.InsertPost(context.Background(), &models.Post{Id: "other", Content: "post", UserID: user.Id}); err != nil {
+		t.Fatal(err)
+	}
+	w := requestAs(router, user, http.MethodGet, "/posts/post/comments?limit=5", nil)
+	var comments []*models.Comment
+	next := decodePage(t, w.Body, &comments)
+	w = requestAs(router, user, http.MethodGet, "/posts/other/comments?cursor="+next, nil)
+	if code := errorCode(t, w); w.Code != http.StatusBadRequest || code != problem.CodeInvalidRequest {
+		t.Errorf("list comments with the cursor of another post was incorrect, got %d %s", w.Code, code)
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"strings"
+)
+
+// Traduce los errores del repositorio a respuestas HTTP
+// Todos los handlers deben usar esta funcion para que el mismo error tenga siempre el mismo codigo
+func RepositoryError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Resource not found")
+	case errors.Is(err, repository.ErrConflict):
+		problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "Resource already exists")
+	default:
+		problem.Internal(w, r, err)
+	}
+}
+
+// Decodifica el cuerpo JSON de la peticion, si falla responde con el error y devuelve false
+// Se rechazan los campos desconocidos, asi un error de tipeo del cliente no se ignora en silencio
+// El error del decodificador no se envia al cliente, solo el campo que fallo
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeBody(w, r, v, false)
+}
+
+// Igual que decodeJSON pero acepta un cuerpo vacio
+func decodeOptionalJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeBody(w, r, v, true)
+}
+
+// Prefijo del error de encoding/json para un campo desconocido, no tiene un tipo propio
+const unknownFieldPrefix = "json: unknown field "
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == io.EOF && optional {
+		return true
+	}
+	// Solo se acepta un valor JSON en el cuerpo
+	if err == nil && decoder.More() {
+		err = errors.New("unexpected data after JSON value")
+	}
+	if err == nil {
+		return true
+	}
+
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, server.ErrBodyTooLarge):
+		problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge, "Request body is too large")
+	case strings.HasPrefix(err.Error(), unknownFieldPrefix):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), unknownFieldPrefix), `"`)
+		invalidJSON(w, r, problem.FieldError{Field: field, Code: "unknown", Message: "Unknown field"})
+	case errors.As(err, &typeError) && typeError.Field != "":
+		invalidJSON(w, r, problem.FieldError{Field: typeError.Field, Code: "type", Message: "Value must be of type " + typeError.Type.String()})
+	default:
+		invalidJSON(w, r)
+	}
+	return false
+}
+
+func invalidJSON(w http.ResponseWriter, r *http.Request, errors ...problem.FieldError) {
+	p := problem.New(http.StatusBadRequest, problem.CodeInvalidJSON, "Request body is not valid JSON")
+	p.Errors = errors
+	problem.Write(w, r, p)
+}
+
+// Responde que la peticion no tiene un usuario autenticado
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+}
+
+// Responde que el usuario no tiene permiso para la accion
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "You are not allowed to perform this action")
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rest_ws/problem"
+	"rest_ws/server"
+	"rest_ws/validation"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tables := []struct {
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{`{"content": "hello"}`, http.StatusOK, "", ""},
+		{`{"content": "hello", "title": "x"}`, http.StatusBadRequest, problem.CodeInvalidJSON, "title"},
+		{`{"content": 42}`, http.StatusBadRequest, problem.CodeInvalidJSON, "content"},
+		{`{"content": "hello"} {}`, http.StatusBadRequest, problem.CodeInvalidJSON, ""},
+		{`{"content": `, http.StatusBadRequest, problem.CodeInvalidJSON, ""},
+		{``, http.StatusBadRequest, problem.CodeInvalidJSON, ""},
+		{`{"content": "` + strings.Repeat("x", 100) + `"}`, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge, ""},
+	}
+
+	handler := server.BodyLimitMiddleware(64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request UpdateInsertPostRequest
+		if decodeJSON(w, r, &request) {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	for _, item := range tables {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/posts", strings.NewReader(item.body)))
+
+		if recorder.Code != item.status {
+			t.Errorf("decodeJSON(%s) status was incorrect, got %d expected %d", item.body, recorder.Code, item.status)
+			continue
+		}
+		if item.status == http.StatusOK {
+			continue
+		}
+
+		var p problem.Problem
+		if err := json.NewDecoder(recorder.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Code != item.code {
+			t.Errorf("decodeJSON(%s) code was incorrect, got %s expected %s", item.body, p.Code, item.code)
+		}
+		field := ""
+		if len(p.Errors) > 0 {
+			field = p.Errors[0].Field
+		}
+		if field != item.field {
+			t.Errorf("decodeJSON(%s) field was incorrect, got %q expected %q", item.body, field, item.field)
+		}
+	}
+}
+
+func TestValidateRequests(t *testing.T) {
+	rules := validation.Rules{}.WithDefaults()
+
+	signUp := SignUpRequest{Email: "  Ana@Example.com ", Password: "correct-horse-1"}
+	if errors := signUp.Validate(rules); len(errors) > 0 {
+		t.Fatalf("valid signup was rejected: %v", errors)
+	}
+	if signUp.Email != "ana@example.com" {
+		t.Errorf("signup email was not normalized, got %s", signUp.Email)
+	}
+
+	signUp = SignUpRequest{Email: "ana", Password: ""}
+	if errors := signUp.Validate(rules); len(errors) != 2 {
+		t.Errorf("invalid signup errors were incorrect, got %v expected one per field", errors)
+	}
+
+	// El login no aplica la politica de contraseñas
+	login := LoginRequest{Email: "Ana@Example.com", Password: "short"}
+	if errors := login.Validate(rules); len(errors) > 0 {
+		t.Errorf("login was rejected: %v", errors)
+	}
+	if login.Email != "ana@example.com" {
+		t.Errorf("login email was not normalized, got %s", login.Email)
+	}
+
+	post := UpdateInsertPostRequest{Content: strings.Repeat("x", rules.PostMaxLength+1)}
+	if errors := post.Validate(rules); len(errors) != 1 || errors[0].Field != "content" {
+		t.Errorf("long post errors were incorrect, got %v", errors)
+	}
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"rest_ws/models"
+	"rest_ws/websockets"
+)
+
+// Crea el mensaje del outbox para un evento del catalogo
+// Se guarda en la misma transaccion que el cambio y el relay lo publica en los topicos indicados,
+// los clientes suscritos a varios de ellos lo reciben una sola vez
+func outboxEvent(actor string, payload models.EventPayload, topics ...string) (*models.OutboxMessage, error) {
+	return models.NewOutboxMessage(models.NewEvent(actor, payload), topics...)
+}
+
+// Topicos en los que se publican los eventos de un post
+func postTopics(id string) []string {
+	return []string{websockets.PostsTopic, websockets.PostTopic(id)}
+}
+
+// Los comentarios solo se publican en el topico del post, no en el topico general de posts
+func commentTopics(postId string) []string {
+	return []string{websockets.PostTopic(postId)}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"rest_ws/middleware"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+	Relaciones entre usuarios y el feed personal
+	PUT y DELETE /api/v1/following/{id} siguen o dejan de seguir a un usuario, los dos son idempotentes
+	/api/v1/feed lista los posts de los usuarios seguidos y los posts nuevos se envian a los seguidores
+	por su topico privado user:{id}
+*/
+
+type FollowResponse struct {
+	Message string `json:"message"`
+}
+
+func FollowHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if id == user.Id {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "You cannot follow yourself")
+			return
+		}
+
+		err := repository.Follow(r.Context(), &models.Follow{FollowerId: user.Id, FolloweeId: id, CreatedAt: time.Now().UTC()})
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FollowResponse{Message: "User followed"})
+	}
+}
+
+func UnfollowHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		if err := repository.Unfollow(r.Context(), user.Id, mux.Vars(r)["id"]); err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FollowResponse{Message: "User unfollowed"})
+	}
+}
+
+func ListFollowersHandler(s server.Server) http.HandlerFunc {
+	return listFollowsHandler(s, "followers", repository.ListFollowers, func(follow *models.Follow) string { return follow.FollowerId })
+}
+
+func ListFollowingHandler(s server.Server) http.HandlerFunc {
+	return listFollowsHandler(s, "following", repository.ListFollowing, func(follow *models.Follow) string { return follow.FolloweeId })
+}
+
+// Los dos listados solo cambian la consulta y cual usuario de la relacion es el id del cursor
+// El cursor queda ligado al listado y al usuario de la ruta, no sirve para el otro listado ni para otro usuario
+func listFollowsHandler(s server.Server, name string, list func(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error), other func(follow *models.Follow) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		query := models.CursorQuery(name, mux.Vars(r)["id"])
+		after, limit, ok := cursorParams(w, r, s, query)
+		if !ok {
+			return
+		}
+
+		user, err := repository.FindUserById(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		// Se pide un elemento de mas para saber si hay otra pagina
+		follows, err := list(r.Context(), user.Id, after, limit+1)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		var next *models.Cursor
+		if len(follows) > limit {
+			follows = follows[:limit]
+			last := follows[limit-1]
+			next = models.NewCursor(last.CreatedAt, other(last))
+			next.Query = query
+		}
+		if follows == nil {
+			follows = []*models.Follow{}
+		}
+
+		writeCursorPage(w, r, s, follows, next)
+	}
+}
+
+func FeedHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		// El cursor queda ligado al feed del usuario autenticado
+		query := models.CursorQuery("feed", user.Id)
+		after, limit, ok := cursorParams(w, r, s, query)
+		if !ok {
+			return
+		}
+
+		posts, err := repository.ListFeed(r.Context(), user.Id, after, limit+1)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		posts, next := postsPage(posts, limit)
+		if next != nil {
+			next.Query = query
+		}
+		writeCursorPage(w, r, s, posts, next)
+	}
+}
+
+// Recorta la pagina de posts pedida con un elemento de mas y devuelve el cursor de la siguiente
+func postsPage(posts []*models.Post, limit int) ([]*models.Post, *models.Cursor) {
+	var next *models.Cursor
+	if len(posts) > limit {
+		posts = posts[:limit]
+		last := posts[limit-1]
+		next = models.NewCursor(last.CreatedAt, last.Id)
+	}
+	if posts == nil {
+		posts = []*models.Post{}
+	}
+	return posts, next
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rest_ws/database"
+	"rest_ws/models"
+	"rest_ws/outbox"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/websockets"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func followRouter(s *testServer) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/posts", InsertPostHandler(s)).Methods("POST")
+	r.HandleFunc("/feed", FeedHandler(s)).Methods("GET")
+	r.HandleFunc("/following/{id}", FollowHandler(s)).Methods("PUT")
+	r.HandleFunc("/following/{id}", UnfollowHandler(s)).Methods("DELETE")
+	r.HandleFunc("/users/{id}/followers", ListFollowersHandler(s)).Methods("GET")
+	r.HandleFunc("/users/{id}/following", ListFollowingHandler(s)).Methods("GET")
+	return r
+}
+
+// Decodifica una pagina de un listado con cursor
+func decodePage(t *testing.T, body io.Reader, items interface{}) string {
+	var page struct {
+		Items      json.RawMessage `json:"items"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(page.Items, items); err != nil {
+		t.Fatal(err)
+	}
+	return page.NextCursor
+}
+
+func TestFollow(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	router := followRouter(s)
+
+	star := insertTestUser(t, repo, "star@example.com", "correct-password")
+	alice := insertTestUser(t, repo, "alice@example.com", "correct-password")
+	bob := insertTestUser(t, repo, "bob@example.com", "correct-password")
+
+	tables := []struct {
+		name   string
+		user   *models.User
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{"follow yourself", alice, http.MethodPut, "/following/alice", http.StatusBadRequest, problem.CodeInvalidRequest},
+		{"follow missing user", alice, http.MethodPut, "/following/missing", http.StatusNotFound, problem.CodeNotFound},
+		{"alice follows star", alice, http.MethodPut, "/following/star", http.StatusOK, ""},
+		{"alice follows star again", alice, http.MethodPut, "/following/star", http.StatusOK, ""},
+		{"bob follows star", bob, http.MethodPut, "/following/star", http.StatusOK, ""},
+		{"bob follows alice", bob, http.MethodPut, "/following/alice", http.StatusOK, ""},
+		{"bob unfollows alice", bob, http.MethodDelete, "/following/alice", http.StatusOK, ""},
+		{"bob unfollows alice again", bob, http.MethodDelete, "/following/alice", http.StatusOK, ""},
+		{"followers of missing user", alice, http.MethodGet, "/users/missing/followers", http.StatusNotFound, problem.CodeNotFound},
+		{"invalid cursor", alice, http.MethodGet, "/users/star/followers?cursor=x", http.StatusBadRequest, problem.CodeInvalidRequest},
+		{"invalid limit", alice, http.MethodGet, "/users/star/followers?limit=0", http.StatusBadRequest, problem.CodeInvalidRequest},
+	}
+
+	for _, item := range tables {
+		w := requestAs(router, item.user, item.method, item.path, nil)
+		if code := errorCode(t, w); w.Code != item.status || code != item.code {
+			t.Errorf("%s: got %d %s expected %d %s", item.name, w.Code, code, item.status, item.code)
+		}
+	}
+
+	// Las paginas de un elemento se enlazan con next_cursor y con la cabecera Link
+	var got []string
+	path := "/users/star/followers?limit=1"
+	for page := 0; page < 5 && path != ""; page++ {
+		w := requestAs(router, star, http.MethodGet, path, nil)
+		var follows []*models.Follow
+		next := decodePage(t, w.Body, &follows)
+		for _, follow := range follows {
+			got = append(got, follow.FollowerId)
+		}
+
+		path = ""
+		if next != "" {
+			link := w.Header().Get("Link")
+			if !strings.HasPrefix(link, "<"+s.config.PublicURL+"/users/star/followers?") || !strings.HasSuffix(link, `>; rel="next"`) {
+				t.Errorf("Link header was incorrect, got %s", link)
+			}
+			path = "/users/star/followers?limit=1&cursor=" + url.QueryEscape(next)
+		} else if w.Header().Get("Link") != "" {
+			t.Errorf("last page should not have a Link header")
+		}
+	}
+	if len(got) != 2 || got[0] == got[1] {
+		t.Errorf("followers were incorrect, got %v expected alice and bob", got)
+	}
+
+	w := requestAs(router, bob, http.MethodGet, "/users/bob/following", nil)
+	var following []*models.Follow
+	decodePage(t, w.Body, &following)
+	if len(following) != 1 || following[0].FolloweeId != star.Id {
+		t.Errorf("following was incorrect, got %+v", following)
+	}
+
+	// El cursor de los seguidores de star solo sirve para ese listado
+	w = requestAs(router, star, http.MethodGet, "/users/star/followers?limit=1", nil)
+	var follows []*models.Follow
+	next := url.QueryEscape(decodePage(t, w.Body, &follows))
+
+	cursorTables := []struct {
+		path   string
+		status int
+	}{
+		{"/users/star/followers?cursor=" + next, http.StatusOK},
+		{"/users/alice/followers?cursor=" + next, http.StatusBadRequest},
+		{"/users/star/following?cursor=" + next, http.StatusBadRequest},
+	}
+	for _, item := range cursorTables {
+		if w := requestAs(router, star, http.MethodGet, item.path, nil); w.Code != item.status {
+			t.Errorf("%s was incorrect, got %d expected %d", item.path, w.Code, item.status)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	s.config.PageSize = 2
+	s.config.MaxPageSize = 3
+	router := followRouter(s)
+
+	star := insertTestUser(t, repo, "star@example.com", "correct-password")
+	fan := insertTestUser(t, repo, "fan@example.com", "correct-password")
+	stranger := insertTestUser(t, repo, "stranger@example.com", "correct-password")
+	if w := requestAs(router, fan, http.MethodPut, "/following/star", nil); w.Code != http.StatusOK {
+		t.Fatalf("follow was incorrect, got %d", w.Code)
+	}
+
+	for _, user := range []*models.User{star, stranger, star, star, star, star} {
+		if w := requestAs(router, user, http.MethodPost, "/posts", UpdateInsertPostRequest{Content: "post"}); w.Code != http.StatusOK {
+			t.Fatalf("insert post was incorrect, got %d", w.Code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	tables := []struct {
+		query    string
+		expected int
+	}{
+		{"", 2},
+		{"?limit=10", 3},
+	}
+
+	for _, item := range tables {
+		w := requestAs(router, fan, http.MethodGet, "/feed"+item.query, nil)
+		var posts []*models.Post
+		if next := decodePage(t, w.Body, &posts); next == "" {
+			t.Errorf("feed%s should have a next cursor", item.query)
+		}
+		if len(posts) != item.expected {
+			t.Errorf("feed%s was incorrect, got %d posts expected %d", item.query, len(posts), item.expected)
+		}
+	}
+
+	// Un cursor del listado de posts lleva su consulta y no sirve para el feed
+	scoped := models.NewCursor(time.Now(), "post")
+	scoped.Query = models.PostFilter{}.Key()
+	w := requestAs(router, fan, http.MethodGet, "/feed?cursor="+url.QueryEscape(scoped.Encode()), nil)
+	if code := errorCode(t, w); w.Code != http.StatusBadRequest || code != problem.CodeInvalidRequest {
+		t.Errorf("feed with a posts cursor was incorrect, got %d %s expected %d %s", w.Code, code, http.StatusBadRequest, problem.CodeInvalidRequest)
+	}
+
+	// El cursor del feed de fan no sirve para el feed de otro usuario
+	w = requestAs(router, fan, http.MethodGet, "/feed", nil)
+	var page []*models.Post
+	next := url.QueryEscape(decodePage(t, w.Body, &page))
+	if w := requestAs(router, stranger, http.MethodGet, "/feed?cursor="+next, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("feed with the cursor of another user was incorrect, got %d expected %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Se recorre todo el feed, del mas reciente al mas antiguo y solo con posts de star
+	var all []*models.Post
+	path := "/feed"
+	for path != "" {
+		w := requestAs(router, fan, http.MethodGet, path, nil)
+		var posts []*models.Post
+		next := decodePage(t, w.Body, &posts)
+		all = append(all, posts...)
+		path = ""
+		if next != "" {
+			path = "/feed?cursor=" + url.QueryEscape(next)
+		}
+	}
+	if len(all) != 5 {
+		t.Errorf("feed was incorrect, got %d posts expected 5", len(all))
+	}
+	for i, post := range all {
+		if post.UserID != star.Id || (i > 0 && post.CreatedAt.After(all[i-1].CreatedAt)) {
+			t.Errorf("feed post %d was incorrect, got %+v", i, post)
+		}
+	}
+
+	// Cada post guarda un solo evento del feed sin topicos, el relay lo reparte entre los seguidores del autor
+	messages, err := repo.ClaimOutboxMessages(context.Background(), 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := 0
+	for _, message := range messages {
+		var event struct {
+			Type models.EventType `json:"type"`
+		}
+		if err := json.Unmarshal(message.Event, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != models.EventFeedPost {
+			continue
+		}
+		feed++
+		if len(message.Topics) != 0 {
+			t.Errorf("feed event topics were incorrect, got %v expected none", message.Topics)
+		}
+	}
+	if feed != 6 {
+		t.Errorf("feed events were incorrect, got %d expected 6", feed)
+	}
+}
+
+// Conecta un cliente al hub como el usuario indicado y lo suscribe al topico
+func subscribeAs(t *testing.T, server *httptest.Server, userId string, topic string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?token="+userId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "payload": map[string]string{"topic": topic}}); err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Type string `json:"type"`
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&reply); err != nil || reply.Type != "subscribed" {
+		t.Fatalf("subscribe %s to %s was incorrect, got %s %v", userId, topic, reply.Type, err)
+	}
+	return conn
+}
+
+func TestNewPostDelivery(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	router := followRouter(s)
+
+	star := insertTestUser(t, repo, "star@example.com", "correct-password")
+	fan := insertTestUser(t, repo, "fan@example.com", "correct-password")
+	insertTestUser(t, repo, "stranger@example.com", "correct-password")
+	if w := requestAs(router, fan, http.MethodPut, "/following/star", nil); w.Code != http.StatusOK {
+		t.Fatalf("follow was incorrect, got %d", w.Code)
+	}
+
+	// Hub real, el token de prueba es el id del usuario
+	hub := websockets.NewHub(websockets.HubConfig{})
+	hub.SetAuthenticator(func(ctx context.Context, token string) (string, error) {
+		return token, nil
+	})
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+	t.Cleanup(server.Close)
+
+	stranger := subscribeAs(t, server, "stranger", websockets.PostsTopic)
+	follower := subscribeAs(t, server, fan.Id, websockets.UserTopic(fan.Id))
+
+	if w := requestAs(router, star, http.MethodPost, "/posts", UpdateInsertPostRequest{Content: "post"}); w.Code != http.StatusOK {
+		t.Fatalf("insert post was incorrect, got %d", w.Code)
+	}
+	relay := outbox.NewRelay(outbox.Config{}, outbox.HubSink(hub), outbox.FeedSink(hub, 0))
+	if _, err := relay.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// El seguidor recibe el post en su topico privado
+	var event struct {
+		Type models.EventType `json:"type"`
+	}
+	follower.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := follower.ReadJSON(&event); err != nil || event.Type != models.EventFeedPost {
+		t.Errorf("follower event was incorrect, got %s %v expected %s", event.Type, err, models.EventFeedPost)
+	}
+
+	// Quien no sigue al autor no recibe nada aunque este suscrito a posts
+	stranger.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := stranger.ReadMessage(); err == nil {
+		t.Errorf("non follower on %s should not get new posts, got %s", websockets.PostsTopic, data)
+	}
+}
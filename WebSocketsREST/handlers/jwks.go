@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"rest_ws/server"
+)
+
+// Publica las llaves publicas con las que se firman los tokens (RFC 7517)
+// Otros servicios pueden usarlas para verificar los tokens sin conocer ningun secreto
+func JWKSHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(s.Keys().JWKS())
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"rest_ws/lockout"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+	Proteccion del login contra ataques de fuerza bruta, las reglas estan en el paquete lockout
+	Un email que no existe se trata igual que uno que existe: se compara la contraseña contra un hash
+	falso y se cuentan sus fallos, asi ni el tiempo de respuesta ni los bloqueos revelan que emails existen
+*/
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// Hash con el mismo costo que los reales, se genera una sola vez
+func getDummyHash() []byte {
+	dummyHashOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte(ksuid.New().String()), bcrypt.DefaultCost)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dummyHash = hash
+	})
+	return dummyHash
+}
+
+// Estado de los intentos de un login, por cuenta y por IP
+type loginAttempts struct {
+	policy lockout.Policy
+	email  string
+	ip     string
+	// Intentos despues de reservar este login, que ya cuenta como un fallo
+	account *models.LoginAttempt
+	address *models.LoginAttempt
+}
+
+// Error del chequeo de la reserva cuando la cuenta o la IP debe esperar antes de intentar
+type loginWaitError struct {
+	wait time.Duration
+}
+
+func (e *loginWaitError) Error() string {
+	return "login must wait " + e.wait.String()
+}
+
+// Reserva el intento antes de verificar la contraseña, el intento se cuenta como un fallo hasta que termine bien
+// Si la cuenta o la IP debe esperar no se reserva nada y devuelve la espera
+// Como el chequeo y el conteo son una sola operacion, los intentos en paralelo no pueden saltarse la espera
+func reserveLoginAttempt(ctx context.Context, policy lockout.Policy, email string, ip string, now time.Time) (*loginAttempts, time.Duration, error) {
+	attempts := &loginAttempts{policy: policy, email: email, ip: ip}
+
+	reserved, err := repository.ReserveLoginAttempt(ctx, []string{lockout.EmailKey(email), lockout.IPKey(ip)}, now, policy.Since(now),
+		func(current []*models.LoginAttempt) error {
+			wait := policy.Wait(current[0], policy.MaxFailures, now)
+			if address := policy.Wait(current[1], policy.MaxFailuresPerIP, now); address > wait {
+				wait = address
+			}
+			if wait > 0 {
+				return &loginWaitError{wait: wait}
+			}
+			return nil
+		})
+
+	var waitErr *loginWaitError
+	if errors.As(err, &waitErr) {
+		return nil, waitErr.wait, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	attempts.account, attempts.address = reserved[0], reserved[1]
+	return attempts, 0, nil
+}
+
+// El fallo ya se conto al reservar, si con este fallo la cuenta o la IP llega al maximo se registra el bloqueo
+func (a *loginAttempts) fail(ctx context.Context, now time.Time) error {
+	if a.account.Failures == a.policy.MaxFailures || a.address.Failures == a.policy.MaxFailuresPerIP {
+		return a.audit(ctx, models.LoginAuditLockout, "", now)
+	}
+	return nil
+}
+
+// Un login correcto olvida los fallos de la cuenta, los de la IP se mantienen
+// para que un atacante no pueda reiniciarlos entrando con su propia cuenta, solo se descuenta este intento
+func (a *loginAttempts) succeed(ctx context.Context, userId string, now time.Time) error {
+	if err := repository.ReleaseLoginAttempt(ctx, lockout.IPKey(a.ip)); err != nil {
+		return err
+	}
+
+	// Este intento no fallo, se descuenta antes de auditar
+	a.account.Failures--
+	if a.account.Failures > a.policy.FreeAttempts {
+		if err := a.audit(ctx, models.LoginAuditAfterFailure, userId, now); err != nil {
+			return err
+		}
+	}
+	return repository.ResetLoginAttempt(ctx, lockout.EmailKey(a.email))
+}
+
+func (a *loginAttempts) audit(ctx context.Context, reason models.LoginAuditReason, userId string, now time.Time) error {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	if a.account != nil {
+		failures = a.account.Failures
+	}
+
+	log.Printf("Suspicious login %s: email=%s ip=%s failures=%d", reason, a.email, a.ip, failures)
+	return repository.InsertLoginAudit(ctx, &models.LoginAudit{
+		Id:        id.String(),
+		Email:     a.email,
+		UserId:    userId,
+		IP:        a.ip,
+		Reason:    reason,
+		Failures:  failures,
+		CreatedAt: now.UTC(),
+	})
+}
+
+// Responde que se debe esperar antes de volver a intentar
+func loginLocked(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	problem.Error(w, r, http.StatusTooManyRequests, problem.CodeLoginLocked, "Too many failed login attempts, try again later")
+}
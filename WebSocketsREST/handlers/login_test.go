@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rest_ws/auth"
+	"rest_ws/database"
+	"rest_ws/lockout"
+	"rest_ws/mailer"
+	"rest_ws/models"
+	"rest_ws/outbox"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"rest_ws/validation"
+	"rest_ws/websockets"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type testServer struct {
+	config     *server.Config
+	keys       *auth.KeyManager
+	mailer     *mailer.MemoryMailer
+	background *server.Background
+}
+
+func (s *testServer) Config() *server.Config {
+	return s.config
+}
+
+func (s *testServer) Hub() *websockets.Hub {
+	return nil
+}
+
+func (s *testServer) Keys() *auth.KeyManager {
+	return s.keys
+}
+
+// El relay no se inicia, los eventos quedan en el outbox del repositorio
+func (s *testServer) Outbox() *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{})
+}
+
+func (s *testServer) Mailer() mailer.Mailer {
+	return s.mailer
+}
+
+// Las pruebas esperan las tareas en segundo plano con s.background.Wait
+func (s *testServer) Background() *server.Background {
+	return s.background
+}
+
+func newTestServer(t *testing.T) *testServer {
+	keys := auth.NewKeyManager()
+	if err := keys.AddKey(auth.NewHMACKey("test", []byte("secret")), true); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testServer{
+		keys:       keys,
+		mailer:     mailer.NewMemoryMailer(),
+		background: server.NewBackground(time.Minute),
+		config: &server.Config{
+			AccessTokenTTL:       time.Minute,
+			RefreshTokenTTL:      time.Hour,
+			PublicURL:            "http://example.com",
+			PasswordResetURL:     "http://example.com/password/reset",
+			VerificationTokenTTL: time.Hour,
+			PageSize:             20,
+			MaxPageSize:          100,
+			Validation:           validation.Rules{}.WithDefaults(),
+			LoginLockout: lockout.Policy{
+				FreeAttempts: 1,
+				BaseDelay:    time.Millisecond,
+				MaxDelay:     time.Millisecond,
+				MaxFailures:  3,
+				Duration:     time.Hour,
+			}.WithDefaults(),
+		},
+	}
+}
+
+func insertTestUser(t *testing.T, repo repository.Repository, email string, password string) *models.User {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Id: strings.SplitN(email, "@", 2)[0], Email: email, Password: string(hash)}
+	if err := repo.InsertUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func login(handler http.Handler, email string, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(LoginRequest{Email: email, Password: password})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(string(body))))
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	if w.Code < 400 {
+		return ""
+	}
+	var p problem.Problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return p.Code
+}
+
+func TestLoginLockout(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	insertTestUser(t, repo, "known@example.com", "correct-password")
+	handler := LoginHandler(newTestServer(t))
+
+	// Un email que existe y uno que no existe reciben exactamente las mismas respuestas
+	for _, email := range []string{"known@example.com", "unknown@example.com"} {
+		tables := []struct {
+			password string
+			status   int
+			code     string
+		}{
+			{"wrong", http.StatusUnauthorized, problem.CodeInvalidCredentials},
+			{"wrong", http.StatusUnauthorized, problem.CodeInvalidCredentials},
+			{"wrong", http.StatusUnauthorized, problem.CodeInvalidCredentials},
+			// Bloqueada, ni siquiera la contraseña correcta se acepta
+			{"correct-password", http.StatusTooManyRequests, problem.CodeLoginLocked},
+			{"wrong", http.StatusTooManyRequests, problem.CodeLoginLocked},
+			{"wrong", http.StatusTooManyRequests, problem.CodeLoginLocked},
+		}
+
+		for i, item := range tables {
+			// Se espera la demora progresiva entre intentos
+			time.Sleep(5 * time.Millisecond)
+			w := login(handler, email, item.password)
+			if code := errorCode(t, w); w.Code != item.status || code != item.code {
+				t.Errorf("%s attempt %d was incorrect, got %d %s expected %d %s", email, i, w.Code, code, item.status, item.code)
+			}
+			if item.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Errorf("%s attempt %d is missing Retry-After", email, i)
+			}
+		}
+	}
+
+	audits, err := repo.ListLoginAudit(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := map[models.LoginAuditReason]int{}
+	for _, audit := range audits {
+		reasons[audit.Reason]++
+	}
+	// Un solo registro por bloqueo, los intentos rechazados no se auditan
+	if reasons[models.LoginAuditLockout] != 2 || len(audits) != 2 {
+		t.Errorf("audit was incorrect, got %v", reasons)
+	}
+}
+
+func TestLoginDelayAndReset(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	insertTestUser(t, repo, "ana@example.com", "correct-password")
+
+	s := newTestServer(t)
+	s.config.LoginLockout.BaseDelay = time.Hour
+	s.config.LoginLockout.MaxDelay = time.Hour
+	handler := LoginHandler(s)
+
+	login(handler, "ana@example.com", "wrong")
+	login(handler, "ana@example.com", "wrong")
+
+	// Despues de los intentos libres hay que esperar antes de volver a intentar
+	w := login(handler, "ana@example.com", "correct-password")
+	if code := errorCode(t, w); code != problem.CodeLoginLocked || w.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("delayed login was incorrect, got %d %s retry after %s", w.Code, code, w.Header().Get("Retry-After"))
+	}
+
+	// Al terminar la espera el login correcto olvida los fallos y queda auditado
+	s.config.LoginLockout.BaseDelay = time.Nanosecond
+	s.config.LoginLockout.MaxDelay = time.Nanosecond
+	handler = LoginHandler(s)
+
+	if w := login(handler, "ana@example.com", "correct-password"); w.Code != http.StatusOK {
+		t.Fatalf("login after the delay was incorrect, got %d", w.Code)
+	}
+	if _, err := repo.GetLoginAttempt(context.Background(), lockout.EmailKey("ana@example.com")); err != repository.ErrNotFound {
+		t.Errorf("account failures were not reset, got %v", err)
+	}
+	if _, err := repo.GetLoginAttempt(context.Background(), lockout.IPKey("192.0.2.1")); err != nil {
+		t.Errorf("ip failures should be kept, got %v", err)
+	}
+
+	audits, err := repo.ListLoginAudit(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 1 || audits[0].Reason != models.LoginAuditAfterFailure || audits[0].UserId != "ana" {
+		t.Errorf("audit was incorrect, got %+v", audits)
+	}
+}
+
+func TestLoginConcurrentAttempts(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	insertTestUser(t, repo, "ana@example.com", "correct-password")
+
+	s := newTestServer(t)
+	s.config.LoginLockout.BaseDelay = time.Hour
+	s.config.LoginLockout.MaxDelay = time.Hour
+	handler := LoginHandler(s)
+
+	// Todos los intentos llegan antes de que termine el primero, solo los intentos libres verifican la contraseña
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	statuses := map[int]int{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := login(handler, "ana@example.com", "wrong")
+			mutex.Lock()
+			statuses[w.Code]++
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if statuses[http.StatusUnauthorized] != 2 || statuses[http.StatusTooManyRequests] != 8 {
+		t.Errorf("concurrent logins were incorrect, got %v expected 2 unauthorized and 8 locked", statuses)
+	}
+
+	attempt, err := repo.GetLoginAttempt(context.Background(), lockout.EmailKey("ana@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt.Failures != 2 {
+		t.Errorf("concurrent login failures were incorrect, got %d expected 2", attempt.Failures)
+	}
+}
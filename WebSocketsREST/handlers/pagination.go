@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/server"
+	"strconv"
+)
+
+/*
+	Paginacion con cursor para los listados del mas reciente al mas antiguo
+	El cliente envia ?limit=20 y, para las siguientes paginas, ?cursor=... con el next_cursor de la respuesta anterior
+	Tambien se envia la cabecera Link con la url de la siguiente pagina
+*/
+
+type CursorPageResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"` // Vacio en la ultima pagina
+}
+
+// Lee los parametros cursor y limit de la url, si no son validos responde con el error y devuelve false
+// query identifica el orden y los filtros de la peticion, vacio si el listado no tiene; un cursor de otra consulta
+// se rechaza porque su posicion no tiene sentido en este orden. Un limit mayor que Config.MaxPageSize se reduce al maximo
+func cursorParams(w http.ResponseWriter, r *http.Request, s server.Server, query string) (*models.Cursor, int, bool) {
+	var after *models.Cursor
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		cursor, err := models.ParseCursor(value)
+		if err != nil {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid cursor")
+			return nil, 0, false
+		}
+		if cursor.Query != query {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Cursor does not match the sort and filters of the request")
+			return nil, 0, false
+		}
+		after = cursor
+	}
+
+	limit := s.Config().PageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid limit")
+			return nil, 0, false
+		}
+		limit = parsed
+	}
+	if limit > s.Config().MaxPageSize {
+		limit = s.Config().MaxPageSize
+	}
+
+	return after, limit, true
+}
+
+// Responde una pagina, next es la posicion del ultimo elemento o nil si no hay mas paginas
+func writeCursorPage(w http.ResponseWriter, r *http.Request, s server.Server, items interface{}, next *models.Cursor) {
+	response := CursorPageResponse{Items: items}
+
+	if next != nil {
+		response.NextCursor = next.Encode()
+
+		query := r.URL.Query()
+		query.Set("cursor", response.NextCursor)
+		w.Header().Set("Link", "<"+s.Config().PublicURL+r.URL.Path+"?"+query.Encode()+`>; rel="next"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
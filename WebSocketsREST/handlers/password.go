@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"rest_ws/lockout"
+	"rest_ws/mailer"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"rest_ws/utils"
+	"rest_ws/validation"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+	Restablecimiento de contraseña
+	/password/forgot envia un enlace con un token opaco de un solo uso, en el repositorio solo se guarda su hash
+	El enlace abre Config.PasswordResetURL, por defecto el formulario que sirve GET /password/reset
+	POST /password/reset usa el token, cambia la contraseña y cierra todas las sesiones del usuario
+*/
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type PasswordResponse struct {
+	Message string `json:"message"`
+}
+
+func (request *ForgotPasswordRequest) Validate(rules validation.Rules) []problem.FieldError {
+	v := &validation.Validator{}
+	request.Email = validation.NormalizeEmail(request.Email)
+	v.Required("email", request.Email)
+	v.MaxLength("email", request.Email, validation.MaxEmailLength)
+	return v.Errors()
+}
+
+// La nueva contraseña debe cumplir la politica igual que en el registro
+func (request *ResetPasswordRequest) Validate(rules validation.Rules) []problem.FieldError {
+	v := &validation.Validator{}
+	v.Required("token", request.Token)
+	v.Password("password", request.Password, rules)
+	return v.Errors()
+}
+
+// Guarda un nuevo token de restablecimiento y envia el enlace por email
+func sendPasswordResetEmail(ctx context.Context, s server.Server, user *models.User) error {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	token, hash, err := utils.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = repository.InsertPasswordResetToken(ctx, &models.PasswordResetToken{
+		Id:        id.String(),
+		UserId:    user.Id,
+		TokenHash: hash,
+		ExpiresAt: now.Add(s.Config().PasswordResetTokenTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(s.Config().PasswordResetURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return s.Mailer().Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Open the following link to choose a new password:\n\n%s\n\nThe link expires in %s and can be used only once. If you did not ask for a new password you can ignore this email.\n",
+			link.String(), s.Config().PasswordResetTokenTTL),
+	})
+}
+
+// Siempre responde lo mismo, exista o no la cuenta, para no revelar que emails estan registrados
+func ForgotPasswordHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var request = ForgotPasswordRequest{}
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
+			return
+		}
+
+		// La busqueda, el token y el email se hacen en segundo plano, asi la respuesta tarda lo mismo exista o no la cuenta
+		// Los fallos solo se registran, responder con error revelaria que la cuenta existe
+		s.Background().Go(func(ctx context.Context) {
+			user, err := repository.FindUserByEmail(ctx, request.Email)
+			if errors.Is(err, repository.ErrNotFound) {
+				return
+			}
+			if err != nil {
+				log.Printf("Password reset lookup failed: %v", err)
+				return
+			}
+			if err := sendPasswordResetEmail(ctx, s, user); err != nil {
+				log.Printf("Password reset email to %s failed: %v", user.Id, err)
+			}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(PasswordResponse{Message: "If the account exists, an email with a reset link was sent"})
+	}
+}
+
+func ResetPasswordHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var request = ResetPasswordRequest{}
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		// El token se marca como usado y los tokens de refresco se revocan en la misma operacion
+		now := time.Now()
+		userId, err := repository.ResetPassword(r.Context(), utils.HashToken(request.Token), string(hashedPassword), now)
+		if errors.Is(err, repository.ErrNotFound) {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token")
+			return
+		}
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		user, err := repository.FindUserById(r.Context(), userId)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		// Quien recibio el enlace controla el email, asi que la cuenta queda verificada y desbloqueada
+		if err := repository.VerifyUserEmail(r.Context(), user.Id, now); err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+		if err := repository.ResetLoginAttempt(r.Context(), lockout.EmailKey(user.Email)); err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PasswordResponse{Message: "Password updated"})
+	}
+}
+
+// Formulario minimo al que lleva el enlace del email si no se configura otra pagina
+// Envia el token y la nueva contraseña como JSON a POST /password/reset
+var resetPasswordForm = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Reset your password</title>
+</head>
+<body>
+<form id="reset">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+<p id="result"></p>
+<script>
+document.getElementById("reset").addEventListener("submit", function (event) {
+	event.preventDefault();
+	var form = event.target;
+	fetch(window.location.pathname, {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({token: form.token.value, password: form.password.value})
+	}).then(function (response) {
+		return response.json().then(function (body) {
+			document.getElementById("result").textContent = response.ok ? body.message : (body.detail || body.title);
+		});
+	});
+});
+</script>
+</body>
+</html>
+`))
+
+// Sirve el formulario del enlace del email, el token solo se valida al enviar la nueva contraseña
+func ResetPasswordFormHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token")
+			return
+		}
+
+		// El token va en la url, no se debe guardar en caches ni enviar a otros sitios
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		if err := resetPasswordForm.Execute(w, token); err != nil {
+			log.Printf("Password reset form failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rest_ws/database"
+	"rest_ws/lockout"
+	"rest_ws/mailer"
+	"rest_ws/middleware"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Servidor de prueba cuyo mailer espera a que se cierre release antes de enviar
+type blockingMailServer struct {
+	*testServer
+	release chan struct{}
+}
+
+func (s *blockingMailServer) Mailer() mailer.Mailer {
+	return blockingMailer{mailer: s.mailer, release: s.release}
+}
+
+type blockingMailer struct {
+	mailer  mailer.Mailer
+	release chan struct{}
+}
+
+func (m blockingMailer) Send(ctx context.Context, message mailer.Message) error {
+	<-m.release
+	return m.mailer.Send(ctx, message)
+}
+
+func TestForgotPassword(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	s.config.PasswordResetTokenTTL = time.Hour
+	insertTestUser(t, repo, "known@example.com", "correct-password")
+
+	tables := []struct {
+		email string
+		sent  int
+	}{
+		{"Known@Example.com", 1},
+		{"unknown@example.com", 0},
+	}
+
+	// El email no se envia hasta que se cierra release, la respuesta no lo debe esperar
+	release := make(chan struct{})
+	handler := ForgotPasswordHandler(&blockingMailServer{testServer: s, release: release})
+	for _, item := range tables {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func(email string) {
+			done <- post(handler, "/password/forgot", ForgotPasswordRequest{Email: email})
+		}(item.email)
+
+		select {
+		case w := <-done:
+			if w.Code != http.StatusAccepted {
+				t.Errorf("forgot %s was incorrect, got %d expected %d", item.email, w.Code, http.StatusAccepted)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("forgot %s waited for the email", item.email)
+		}
+	}
+	close(release)
+	s.background.Wait(context.Background())
+
+	for _, item := range tables {
+		if sent := len(s.mailer.Messages(strings.ToLower(item.email))); sent != item.sent {
+			t.Errorf("forgot %s sent %d mails expected %d", item.email, sent, item.sent)
+		}
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	s.config.PasswordResetTokenTTL = time.Hour
+	user := insertTestUser(t, repo, "known@example.com", "correct-password")
+
+	// Sesion abierta antes del cambio de contraseña
+	w := login(LoginHandler(s), user.Email, "correct-password")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login was incorrect, got %d", w.Code)
+	}
+	var session LoginResponse
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un fallo previo no debe seguir contando despues del cambio
+	login(LoginHandler(s), user.Email, "wrong")
+
+	if w := post(ForgotPasswordHandler(s), "/password/forgot", ForgotPasswordRequest{Email: user.Email}); w.Code != http.StatusAccepted {
+		t.Fatalf("forgot was incorrect, got %d", w.Code)
+	}
+	s.background.Wait(context.Background())
+	token := tokenFromMail(t, s, user.Email, "/password/reset")
+
+	tables := []struct {
+		name     string
+		token    string
+		password string
+		status   int
+		code     string
+	}{
+		{"missing token", "", "new-password-1", http.StatusUnprocessableEntity, problem.CodeValidationFailed},
+		{"weak password", token, "short", http.StatusUnprocessableEntity, problem.CodeValidationFailed},
+		{"unknown token", "unknown", "new-password-1", http.StatusBadRequest, problem.CodeInvalidToken},
+		{"valid token", token, "new-password-1", http.StatusOK, ""},
+		{"used token", token, "new-password-2", http.StatusBadRequest, problem.CodeInvalidToken},
+	}
+
+	handler := ResetPasswordHandler(s)
+	for _, item := range tables {
+		w := post(handler, "/password/reset", ResetPasswordRequest{Token: item.token, Password: item.password})
+		if code := errorCode(t, w); w.Code != item.status || code != item.code {
+			t.Errorf("%s: got %d %s expected %d %s", item.name, w.Code, code, item.status, item.code)
+		}
+	}
+
+	// La sesion se abrio en el mismo segundo que el cambio, o antes, y su access token ya no sirve
+	if _, _, err := middleware.Authenticate(context.Background(), s, session.Token); err == nil {
+		t.Errorf("reset did not revoke the access token")
+	}
+
+	refresh, err := repo.GetRefreshTokenByHash(context.Background(), utils.HashToken(session.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refresh.RevokedAt == nil {
+		t.Errorf("reset did not revoke the refresh token")
+	}
+
+	if _, err := repo.GetLoginAttempt(context.Background(), lockout.EmailKey(user.Email)); err == nil {
+		t.Errorf("reset did not clear the failed logins")
+	}
+
+	stored, err := repo.FindUserById(context.Background(), user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Verified() || stored.PasswordChangedAt == nil {
+		t.Errorf("reset did not update the user, got %+v", stored)
+	}
+
+	logins := []struct {
+		password string
+		status   int
+	}{
+		{"correct-password", http.StatusUnauthorized},
+		{"new-password-1", http.StatusOK},
+	}
+	for _, item := range logins {
+		if w := login(LoginHandler(s), user.Email, item.password); w.Code != item.status {
+			t.Errorf("login with %s was incorrect, got %d expected %d", item.password, w.Code, item.status)
+		}
+	}
+
+	// Un login justo despues del cambio, aunque sea en el mismo segundo, da un token valido
+	w = login(LoginHandler(s), user.Email, "new-password-1")
+	var fresh LoginResponse
+	if err := json.NewDecoder(w.Body).Decode(&fresh); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := middleware.Authenticate(context.Background(), s, fresh.Token); err != nil {
+		t.Errorf("token issued right after the reset was rejected: %v", err)
+	}
+}
+
+// Extrae el enlace del ultimo email enviado a la direccion
+func linkFromMail(t *testing.T, s *testServer, to string, prefix string) *url.URL {
+	messages := s.mailer.Messages(to)
+	if len(messages) == 0 {
+		t.Fatalf("no mail was sent to %s", to)
+	}
+	body := messages[len(messages)-1].Body
+
+	start := strings.Index(body, prefix)
+	if start < 0 {
+		t.Fatalf("mail does not contain a %s link: %s", prefix, body)
+	}
+	link, err := url.Parse(strings.Fields(body[start:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+func TestResetPasswordLink(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	s.config.PasswordResetTokenTTL = time.Hour
+	user := insertTestUser(t, repo, "known@example.com", "correct-password")
+
+	router := mux.NewRouter()
+	router.HandleFunc("/password/reset", ResetPasswordFormHandler(s)).Methods("GET")
+	router.HandleFunc("/password/reset", ResetPasswordHandler(s)).Methods("POST")
+
+	// Se sigue el enlace del email: abre el formulario y el formulario envia la nueva contraseña
+	post(ForgotPasswordHandler(s), "/password/forgot", ForgotPasswordRequest{Email: user.Email})
+	s.background.Wait(context.Background())
+	link := linkFromMail(t, s, user.Email, s.config.PasswordResetURL+"?")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("reset link was incorrect, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), `name="token"`) || w.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("reset form was incorrect, got %s", w.Body.String())
+	}
+
+	w = post(router, link.Path, ResetPasswordRequest{Token: link.Query().Get("token"), Password: "new-password-1"})
+	if w.Code != http.StatusOK {
+		t.Errorf("reset from the link was incorrect, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/password/reset", nil))
+	if code := errorCode(t, w); w.Code != http.StatusBadRequest || code != problem.CodeInvalidToken {
+		t.Errorf("reset link without token was incorrect, got %d %s", w.Code, code)
+	}
+
+	// Con una pagina propia el enlace la usa y conserva sus parametros
+	s.config.PasswordResetURL = "https://app.example.com/reset?lang=es"
+	post(ForgotPasswordHandler(s), "/password/forgot", ForgotPasswordRequest{Email: user.Email})
+	s.background.Wait(context.Background())
+	link = linkFromMail(t, s, user.Email, "https://app.example.com/reset?")
+	if link.Query().Get("lang") != "es" || link.Query().Get("token") == "" {
+		t.Errorf("custom reset link was incorrect, got %s", link)
+	}
+}
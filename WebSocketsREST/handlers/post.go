@@ -3,11 +3,13 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"rest_ws/middleware"
 	"rest_ws/models"
+	"rest_ws/policy"
+	"rest_ws/problem"
 	"rest_ws/repository"
 	"rest_ws/server"
-	"rest_ws/utils"
-	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/segmentio/ksuid"
@@ -28,47 +30,48 @@ type UpdatePostResponse struct {
 
 func InsertPostHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token, err := utils.GetTokenFromHeader(r, s.Config().JWTSecret)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
 			return
 		}
 
-		user, err := utils.GetUserIdFromToken(r, token)
-		if err != nil || user == nil {
-			http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		var request = UpdateInsertPostRequest{}
+		if !decodeJSON(w, r, &request) {
 			return
 		}
-
-		var request = UpdateInsertPostRequest{}
-		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
 			return
 		}
 
 		id, err := ksuid.NewRandom()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
 		post := models.Post{
-			Id:      id.String(),
-			Content: request.Content,
-			UserID:  user.Id,
+			Id:        id.String(),
+			Content:   request.Content,
+			CreatedAt: time.Now().UTC(),
+			UserID:    user.Id,
 		}
 
-		err = repository.InsertPost(r.Context(), &post)
+		// Un post nuevo solo llega por WebSockets a los seguidores del autor, nadie puede estar suscrito todavia a posts:{id}
+		// El evento se guarda sin topicos y el relay lo entrega en los topicos privados de los seguidores por paginas
+		event, err := outboxEvent(user.Id, models.FeedPost(post))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
-		var postMessage = models.WebSocketMessage{
-			Type:    "post",
-			Payload: post,
+		err = repository.InsertPost(r.Context(), &post, event)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
 		}
-		s.Hub().Broadcast(postMessage, nil)
+		s.Outbox().Wake()
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(InsertPostResponse{
@@ -84,18 +87,13 @@ func GetPostByIdHandler(s server.Server) http.HandlerFunc {
 
 		id := mux.Vars(r)["id"]
 		if id == "" {
-			http.Error(w, "Invalid Id", http.StatusBadRequest)
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid id")
 			return
 		}
 
 		post, err := repository.GetPostById(r.Context(), id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if post == nil {
-			http.Error(w, "Post not found", http.StatusNotFound)
+			RepositoryError(w, r, err)
 			return
 		}
 
@@ -107,54 +105,53 @@ func GetPostByIdHandler(s server.Server) http.HandlerFunc {
 func UpdatePostHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		token, err := utils.GetTokenFromHeader(r, s.Config().JWTSecret)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-
-		user, err := utils.GetUserIdFromToken(r, token)
-		if err != nil || user == nil {
-			http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
 			return
 		}
 
 		id := mux.Vars(r)["id"]
 		if id == "" {
-			http.Error(w, "Invalid Id", http.StatusBadRequest)
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid id")
 			return
 		}
 
 		post, err := repository.GetPostById(r.Context(), id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			RepositoryError(w, r, err)
 			return
 		}
 
-		if post == nil {
-			http.Error(w, "Post not found", http.StatusNotFound)
+		if !policy.CanPost(user, policy.ActionUpdate, post) {
+			forbidden(w, r)
 			return
 		}
 
-		if post.UserID != user.Id {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		var request = UpdateInsertPostRequest{}
+		if !decodeJSON(w, r, &request) {
 			return
 		}
-
-		var request = UpdateInsertPostRequest{}
-		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
 			return
 		}
 
 		post.Content = request.Content
 
-		err = repository.UpdatePost(r.Context(), post)
+		event, err := outboxEvent(user.Id, models.PostUpdated(*post), postTopics(post.Id)...)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
+		err = repository.UpdatePost(r.Context(), post, event)
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+		s.Outbox().Wake()
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(UpdatePostResponse{
 			Message: "Post updated",
@@ -165,45 +162,41 @@ func UpdatePostHandler(s server.Server) http.HandlerFunc {
 func DeletePostHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		token, err := utils.GetTokenFromHeader(r, s.Config().JWTSecret)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-
-		user, err := utils.GetUserIdFromToken(r, token)
-		if err != nil || user == nil {
-			http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
 			return
 		}
 
 		id := mux.Vars(r)["id"]
 		if id == "" {
-			http.Error(w, "Invalid Id", http.StatusBadRequest)
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid id")
 			return
 		}
 
 		post, err := repository.GetPostById(r.Context(), id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			RepositoryError(w, r, err)
 			return
 		}
 
-		if post == nil {
-			http.Error(w, "Post not found", http.StatusNotFound)
+		if !policy.CanPost(user, policy.ActionDelete, post) {
+			forbidden(w, r)
 			return
 		}
 
-		if post.UserID != user.Id {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		event, err := outboxEvent(user.Id, models.PostDeleted{Id: post.Id, UserID: post.UserID}, postTopics(post.Id)...)
+		if err != nil {
+			problem.Internal(w, r, err)
 			return
 		}
 
-		err = repository.DeletePost(r.Context(), id)
+		err = repository.DeletePost(r.Context(), id, event)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			RepositoryError(w, r, err)
 			return
 		}
+		s.Outbox().Wake()
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(UpdatePostResponse{
@@ -212,33 +205,77 @@ func DeletePostHandler(s server.Server) http.HandlerFunc {
 	}
 }
 
+// Lista los posts con paginacion por cursor, admite los filtros author, since y until y el orden sort
 func ListPostsHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		token, err := utils.GetTokenFromHeader(r, s.Config().JWTSecret)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+		filter, ok := postFilterParams(w, r)
+		if !ok {
 			return
 		}
 
-		user, err := utils.GetUserIdFromToken(r, token)
-		if err != nil || user == nil {
-			http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		// El cursor queda ligado al orden y a los filtros, no se puede usar con otros
+		after, limit, ok := cursorParams(w, r, s, filter.Key())
+		if !ok {
 			return
 		}
 
-		pages, err := strconv.ParseUint(mux.Vars(r)["pages"], 10, 64)
+		// Se pide un elemento de mas para saber si hay otra pagina
+		posts, err := repository.ListPosts(r.Context(), filter, after, limit+1)
 		if err != nil {
-			http.Error(w, "Invalid Pages", http.StatusBadRequest)
+			RepositoryError(w, r, err)
 			return
 		}
-		posts, err := repository.ListPosts(r.Context(), pages)
+
+		posts, next := postsPage(posts, limit)
+		if next != nil {
+			next.Query = filter.Key()
+		}
+		writeCursorPage(w, r, s, posts, next)
+	}
+}
+
+// Lee los filtros del listado de posts, las fechas van en formato RFC 3339
+// Si no son validos responde con el error y devuelve false
+func postFilterParams(w http.ResponseWriter, r *http.Request) (models.PostFilter, bool) {
+	query := r.URL.Query()
+	filter := models.PostFilter{
+		UserId: query.Get("author"),
+		Order:  models.SortOrder(query.Get("sort")),
+	}
+
+	if filter.Order == "" {
+		filter.Order = models.SortDesc
+	}
+	if !filter.Order.Valid() {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid sort, must be asc or desc")
+		return filter, false
+	}
+
+	for _, param := range []struct {
+		name  string
+		value **time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid "+param.name+", must be an RFC 3339 date")
+			return filter, false
 		}
+		parsed = parsed.UTC()
+		*param.value = &parsed
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(posts)
+	if filter.Since != nil && filter.Until != nil && !filter.Since.Before(*filter.Until) {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid date range, since must be before until")
+		return filter, false
 	}
+
+	return filter, true
 }
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"rest_ws/database"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestListPosts(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	s.config.PageSize = 2
+	s.config.MaxPageSize = 3
+
+	router := mux.NewRouter()
+	router.HandleFunc("/posts", ListPostsHandler(s)).Methods("GET")
+
+	alice := insertTestUser(t, repo, "alice@example.com", "correct-password")
+	bob := insertTestUser(t, repo, "bob@example.com", "correct-password")
+
+	// Un post por hora alternando autores, de post-0 (el mas antiguo) a post-5
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, user := range []*models.User{alice, bob, alice, bob, alice, alice} {
+		post := &models.Post{Id: fmt.Sprintf("post-%d", i), Content: "post", CreatedAt: start.Add(time.Duration(i) * time.Hour), UserID: user.Id}
+		if err := repo.InsertPost(context.Background(), post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errorTables := []struct {
+		query string
+		code  string
+	}{
+		{"?sort=newest", problem.CodeInvalidRequest},
+		{"?since=yesterday", problem.CodeInvalidRequest},
+		{"?until=2024-01-01", problem.CodeInvalidRequest},
+		{"?since=2024-01-01T03:00:00Z&until=2024-01-01T01:00:00Z", problem.CodeInvalidRequest},
+		{"?cursor=x", problem.CodeInvalidRequest},
+		{"?limit=-1", problem.CodeInvalidRequest},
+	}
+
+	for _, item := range errorTables {
+		w := requestAs(router, alice, http.MethodGet, "/posts"+item.query, nil)
+		if code := errorCode(t, w); w.Code != http.StatusBadRequest || code != item.code {
+			t.Errorf("list posts%s was incorrect, got %d %s expected %d %s", item.query, w.Code, code, http.StatusBadRequest, item.code)
+		}
+	}
+
+	tables := []struct {
+		query    string
+		expected string
+	}{
+		{"", "post-5,post-4,post-3,post-2,post-1,post-0"},
+		{"?sort=asc", "post-0,post-1,post-2,post-3,post-4,post-5"},
+		{"?limit=10", "post-5,post-4,post-3,post-2,post-1,post-0"},
+		{"?author=bob", "post-3,post-1"},
+		{"?author=alice&sort=asc", "post-0,post-2,post-4,post-5"},
+		{"?since=2024-01-01T02:00:00Z&until=2024-01-01T05:00:00Z", "post-4,post-3,post-2"},
+		{"?since=2024-01-01T03:00:00%2B01:00", "post-5,post-4,post-3,post-2"},
+		{"?author=missing", ""},
+	}
+
+	for _, item := range tables {
+		// Se siguen las cabeceras Link hasta la ultima pagina, deben conservar los filtros
+		var ids []string
+		path := "/posts" + item.query
+		for pages := 0; path != ""; pages++ {
+			if pages > 10 {
+				t.Fatalf("list posts%s did not reach the last page", item.query)
+			}
+			w := requestAs(router, alice, http.MethodGet, path, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("list posts %s was incorrect, got status %d", path, w.Code)
+			}
+			link := w.Header().Get("Link")
+
+			var posts []*models.Post
+			next := decodePage(t, w.Body, &posts)
+			if len(posts) > s.config.MaxPageSize {
+				t.Errorf("list posts %s was incorrect, got %d posts expected at most %d", path, len(posts), s.config.MaxPageSize)
+			}
+			for _, post := range posts {
+				ids = append(ids, post.Id)
+			}
+
+			path = ""
+			if next != "" {
+				if !strings.HasPrefix(link, "<"+s.config.PublicURL+"/posts?") || !strings.HasSuffix(link, `>; rel="next"`) {
+					t.Fatalf("Link header was incorrect, got %s", link)
+				}
+				parsed, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if parsed.Query().Get("cursor") != next {
+					t.Errorf("Link header cursor was incorrect, got %s expected %s", parsed.Query().Get("cursor"), next)
+				}
+				path = parsed.RequestURI()
+			} else if link != "" {
+				t.Errorf("last page of list posts%s should not have a Link header", item.query)
+			}
+		}
+
+		if got := strings.Join(ids, ","); got != item.expected {
+			t.Errorf("list posts%s was incorrect, got %s expected %s", item.query, got, item.expected)
+		}
+	}
+
+	// Un cursor solo sirve con el mismo orden y los mismos filtros
+	w := requestAs(router, alice, http.MethodGet, "/posts?author=alice&since=2024-01-01T01:00:00Z", nil)
+	var posts []*models.Post
+	next := url.QueryEscape(decodePage(t, w.Body, &posts))
+	feed := url.QueryEscape(models.NewCursor(start, "post-3").Encode())
+
+	cursorTables := []struct {
+		query  string
+		status int
+	}{
+		{"?author=alice&since=2024-01-01T01:00:00Z&cursor=" + next, http.StatusOK},
+		{"?since=2024-01-01T02:00:00%2B01:00&author=alice&sort=desc&cursor=" + next, http.StatusOK},
+		{"?author=alice&since=2024-01-01T01:00:00Z&sort=asc&cursor=" + next, http.StatusBadRequest},
+		{"?author=bob&since=2024-01-01T01:00:00Z&cursor=" + next, http.StatusBadRequest},
+		{"?author=alice&cursor=" + next, http.StatusBadRequest},
+		{"?author=alice&since=2024-01-01T01:00:00Z&until=2024-01-02T00:00:00Z&cursor=" + next, http.StatusBadRequest},
+		// Los cursores del feed no tienen consulta
+		{"?cursor=" + feed, http.StatusBadRequest},
+	}
+
+	for _, item := range cursorTables {
+		w := requestAs(router, alice, http.MethodGet, "/posts"+item.query, nil)
+		if code := errorCode(t, w); w.Code != item.status || (item.status == http.StatusBadRequest && code != problem.CodeInvalidRequest) {
+			t.Errorf("list posts%s was incorrect, got %d %s expected %d", item.query, w.Code, code, item.status)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"rest_ws/middleware"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+)
+
+/*
+	Reacciones a los posts, en /api/v1/posts/{id}/reactions
+	Cada usuario tiene como maximo una reaccion por post: PUT la agrega o la reemplaza y DELETE la quita
+	Las dos operaciones son idempotentes y solo generan un evento si la reaccion cambio
+*/
+
+type ReactionRequest struct {
+	Reaction models.ReactionKind `json:"reaction"`
+}
+
+type ReactionResponse struct {
+	PostId    string                `json:"post_id"`
+	Reaction  models.ReactionKind   `json:"reaction,omitempty"` // Reaccion del usuario, vacia si no tiene
+	Reactions models.ReactionCounts `json:"reactions"`
+}
+
+func SetReactionHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request = ReactionRequest{}
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
+			return
+		}
+
+		changeReaction(w, r, s, request.Reaction)
+	}
+}
+
+func DeleteReactionHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changeReaction(w, r, s, "")
+	}
+}
+
+// Guarda la reaccion del usuario al post de la url, una reaccion vacia la quita
+func changeReaction(w http.ResponseWriter, r *http.Request, s server.Server, reaction models.ReactionKind) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		unauthorized(w, r)
+		return
+	}
+
+	post, ok := postFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// El evento lleva los totales que calcula el repositorio dentro de la transaccion
+	event := func(counts models.ReactionCounts) (*models.OutboxMessage, error) {
+		return outboxEvent(user.Id, models.PostReacted{
+			PostId:    post.Id,
+			UserId:    user.Id,
+			Reaction:  reaction,
+			Reactions: counts,
+		}, postTopics(post.Id)...)
+	}
+
+	var counts models.ReactionCounts
+	var err error
+	if reaction == "" {
+		counts, err = repository.DeleteReaction(r.Context(), post.Id, user.Id, event)
+	} else {
+		counts, err = repository.SetReaction(r.Context(), post.Id, user.Id, reaction, event)
+	}
+	if err != nil {
+		RepositoryError(w, r, err)
+		return
+	}
+	s.Outbox().Wake()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReactionResponse{
+		PostId:    post.Id,
+		Reaction:  reaction,
+		Reactions: counts,
+	})
+}
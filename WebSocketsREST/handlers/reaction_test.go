@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"rest_ws/database"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestReactions(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/posts/{id}", GetPostByIdHandler(s)).Methods("GET")
+	router.HandleFunc("/posts/{id}/reactions", SetReactionHandler(s)).Methods("PUT")
+	router.HandleFunc("/posts/{id}/reactions", DeleteReactionHandler(s)).Methods("DELETE")
+
+	alice := insertTestUser(t, repo, "alice@example.com", "correct-password")
+	bob := insertTestUser(t, repo, "bob@example.com", "correct-password")
+	if err := repo.InsertPost(context.Background(), &models.Post{Id: "post", Content: "post", UserID: alice.Id}); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		name     string
+		user     *models.User
+		method   string
+		path     string
+		body     interface{}
+		status   int
+		code     string
+		expected models.ReactionCounts
+	}{
+		{"missing reaction", alice, http.MethodPut, "/posts/post/reactions", ReactionRequest{}, http.StatusUnprocessableEntity, problem.CodeValidationFailed, nil},
+		{"unknown reaction", alice, http.MethodPut, "/posts/post/reactions", ReactionRequest{Reaction: "dislike"}, http.StatusUnprocessableEntity, problem.CodeValidationFailed, nil},
+		{"missing post", alice, http.MethodPut, "/posts/missing/reactions", ReactionRequest{Reaction: models.ReactionLike}, http.StatusNotFound, problem.CodeNotFound, nil},
+		{"alice likes", alice, http.MethodPut, "/posts/post/reactions", ReactionRequest{Reaction: models.ReactionLike}, http.StatusOK, "", models.ReactionCounts{models.ReactionLike: 1}},
+		{"alice likes again", alice, http.MethodPut, "/posts/post/reactions", ReactionRequest{Reaction: models.ReactionLike}, http.StatusOK, "", models.ReactionCounts{models.ReactionLike: 1}},
+		{"bob laughs", bob, http.MethodPut, "/posts/post/reactions", ReactionRequest{Reaction: models.ReactionLaugh}, http.StatusOK, "", models.ReactionCounts{models.ReactionLike: 1, models.ReactionLaugh: 1}},
+		{"alice removes", alice, http.MethodDelete, "/posts/post/reactions", nil, http.StatusOK, "", models.ReactionCounts{models.ReactionLaugh: 1}},
+	}
+
+	for _, item := range tables {
+		w := requestAs(router, item.user, item.method, item.path, item.body)
+		if code := errorCode(t, w); w.Code != item.status || code != item.code {
+			t.Errorf("%s: got %d %s expected %d %s", item.name, w.Code, code, item.status, item.code)
+			continue
+		}
+		if item.status != http.StatusOK {
+			continue
+		}
+		var response ReactionResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(response.Reactions, item.expected) {
+			t.Errorf("%s reactions were incorrect, got %v expected %v", item.name, response.Reactions, item.expected)
+		}
+	}
+
+	// Los totales se devuelven junto con el post
+	w := requestAs(router, bob, http.MethodGet, "/posts/post", nil)
+	var post models.Post
+	if err := json.NewDecoder(w.Body).Decode(&post); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(post.Reactions, models.ReactionCounts{models.ReactionLaugh: 1}) {
+		t.Errorf("post reactions were incorrect, got %v", post.Reactions)
+	}
+
+	// Solo los cambios generan eventos: alice likes, bob laughs y alice removes
+	messages, err := repo.ClaimOutboxMessages(context.Background(), 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Errorf("reaction events were incorrect, got %d expected 3", len(messages))
+	}
+	for _, message := range messages {
+		var event struct {
+			Type    models.EventType   `json:"type"`
+			Payload models.PostReacted `json:"payload"`
+		}
+		if err := json.Unmarshal(message.Event, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != models.EventPostReacted || event.Payload.PostId != "post" || event.Payload.Reactions == nil {
+			t.Errorf("reaction event was incorrect, got %+v", event)
+		}
+	}
+}
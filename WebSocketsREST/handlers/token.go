@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rest_ws/middleware"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"rest_ws/utils"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutResponse struct {
+	Message string `json:"message"`
+}
+
+// Genera un access token y un token de refresco de la familia indicada y guarda este ultimo en el repositorio
+func issueTokens(r *http.Request, s server.Server, user *models.User, familyId string) (*LoginResponse, error) {
+	accessToken, _, err := utils.NewAccessToken(user, s.Keys(), s.Config().AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRefreshToken(s, user.Id, familyId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repository.InsertRefreshToken(r.Context(), refreshToken.model); err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken.value,
+		ExpiresIn:    int64(s.Config().AccessTokenTTL / time.Second),
+	}, nil
+}
+
+type refreshToken struct {
+	value string               // Token que se entrega al cliente
+	model *models.RefreshToken // Registro que se guarda en el repositorio
+}
+
+func newRefreshToken(s server.Server, userId string, familyId string) (*refreshToken, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	value, hash, err := utils.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &refreshToken{
+		value: value,
+		model: &models.RefreshToken{
+			Id:        id.String(),
+			UserId:    userId,
+			FamilyId:  familyId,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(s.Config().RefreshTokenTTL),
+		},
+	}, nil
+}
+
+// Intercambia un token de refresco por un nuevo access token y un nuevo token de refresco
+// Si se presenta un token que ya fue rotado se asume que fue robado y se revoca toda su familia
+func RefreshTokenHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request = RefreshTokenRequest{}
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+
+		current, err := repository.GetRefreshTokenByHash(r.Context(), utils.HashToken(request.RefreshToken))
+		if errors.Is(err, repository.ErrNotFound) {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid refresh token")
+			return
+		}
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		if current.RevokedAt != nil {
+			revokeFamily(w, r, current.FamilyId)
+			return
+		}
+
+		if time.Now().After(current.ExpiresAt) {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidToken, "Refresh token expired")
+			return
+		}
+
+		// Se vuelve a cargar el usuario para que el nuevo token tenga su rol actual
+		user, err := repository.FindUserById(r.Context(), current.UserId)
+		if errors.Is(err, repository.ErrNotFound) {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid refresh token")
+			return
+		}
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		next, err := newRefreshToken(s, current.UserId, current.FamilyId)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		err = repository.RotateRefreshToken(r.Context(), current.Id, next.model)
+		if errors.Is(err, repository.ErrConflict) {
+			// Otra peticion roto el mismo token al mismo tiempo
+			revokeFamily(w, r, current.FamilyId)
+			return
+		}
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		accessToken, _, err := utils.NewAccessToken(user, s.Keys(), s.Config().AccessTokenTTL)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LoginResponse{
+			Token:        accessToken,
+			RefreshToken: next.value,
+			ExpiresIn:    int64(s.Config().AccessTokenTTL / time.Second),
+		})
+	}
+}
+
+func revokeFamily(w http.ResponseWriter, r *http.Request, familyId string) {
+	if err := repository.RevokeRefreshTokenFamily(r.Context(), familyId); err != nil {
+		RepositoryError(w, r, err)
+		return
+	}
+	problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidToken, "Refresh token reused")
+}
+
+// Revoca la familia del token de refresco y agrega el access token actual a la lista de tokens revocados
+func LogoutHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		claims, ok := middleware.ClaimsFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		// El cuerpo es opcional, sin token de refresco solo se revoca el access token
+		var request = RefreshTokenRequest{}
+		if !decodeOptionalJSON(w, r, &request) {
+			return
+		}
+
+		if request.RefreshToken != "" {
+			token, err := repository.GetRefreshTokenByHash(r.Context(), utils.HashToken(request.RefreshToken))
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				RepositoryError(w, r, err)
+				return
+			}
+
+			// Solo se revoca la familia si el token pertenece al usuario autenticado
+			if err == nil && token.UserId == user.Id {
+				if err := repository.RevokeRefreshTokenFamily(r.Context(), token.FamilyId); err != nil {
+					RepositoryError(w, r, err)
+					return
+				}
+			}
+		}
+
+		err := repository.RevokeToken(r.Context(), claims.Id, time.Unix(claims.ExpiresAt, 0))
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LogoutResponse{
+			Message: "Logged out",
+		})
+	}
+}
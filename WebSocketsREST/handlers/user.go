@@ -2,14 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"rest_ws/middleware"
 	"rest_ws/models"
+	"rest_ws/problem"
 	"rest_ws/repository"
 	"rest_ws/server"
-	"rest_ws/utils"
+	"rest_ws/websockets"
 	"time"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/segmentio/ksuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -30,27 +32,31 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`         // Access token de corta duracion
+	RefreshToken string `json:"refresh_token"` // Token opaco para obtener un nuevo access token
+	ExpiresIn    int64  `json:"expires_in"`    // Segundos de vida del access token
 }
 
 func SignUpHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request = SignUpRequest{}
-		err := json.NewDecoder(r.Body).Decode(&request)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
 			return
 		}
 
 		id, err := ksuid.NewRandom()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
@@ -58,13 +64,27 @@ func SignUpHandler(s server.Server) http.HandlerFunc {
 			Id:       id.String(),
 			Email:    request.Email,
 			Password: string(hashedPassword),
+			Role:     models.RoleUser,
+		}
+
+		event, err := outboxEvent(user.Id, models.UserCreated{Id: user.Id, Role: user.Role}, websockets.UsersTopic)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
 		}
 
-		err = repository.InsertUser(r.Context(), &user)
+		err = repository.InsertUser(r.Context(), &user, event)
+		if errors.Is(err, repository.ErrConflict) {
+			problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "Email is already registered")
+			return
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			RepositoryError(w, r, err)
 			return
 		}
+		s.Outbox().Wake()
+
+		sendVerificationEmailOrLog(r.Context(), s, &user)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(SignUpResponse{
@@ -79,46 +99,67 @@ func SignUpHandler(s server.Server) http.HandlerFunc {
 func LoginHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request = LoginRequest{}
-		err := json.NewDecoder(r.Body).Decode(&request)
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
+			return
+		}
+
+		now := time.Now()
+		attempts, wait, err := reserveLoginAttempt(r.Context(), s.Config().LoginLockout, request.Email, middleware.ClientIP(s, r), now)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			RepositoryError(w, r, err)
 			return
 		}
 
+		// Mientras la cuenta o la IP deba esperar no se verifica la contraseña, ni siquiera la correcta
+		// El bloqueo ya quedo auditado cuando empezo, los intentos rechazados no se auditan para que no llenen login_audit
+		if wait > 0 {
+			loginLocked(w, r, wait)
+			return
+		}
+
+		// Si el email no existe se compara contra un hash falso para que la respuesta tarde lo mismo
 		user, err := repository.FindUserByEmail(r.Context(), request.Email)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		hash := getDummyHash()
+		if err == nil {
+			hash = []byte(user.Password)
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			RepositoryError(w, r, err)
 			return
 		}
-		if user == nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+
+		if bcrypt.CompareHashAndPassword(hash, []byte(request.Password)) != nil || user == nil {
+			if err := attempts.fail(r.Context(), now); err != nil {
+				RepositoryError(w, r, err)
+				return
+			}
+			invalidCredentials(w, r)
 			return
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password))
-		if err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		if err := attempts.succeed(r.Context(), user.Id, now); err != nil {
+			RepositoryError(w, r, err)
 			return
 		}
 
-		claims := models.AppClaims{
-			UserId: user.Id,
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(2 * time.Hour * 24).Unix(),
-			},
+		// Cada login inicia una nueva familia de tokens de refresco
+		familyId, err := ksuid.NewRandom()
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		signedToken, err := token.SignedString([]byte(s.Config().JWTSecret))
+		response, err := issueTokens(r, s, user, familyId.String())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			RepositoryError(w, r, err)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(LoginResponse{
-			Token: signedToken,
-		})
+		json.NewEncoder(w).Encode(response)
 
 	}
 }
@@ -126,15 +167,9 @@ func LoginHandler(s server.Server) http.HandlerFunc {
 func MeHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		token, err := utils.GetTokenFromHeader(r, s.Config().JWTSecret)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-
-		user, err := utils.GetUserIdFromToken(r, token)
-		if err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		user, ok := middleware.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r)
 			return
 		}
 
@@ -143,3 +178,8 @@ func MeHandler(s server.Server) http.HandlerFunc {
 
 	}
 }
+
+// El mismo error para un email que no existe y para una contraseña incorrecta, asi no se puede saber que emails existen
+func invalidCredentials(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid email or password")
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/validation"
+	"strings"
+)
+
+// Cada peticion se valida con las reglas de la configuracion del servidor
+// Validate normaliza los campos en la misma peticion, por eso recibe un puntero
+
+func (request *SignUpRequest) Validate(rules validation.Rules) []problem.FieldError {
+	v := &validation.Validator{}
+	request.Email = validation.NormalizeEmail(request.Email)
+	v.Email("email", request.Email)
+	v.Password("password", request.Password, rules)
+	return v.Errors()
+}
+
+// En el login no se aplica la politica de contraseñas, las cuentas anteriores a la politica deben poder entrar
+func (request *LoginRequest) Validate(rules validation.Rules) []problem.FieldError {
+	v := &validation.Validator{}
+	request.Email = validation.NormalizeEmail(request.Email)
+	v.Required("email", request.Email)
+	v.MaxLength("email", request.Email, validation.MaxEmailLength)
+	v.Required("password", request.Password)
+	return v.Errors()
+}
+
+func (request *UpdateInsertPostRequest) Validate(rules validation.Rules) []problem.FieldError {
+	v := &validation.Validator{}
+	v.Text("content", request.Content, rules.PostMaxLength)
+	return v.Errors()
+}
+
+func (request *UpdateInsertCommentRequest) Validate(rules validation.Rules) []problem.FieldError {
+	v := &validation.Validator{}
+	v.Text("content", request.Content, rules.CommentMaxLength)
+	return v.Errors()
+}
+
+func (request *ReactionRequest) Validate(rules validation.Rules) []problem.FieldError {
+	v := &validation.Validator{}
+	v.Required("reaction", string(request.Reaction))
+	if request.Reaction != "" && !request.Reaction.Valid() {
+		kinds := make([]string, 0, len(models.ReactionKinds))
+		for _, kind := range models.ReactionKinds {
+			kinds = append(kinds, string(kind))
+		}
+		v.Add("reaction", validation.CodeInvalid, "Reaction must be one of "+strings.Join(kinds, ", "))
+	}
+	return v.Errors()
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"rest_ws/mailer"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"rest_ws/utils"
+	"rest_ws/validation"
+	"time"
+)
+
+/*
+	Verificacion del email de los usuarios
+	Al registrarse se envia un enlace con un token firmado a /verify, el token vence despues de
+	Config.VerificationTokenTTL y se puede pedir uno nuevo con /verify/resend
+*/
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+type VerificationResponse struct {
+	Message string `json:"message"`
+}
+
+func (request *ResendVerificationRequest) Validate(rules validation.Rules) []problem.FieldError {
+	v := &validation.Validator{}
+	request.Email = validation.NormalizeEmail(request.Email)
+	v.Required("email", request.Email)
+	v.MaxLength("email", request.Email, validation.MaxEmailLength)
+	return v.Errors()
+}
+
+// Envia el email con el enlace de verificacion
+func sendVerificationEmail(ctx context.Context, s server.Server, user *models.User) error {
+	token, err := utils.NewVerificationToken(user, s.Keys(), s.Config().VerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.Config().PublicURL + "/verify?token=" + url.QueryEscape(token)
+	return s.Mailer().Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Open the following link to verify your email:\n\n%s\n\nThe link expires in %s. If you did not create an account you can ignore this email.\n",
+			link, s.Config().VerificationTokenTTL),
+	})
+}
+
+func VerifyEmailHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		claims, err := utils.ParseVerificationToken(r.URL.Query().Get("token"), s.Keys())
+		if err != nil {
+			invalidVerificationToken(w, r)
+			return
+		}
+
+		// Si el usuario cambio su email el token ya no sirve
+		user, err := repository.FindUserById(r.Context(), claims.Subject)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && user.Email != claims.Email) {
+			invalidVerificationToken(w, r)
+			return
+		}
+		if err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		if err := repository.VerifyUserEmail(r.Context(), user.Id, time.Now()); err != nil {
+			RepositoryError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(VerificationResponse{Message: "Email verified"})
+	}
+}
+
+// Siempre responde lo mismo, exista o no la cuenta, para no revelar que emails estan registrados
+func ResendVerificationHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var request = ResendVerificationRequest{}
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		if fieldErrors := request.Validate(s.Config().Validation); len(fieldErrors) > 0 {
+			problem.Validation(w, r, fieldErrors)
+			return
+		}
+
+		// Igual que en /password/forgot la busqueda y el email se hacen en segundo plano y los fallos solo se registran,
+		// asi ni el tiempo ni el codigo de la respuesta revelan si la cuenta existe o ya esta verificada
+		s.Background().Go(func(ctx context.Context) {
+			user, err := repository.FindUserByEmail(ctx, request.Email)
+			if errors.Is(err, repository.ErrNotFound) {
+				return
+			}
+			if err != nil {
+				log.Printf("Verification lookup failed: %v", err)
+				return
+			}
+			if !user.Verified() {
+				sendVerificationEmailOrLog(ctx, s, user)
+			}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(VerificationResponse{Message: "If the account exists and is not verified, a new email was sent"})
+	}
+}
+
+func invalidVerificationToken(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired verification token")
+}
+
+// El registro no falla si no se puede enviar el email, el usuario puede pedir otro con /verify/resend
+func sendVerificationEmailOrLog(ctx context.Context, s server.Server, user *models.User) {
+	if err := sendVerificationEmail(ctx, s, user); err != nil {
+		log.Printf("Verification email to %s failed: %v", user.Id, err)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rest_ws/database"
+	"rest_ws/mailer"
+	"rest_ws/models"
+	"rest_ws/problem"
+	"rest_ws/repository"
+	"rest_ws/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func post(handler http.Handler, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(data))))
+	return w
+}
+
+func verify(handler http.Handler, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/verify?token="+url.QueryEscape(token), nil))
+	return w
+}
+
+// Extrae el token del enlace del ultimo email enviado a la direccion
+func tokenFromMail(t *testing.T, s *testServer, to string, path string) string {
+	messages := s.mailer.Messages(to)
+	if len(messages) == 0 {
+		t.Fatalf("no mail was sent to %s", to)
+	}
+	body := messages[len(messages)-1].Body
+
+	start := strings.Index(body, s.config.PublicURL+path+"?token=")
+	if start < 0 {
+		t.Fatalf("mail does not contain a %s link: %s", path, body)
+	}
+	link := strings.Fields(body[start:])[0]
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Query().Get("token")
+}
+
+func TestEmailVerification(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+
+	w := post(SignUpHandler(s), "/signup", SignUpRequest{Email: "Ana@Example.com", Password: "correct-horse-1"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("signup was incorrect, got %d", w.Code)
+	}
+
+	user, err := repo.FindUserByEmail(context.Background(), "ana@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Verified() {
+		t.Fatalf("new user should not be verified")
+	}
+
+	token := tokenFromMail(t, s, "ana@example.com", "/verify")
+
+	// Un access token no sirve como token de verificacion
+	accessToken, _, err := utils.NewAccessToken(user, s.keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := utils.NewVerificationToken(user, s.keys, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := utils.NewVerificationToken(&models.User{Id: user.Id, Email: "old@example.com"}, s.keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"missing token", "", http.StatusBadRequest},
+		{"access token", accessToken, http.StatusBadRequest},
+		{"expired token", expired, http.StatusBadRequest},
+		{"email changed", changed, http.StatusBadRequest},
+		{"valid token", token, http.StatusOK},
+		{"valid token again", token, http.StatusOK},
+	}
+
+	handler := VerifyEmailHandler(s)
+	for _, item := range tables {
+		w := verify(handler, item.token)
+		if w.Code != item.status {
+			t.Errorf("%s: got status %d expected %d", item.name, w.Code, item.status)
+		}
+		if item.status == http.StatusBadRequest {
+			if code := errorCode(t, w); code != problem.CodeInvalidToken {
+				t.Errorf("%s: got code %s expected %s", item.name, code, problem.CodeInvalidToken)
+			}
+		}
+	}
+
+	user, err = repo.FindUserById(context.Background(), user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Verified() {
+		t.Errorf("user should be verified")
+	}
+}
+
+// Servidor de prueba cuyo mailer siempre falla
+type failingMailServer struct {
+	*testServer
+}
+
+func (s *failingMailServer) Mailer() mailer.Mailer {
+	return failingMailer{}
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, message mailer.Message) error {
+	return errors.New("smtp down")
+}
+
+func TestResendVerification(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	s := newTestServer(t)
+	insertTestUser(t, repo, "pending@example.com", "correct-password")
+	verified := insertTestUser(t, repo, "verified@example.com", "correct-password")
+	if err := repo.VerifyUserEmail(context.Background(), verified.Id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		email string
+		sent  int
+	}{
+		{"Pending@Example.com", 1},
+		{"verified@example.com", 0},
+		{"unknown@example.com", 0},
+	}
+
+	// La respuesta es identica para las tres cuentas, aunque el envio falle o todavia no termine
+	release := make(chan struct{})
+	handlers := []http.Handler{
+		ResendVerificationHandler(&blockingMailServer{testServer: s, release: release}),
+		ResendVerificationHandler(&failingMailServer{testServer: s}),
+	}
+	var first *httptest.ResponseRecorder
+	for _, handler := range handlers {
+		for _, item := range tables {
+			w := post(handler, "/verify/resend", ResendVerificationRequest{Email: item.email})
+			if w.Code != http.StatusAccepted {
+				t.Errorf("resend %s was incorrect, got %d expected %d", item.email, w.Code, http.StatusAccepted)
+			}
+			if first == nil {
+				first = w
+				continue
+			}
+			if w.Body.String() != first.Body.String() || w.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+				t.Errorf("resend %s response was incorrect, got %s expected %s", item.email, w.Body.String(), first.Body.String())
+			}
+		}
+	}
+	close(release)
+	s.background.Wait(context.Background())
+
+	for _, item := range tables {
+		if sent := len(s.mailer.Messages(strings.ToLower(item.email))); sent != item.sent {
+			t.Errorf("resend %s sent %d mails expected %d", item.email, sent, item.sent)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package lockout
+
+/*
+	Politica de bloqueo de login contra ataques de fuerza bruta
+	Los fallos se cuentan por cuenta y por IP. Despues de FreeAttempts fallos cada nuevo intento debe esperar
+	un tiempo que se duplica con cada fallo, y al llegar al maximo la cuenta o la IP se bloquea por Duration
+
+	La IP tiene un maximo mas alto porque varios usuarios pueden compartirla, por ejemplo detras de un NAT
+	Los fallos se olvidan cuando pasa Duration sin nuevos fallos o cuando el usuario entra correctamente
+*/
+
+import (
+	"rest_ws/models"
+	"time"
+)
+
+type Policy struct {
+	FreeAttempts     int           // Fallos permitidos sin espera, 3 por defecto
+	BaseDelay        time.Duration // Espera despues del primer fallo que supera FreeAttempts, 1 segundo por defecto
+	MaxDelay         time.Duration // Espera maxima antes del bloqueo, 30 segundos por defecto
+	MaxFailures      int           // Fallos de una cuenta que la bloquean, 10 por defecto
+	MaxFailuresPerIP int           // Fallos desde una IP que la bloquean, 100 por defecto
+	Duration         time.Duration // Duracion del bloqueo y de la ventana en la que se cuentan los fallos, 15 minutos por defecto
+}
+
+func (p Policy) WithDefaults() Policy {
+	if p.FreeAttempts <= 0 {
+		p.FreeAttempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = time.Second
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 30 * time.Second
+	}
+	if p.MaxFailures <= 0 {
+		p.MaxFailures = 10
+	}
+	if p.MaxFailuresPerIP <= 0 {
+		p.MaxFailuresPerIP = 100
+	}
+	if p.Duration <= 0 {
+		p.Duration = 15 * time.Minute
+	}
+	return p
+}
+
+// Llaves con las que se guardan los intentos en el repositorio
+func EmailKey(email string) string {
+	return "email:" + email
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Fecha a partir de la cual se cuentan los fallos, los anteriores se olvidan
+func (p Policy) Since(now time.Time) time.Time {
+	return now.Add(-p.Duration)
+}
+
+// Indica si los fallos alcanzaron el maximo y la llave queda bloqueada
+func (p Policy) Locked(attempt *models.LoginAttempt, maxFailures int) bool {
+	return attempt != nil && attempt.Failures >= maxFailures
+}
+
+// Devuelve cuanto debe esperar la llave antes del proximo intento, 0 si puede intentar ahora
+func (p Policy) Wait(attempt *models.LoginAttempt, maxFailures int, now time.Time) time.Duration {
+	if attempt == nil || attempt.Failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.Duration
+	if attempt.Failures < maxFailures {
+		delay = p.BaseDelay
+		for i := p.FreeAttempts + 1; i < attempt.Failures && delay < p.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+
+	if wait := attempt.LastFailedAt.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package lockout
+
+import (
+	"rest_ws/models"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	policy := Policy{}.WithDefaults()
+	now := time.Unix(1000, 0)
+
+	tables := []struct {
+		failures int
+		ago      time.Duration
+		expected time.Duration
+	}{
+		{0, 0, 0},
+		{3, 0, 0},
+		// Despues de los intentos libres la espera se duplica con cada fallo
+		{4, 0, time.Second},
+		{5, 0, 2 * time.Second},
+		{6, 0, 4 * time.Second},
+		{6, time.Second, 3 * time.Second},
+		{6, 10 * time.Second, 0},
+		{9, 0, 30 * time.Second},
+		// Al llegar al maximo se bloquea por la duracion completa
+		{10, 0, 15 * time.Minute},
+		{10, 5 * time.Minute, 10 * time.Minute},
+		{25, 15 * time.Minute, 0},
+	}
+
+	for _, item := range tables {
+		attempt := &models.LoginAttempt{Failures: item.failures, LastFailedAt: now.Add(-item.ago)}
+		if got := policy.Wait(attempt, policy.MaxFailures, now); got != item.expected {
+			t.Errorf("Wait(%d failures, %v ago) was incorrect, got %v expected %v", item.failures, item.ago, got, item.expected)
+		}
+	}
+
+	if got := policy.Wait(nil, policy.MaxFailures, now); got != 0 {
+		t.Errorf("Wait without attempts was incorrect, got %v expected 0", got)
+	}
+
+	// La IP usa su propio maximo
+	attempt := &models.LoginAttempt{Failures: 10, LastFailedAt: now}
+	if got := policy.Wait(attempt, policy.MaxFailuresPerIP, now); got != 30*time.Second {
+		t.Errorf("Wait for an ip was incorrect, got %v expected %v", got, 30*time.Second)
+	}
+}
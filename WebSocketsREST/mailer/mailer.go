@@ -0,0 +1,142 @@
+package mailer
+
+/*
+	Envio de emails, los handlers solo conocen la interfaz Mailer
+	Implementaciones:
+		SMTPMailer   -> envia los emails a un servidor SMTP, para produccion
+		FileMailer   -> guarda cada email en un archivo .eml de un directorio, para desarrollo local
+		MemoryMailer -> guarda los emails en memoria, para pruebas
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // Texto plano
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var errInvalidHeader = errors.New("mail headers cannot contain line breaks")
+
+// Arma el email con sus cabeceras, se rechazan los saltos de linea para que no se puedan inyectar cabeceras
+func format(from string, message Message, date time.Time) ([]byte, error) {
+	for _, value := range []string{from, message.To, message.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errInvalidHeader
+		}
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "From: %s\r\n", from)
+	fmt.Fprintf(&buffer, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buffer, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("\r\n")
+	buffer.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buffer.Bytes(), nil
+}
+
+type SMTPMailer struct {
+	Addr     string // host:puerto del servidor SMTP
+	From     string
+	Username string // Opcional, sin usuario no se autentica
+	Password string
+}
+
+// net/smtp no acepta un contexto, el envio usa los tiempos maximos del servidor SMTP
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := format(m.From, message, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{message.To}, data)
+}
+
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Cada email se guarda en un archivo nuevo, el nombre empieza con la fecha para que se ordenen solos
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	now := time.Now()
+	data, err := format(m.From, message, now)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405"), ksuid.New().String())
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o644)
+}
+
+type MemoryMailer struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, message Message) error {
+	if _, err := format("", message, time.Now()); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Devuelve los emails enviados a la direccion, del mas antiguo al mas reciente
+func (m *MemoryMailer) Messages(to string) []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var messages []Message
+	for _, message := range m.messages {
+		if message.To == to {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
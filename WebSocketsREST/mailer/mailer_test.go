@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := format("no-reply@example.com", Message{To: "ana@example.com", Subject: "Verificación", Body: "line 1\nline 2"}, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "From: no-reply@example.com\r\n" +
+		"To: ana@example.com\r\n" +
+		"Subject: =?utf-8?q?Verificaci=C3=B3n?=\r\n" +
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"line 1\r\nline 2"
+	if string(data) != expected {
+		t.Errorf("format was incorrect, got %q expected %q", data, expected)
+	}
+
+	tables := []Message{
+		{To: "ana@example.com\r\nBcc: eve@example.com", Subject: "hi"},
+		{To: "ana@example.com", Subject: "hi\nBcc: eve@example.com"},
+	}
+	for _, message := range tables {
+		if _, err := format("no-reply@example.com", message, date); err != errInvalidHeader {
+			t.Errorf("format(%q, %q) was incorrect, got %v expected %v", message.To, message.Subject, err, errInvalidHeader)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer := &FileMailer{Dir: dir, From: "no-reply@example.com"}
+
+	for i := 0; i < 2; i++ {
+		if err := mailer.Send(context.Background(), Message{To: "ana@example.com", Subject: "hi", Body: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("FileMailer wrote %d files expected 2", len(files))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "To: ana@example.com\r\n") || !strings.HasSuffix(string(data), "\r\n\r\nhello") {
+		t.Errorf("FileMailer wrote %q", data)
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := NewMemoryMailer()
+	mailer.Send(context.Background(), Message{To: "ana@example.com", Subject: "first"})
+	mailer.Send(context.Background(), Message{To: "bob@example.com", Subject: "other"})
+	mailer.Send(context.Background(), Message{To: "ana@example.com", Subject: "second"})
+
+	messages := mailer.Messages("ana@example.com")
+	if len(messages) != 2 || messages[0].Subject != "first" || messages[1].Subject != "second" {
+		t.Errorf("Messages was incorrect, got %+v", messages)
+	}
+}
@@ -2,11 +2,22 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"rest_ws/handlers"
+	"rest_ws/lockout"
 	"rest_ws/middleware"
+	"rest_ws/models"
+	"rest_ws/ratelimit"
 	"rest_ws/server"
+	"rest_ws/validation"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -23,21 +34,223 @@ func main() {
 	// Se crean las variables de entorno
 	PORT := os.Getenv("PORT")
 	JWT_SECRET := os.Getenv("JWT_SECRET")
+	JWT_KEYS_DIR := os.Getenv("JWT_KEYS_DIR")
+	JWT_ACTIVE_KEY_ID := os.Getenv("JWT_ACTIVE_KEY_ID")
+	ALLOWED_ORIGINS := listEnv("ALLOWED_ORIGINS")
+	WS_SEND_BUFFER, err := intEnv("WS_SEND_BUFFER")
+	if err != nil {
+		log.Fatal(err)
+	}
+	WS_SLOW_CONSUMER := os.Getenv("WS_SLOW_CONSUMER")
+	WS_EVENT_LOG_SIZE, err := intEnv("WS_EVENT_LOG_SIZE")
+	if err != nil {
+		log.Fatal(err)
+	}
+	WS_BACKPLANE := os.Getenv("WS_BACKPLANE")
 	DATABASE_URL := os.Getenv("DATABASE_URL")
+	DATABASE_DRIVER := os.Getenv("DATABASE_DRIVER")
+	AUTO_MIGRATE := os.Getenv("AUTO_MIGRATE") == "true"
+	ACCESS_TOKEN_TTL, err := durationEnv("ACCESS_TOKEN_TTL")
+	if err != nil {
+		log.Fatal(err)
+	}
+	REFRESH_TOKEN_TTL, err := durationEnv("REFRESH_TOKEN_TTL")
+	if err != nil {
+		log.Fatal(err)
+	}
+	OUTBOX_POLL_INTERVAL, err := durationEnv("OUTBOX_POLL_INTERVAL")
+	if err != nil {
+		log.Fatal(err)
+	}
+	HTTP_READ_TIMEOUT, err := durationEnv("HTTP_READ_TIMEOUT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	HTTP_WRITE_TIMEOUT, err := durationEnv("HTTP_WRITE_TIMEOUT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	HTTP_IDLE_TIMEOUT, err := durationEnv("HTTP_IDLE_TIMEOUT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	SHUTDOWN_TIMEOUT, err := durationEnv("SHUTDOWN_TIMEOUT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	MAX_BODY_BYTES, err := intEnv("MAX_BODY_BYTES")
+	if err != nil {
+		log.Fatal(err)
+	}
+	PASSWORD_MIN_LENGTH, err := intEnv("PASSWORD_MIN_LENGTH")
+	if err != nil {
+		log.Fatal(err)
+	}
+	PASSWORD_MIN_CLASSES, err := intEnv("PASSWORD_MIN_CLASSES")
+	if err != nil {
+		log.Fatal(err)
+	}
+	POST_MAX_LENGTH, err := intEnv("POST_MAX_LENGTH")
+	if err != nil {
+		log.Fatal(err)
+	}
+	COMMENT_MAX_LENGTH, err := intEnv("COMMENT_MAX_LENGTH")
+	if err != nil {
+		log.Fatal(err)
+	}
+	PAGE_SIZE, err := intEnv("PAGE_SIZE")
+	if err != nil {
+		log.Fatal(err)
+	}
+	MAX_PAGE_SIZE, err := intEnv("MAX_PAGE_SIZE")
+	if err != nil {
+		log.Fatal(err)
+	}
+	RATE_LIMITS := map[string]ratelimit.Rule{}
+	for group, name := range map[string]string{
+		server.RateLimitAuth:      "RATE_LIMIT_AUTH",
+		server.RateLimitRead:      "RATE_LIMIT_READ",
+		server.RateLimitWrite:     "RATE_LIMIT_WRITE",
+		server.RateLimitWebSocket: "RATE_LIMIT_WEBSOCKET",
+	} {
+		rule, err := ratelimit.ParseRule(os.Getenv(name))
+		if err != nil {
+			log.Fatalf("invalid %s: %v", name, err)
+		}
+		RATE_LIMITS[group] = rule
+	}
+	TRUST_PROXY := os.Getenv("TRUST_PROXY") == "true"
+	LOGIN_MAX_FAILURES, err := intEnv("LOGIN_MAX_FAILURES")
+	if err != nil {
+		log.Fatal(err)
+	}
+	LOGIN_MAX_FAILURES_PER_IP, err := intEnv("LOGIN_MAX_FAILURES_PER_IP")
+	if err != nil {
+		log.Fatal(err)
+	}
+	LOGIN_LOCKOUT_DURATION, err := durationEnv("LOGIN_LOCKOUT_DURATION")
+	if err != nil {
+		log.Fatal(err)
+	}
+	PUBLIC_URL := os.Getenv("PUBLIC_URL")
+	MAIL_DRIVER := os.Getenv("MAIL_DRIVER")
+	MAIL_DIR := os.Getenv("MAIL_DIR")
+	MAIL_FROM := os.Getenv("MAIL_FROM")
+	SMTP_ADDR := os.Getenv("SMTP_ADDR")
+	SMTP_USERNAME := os.Getenv("SMTP_USERNAME")
+	SMTP_PASSWORD := os.Getenv("SMTP_PASSWORD")
+	REQUIRE_VERIFIED_EMAIL := os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+	VERIFICATION_TOKEN_TTL, err := durationEnv("VERIFICATION_TOKEN_TTL")
+	if err != nil {
+		log.Fatal(err)
+	}
+	PASSWORD_RESET_TOKEN_TTL, err := durationEnv("PASSWORD_RESET_TOKEN_TTL")
+	if err != nil {
+		log.Fatal(err)
+	}
+	PASSWORD_RESET_URL := os.Getenv("PASSWORD_RESET_URL")
+	WS_MAX_CONNECTIONS, err := intEnv("WS_MAX_CONNECTIONS")
+	if err != nil {
+		log.Fatal(err)
+	}
+	WS_MAX_CONNECTIONS_PER_USER, err := intEnv("WS_MAX_CONNECTIONS_PER_USER")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Subcomando para administrar las migraciones: rest-ws migrate up|down|status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(DATABASE_URL, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Subcomando para asignar roles, necesario para crear el primer admin: rest-ws role <email> <role>
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		if err := runRole(DATABASE_URL, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// Se crea el servidor REST y Websockets
 	s, err := server.NewServer(context.Background(), &server.Config{
 		Port:        PORT,
 		JWTSecret:   JWT_SECRET,
 		DatabaseUrl: DATABASE_URL,
+		Driver:      DATABASE_DRIVER,
+		AutoMigrate: AUTO_MIGRATE,
+
+		JWTKeysDir:     JWT_KEYS_DIR,
+		JWTActiveKeyId: JWT_ACTIVE_KEY_ID,
+		AllowedOrigins: ALLOWED_ORIGINS,
+
+		WebSocketSendBuffer:   WS_SEND_BUFFER,
+		WebSocketSlowConsumer: WS_SLOW_CONSUMER,
+		WebSocketEventLog:     WS_EVENT_LOG_SIZE,
+		WebSocketBackplane:    WS_BACKPLANE,
+
+		WebSocketMaxConnections:        WS_MAX_CONNECTIONS,
+		WebSocketMaxConnectionsPerUser: WS_MAX_CONNECTIONS_PER_USER,
+
+		ReadTimeout:     HTTP_READ_TIMEOUT,
+		WriteTimeout:    HTTP_WRITE_TIMEOUT,
+		IdleTimeout:     HTTP_IDLE_TIMEOUT,
+		ShutdownTimeout: SHUTDOWN_TIMEOUT,
+
+		OutboxPollInterval: OUTBOX_POLL_INTERVAL,
+
+		AccessTokenTTL:  ACCESS_TOKEN_TTL,
+		RefreshTokenTTL: REFRESH_TOKEN_TTL,
+
+		MaxBodyBytes: int64(MAX_BODY_BYTES),
+		Validation: validation.Rules{
+			PasswordMinLength:  PASSWORD_MIN_LENGTH,
+			PasswordMinClasses: PASSWORD_MIN_CLASSES,
+			PostMaxLength:      POST_MAX_LENGTH,
+			CommentMaxLength:   COMMENT_MAX_LENGTH,
+		},
+
+		RateLimits: RATE_LIMITS,
+		TrustProxy: TRUST_PROXY,
+
+		LoginLockout: lockout.Policy{
+			MaxFailures:      LOGIN_MAX_FAILURES,
+			MaxFailuresPerIP: LOGIN_MAX_FAILURES_PER_IP,
+			Duration:         LOGIN_LOCKOUT_DURATION,
+		},
+
+		PublicURL:            PUBLIC_URL,
+		MailDriver:           MAIL_DRIVER,
+		MailDir:              MAIL_DIR,
+		MailFrom:             MAIL_FROM,
+		SMTPAddr:             SMTP_ADDR,
+		SMTPUsername:         SMTP_USERNAME,
+		SMTPPassword:         SMTP_PASSWORD,
+		RequireVerifiedEmail: REQUIRE_VERIFIED_EMAIL,
+		VerificationTokenTTL: VERIFICATION_TOKEN_TTL,
+
+		PasswordResetTokenTTL: PASSWORD_RESET_TOKEN_TTL,
+		PasswordResetURL:      PASSWORD_RESET_URL,
+
+		PageSize:    PAGE_SIZE,
+		MaxPageSize: MAX_PAGE_SIZE,
 	})
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// El servidor se apaga de forma ordenada al recibir SIGINT (Ctrl+C) o SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Se inicia el servidor REST y Websockets
-	s.Start(BindRoutes)
+	if err := s.Start(ctx, BindRoutes); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
 
 }
 
@@ -49,20 +262,100 @@ func BindRoutes(s server.Server, r *mux.Router) {
 	// Se le aplica el middleware de autenticación
 	// A este middleware se le pasa el servidor para poder acceder a la configuración donde se encuentra la clave secreta
 	api.Use(middleware.CheckAuthMiddleware(s))
+	// Despues de autenticar, asi el limite de la API es por usuario y no por IP
+	api.Use(middleware.RateLimitAPI(s))
+
+	// Las rutas que reciben credenciales comparten un limite estricto por IP
+	authLimit := middleware.RateLimit(s, server.RateLimitAuth)
 
 	// Se crean las rutas que van sobre la raíz del servidor
 	r.HandleFunc("/", handlers.HomeHandler(s)).Methods("GET")
-	r.HandleFunc("/signup", handlers.SignUpHandler(s)).Methods("POST")
-	r.HandleFunc("/login", handlers.LoginHandler(s)).Methods("POST")
+	r.Handle("/signup", authLimit(handlers.SignUpHandler(s))).Methods("POST")
+	r.Handle("/login", authLimit(handlers.LoginHandler(s))).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", handlers.JWKSHandler(s)).Methods("GET")
+	r.Handle("/token/refresh", authLimit(handlers.RefreshTokenHandler(s))).Methods("POST")
+	r.Handle("/verify", authLimit(handlers.VerifyEmailHandler(s))).Methods("GET")
+	r.Handle("/verify/resend", authLimit(handlers.ResendVerificationHandler(s))).Methods("POST")
+	r.Handle("/password/forgot", authLimit(handlers.ForgotPasswordHandler(s))).Methods("POST")
+	r.HandleFunc("/password/reset", handlers.ResetPasswordFormHandler(s)).Methods("GET")
+	r.Handle("/password/reset", authLimit(handlers.ResetPasswordHandler(s))).Methods("POST")
+	r.Handle("/logout", middleware.CheckAuthMiddleware(s)(handlers.LogoutHandler(s))).Methods("POST")
 
 	// Se registran las rutas del middleware de autenticación
 	api.HandleFunc("/me", handlers.MeHandler(s)).Methods("GET")
-	api.HandleFunc("/posts", handlers.InsertPostHandler(s)).Methods("POST")
+	// Con REQUIRE_VERIFIED_EMAIL solo los usuarios verificados pueden publicar
+	verified := middleware.RequireVerifiedEmail(s)
+	api.HandleFunc("/posts", handlers.ListPostsHandler(s)).Methods("GET")
+	api.Handle("/posts", verified(handlers.InsertPostHandler(s))).Methods("POST")
 	api.HandleFunc("/posts/{id}", handlers.GetPostByIdHandler(s)).Methods("GET")
-	api.HandleFunc("/posts/{id}", handlers.UpdatePostHandler(s)).Methods("PUT")
+	api.Handle("/posts/{id}", verified(handlers.UpdatePostHandler(s))).Methods("PUT")
 	api.HandleFunc("/posts/{id}", handlers.DeletePostHandler(s)).Methods("DELETE")
+	api.HandleFunc("/feed", handlers.FeedHandler(s)).Methods("GET")
+	api.HandleFunc("/following/{id}", handlers.FollowHandler(s)).Methods("PUT")
+	api.HandleFunc("/following/{id}", handlers.UnfollowHandler(s)).Methods("DELETE")
+	// Se registran antes que las rutas de administracion para no pasar por RequireRole
+	api.HandleFunc("/users/{id}/followers", handlers.ListFollowersHandler(s)).Methods("GET")
+	api.HandleFunc("/users/{id}/following", handlers.ListFollowingHandler(s)).Methods("GET")
+	api.HandleFunc("/posts/{id}/reactions", handlers.SetReactionHandler(s)).Methods("PUT")
+	api.HandleFunc("/posts/{id}/reactions", handlers.DeleteReactionHandler(s)).Methods("DELETE")
+	api.HandleFunc("/posts/{id}/comments", handlers.ListCommentsHandler(s)).Methods("GET")
+	api.Handle("/posts/{id}/comments", verified(handlers.InsertCommentHandler(s))).Methods("POST")
+	api.Handle("/posts/{id}/comments/{commentId}", verified(handlers.UpdateCommentHandler(s))).Methods("PUT")
+	api.HandleFunc("/posts/{id}/comments/{commentId}", handlers.DeleteCommentHandler(s)).Methods("DELETE")
+
+	// Rutas de administracion de usuarios, solo para admins
+	admin := api.PathPrefix("/users").Subrouter()
+	admin.Use(middleware.RequireRole(models.RoleAdmin))
+	admin.HandleFunc("", handlers.ListUsersHandler(s)).Methods("GET")
+	admin.HandleFunc("/{id}/role", handlers.UpdateUserRoleHandler(s)).Methods("PUT")
+
+	// Auditoria de logins sospechosos, solo para admins
+	audit := api.PathPrefix("/audit").Subrouter()
+	audit.Use(middleware.RequireRole(models.RoleAdmin))
+	audit.HandleFunc("/logins", handlers.ListLoginAuditHandler(s)).Methods("GET")
+
+	// Se registran las rutas de websockets, las conexiones se autentican con el mismo token que la API
+	s.Hub().SetAuthenticator(middleware.WebSocketAuthenticator(s))
+	r.Handle("/ws", middleware.RateLimit(s, server.RateLimitWebSocket)(http.HandlerFunc(s.Hub().HandleWebSocket)))
+
+}
 
-	// Se registran las rutas de websockets
-	r.HandleFunc("/ws", s.Hub().HandleWebSocket)
+// Lee una duracion de las variables de entorno, por ejemplo "15m" o "720h"
+// Si la variable no existe se devuelve 0 para que el servidor use el valor por defecto
+func durationEnv(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
 
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return duration, nil
+}
+
+// Lee una lista separada por comas de las variables de entorno
+func listEnv(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Lee un entero de las variables de entorno, si la variable no existe se devuelve 0
+func intEnv(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return n, nil
 }
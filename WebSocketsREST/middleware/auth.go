@@ -1,9 +1,16 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"rest_ws/models"
+	"rest_ws/policy"
+	"rest_ws/problem"
+	"rest_ws/repository"
 	"rest_ws/server"
 	"rest_ws/utils"
+	"rest_ws/websockets"
 )
 
 var (
@@ -13,14 +20,156 @@ var (
 	}
 )
 
+// Tipo privado para la llave del contexto, asi ningun otro paquete puede sobrescribir el valor
+type contextKey string
+
+const (
+	userContextKey   contextKey = "user"
+	claimsContextKey contextKey = "claims"
+)
+
+// Devuelve una copia del contexto que contiene al usuario autenticado
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// Devuelve el usuario autenticado que el middleware guardo en el contexto de la peticion
+func UserFromContext(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(*models.User)
+	return user, ok && user != nil
+}
+
+// Devuelve las claims del access token con el que se autentico la peticion
+func ClaimsFromContext(ctx context.Context) (*models.AppClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*models.AppClaims)
+	return claims, ok && claims != nil
+}
+
+// Error de autenticacion, se responde siempre con 401
+// Cualquier otro error que devuelva Authenticate es un error interno
+type AuthError struct {
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Valida el token, verifica que no este revocado y carga al usuario
+// Se usa tanto en las peticiones REST como en las conexiones de WebSockets
+func Authenticate(ctx context.Context, s server.Server, tokenString string) (*models.User, *models.AppClaims, error) {
+	token, err := utils.ParseToken(tokenString, s.Keys())
+	if err != nil {
+		return nil, nil, &AuthError{Message: err.Error()}
+	}
+
+	// Los tokens revocados con logout se rechazan aunque todavia no hayan expirado
+	// Los access tokens no tienen audiencia, los tokens con audiencia son para otros usos como la verificacion de email
+	claims, ok := token.Claims.(*models.AppClaims)
+	if !ok || claims.Id == "" || claims.Audience != "" {
+		return nil, nil, &AuthError{Message: "Invalid token"}
+	}
+
+	revoked, err := repository.IsTokenRevoked(ctx, claims.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, &AuthError{Message: "Token revoked"}
+	}
+
+	user, err := utils.GetUserIdFromToken(ctx, token)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, &AuthError{Message: "Invalid credentials"}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Al cambiar la contraseña se cierran todas las sesiones abiertas
+	// Se compara con iat_ns, asi un login justo despues del cambio sigue sirviendo
+	// Los tokens sin iat_ns emitidos en el mismo segundo del cambio se rechazan porque no se sabe si son anteriores
+	if user.PasswordChangedAt != nil && !claims.IssuedAtTime().After(*user.PasswordChangedAt) {
+		return nil, nil, &AuthError{Message: "Token revoked"}
+	}
+
+	return user, claims, nil
+}
+
+// Valida el token una sola vez, carga al usuario y lo guarda en el contexto de la peticion
+// para que los handlers no tengan que volver a parsear el token
 func CheckAuthMiddleware(s server.Server) func(http.Handler) http.Handler {
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-			_, err := utils.GetTokenFromHeader(r, s.Config().JWTSecret)
+			user, claims, err := Authenticate(r.Context(), s, utils.TokenFromHeader(r))
+			var authErr *AuthError
+			if errors.As(err, &authErr) {
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, authErr.Message)
+				return
+			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
+				problem.Internal(w, r, err)
+				return
+			}
+
+			ctx := WithUser(r.Context(), user)
+			ctx = context.WithValue(ctx, claimsContextKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Autenticador para las conexiones de WebSockets, devuelve el id del usuario dueño del token
+func WebSocketAuthenticator(s server.Server) websockets.Authenticator {
+	return func(ctx context.Context, tokenString string) (string, error) {
+		user, _, err := Authenticate(ctx, s, tokenString)
+		if err != nil {
+			return "", err
+		}
+		return user.Id, nil
+	}
+}
+
+// Solo deja pasar a los usuarios autenticados que tengan alguno de los roles
+// Debe aplicarse despues de CheckAuthMiddleware
+func RequireRole(roles ...models.Role) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+				return
+			}
+
+			if !policy.HasRole(user, roles...) {
+				problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "You are not allowed to perform this action")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Con Config.RequireVerifiedEmail solo deja pasar a los usuarios que verificaron su email
+// Debe aplicarse despues de CheckAuthMiddleware
+func RequireVerifiedEmail(s server.Server) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+				return
+			}
+
+			if s.Config().RequireVerifiedEmail && !user.Verified() {
+				problem.Error(w, r, http.StatusForbidden, problem.CodeEmailNotVerified, "Verify your email before posting")
 				return
 			}
 
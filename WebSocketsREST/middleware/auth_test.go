@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rest_ws/auth"
+	"rest_ws/database"
+	"rest_ws/mailer"
+	"rest_ws/models"
+	"rest_ws/outbox"
+	"rest_ws/repository"
+	"rest_ws/server"
+	"rest_ws/utils"
+	"rest_ws/websockets"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type testServer struct {
+	config *server.Config
+	keys   *auth.KeyManager
+	mailer *mailer.MemoryMailer
+}
+
+func (s *testServer) Config() *server.Config {
+	return s.config
+}
+
+func (s *testServer) Hub() *websockets.Hub {
+	return nil
+}
+
+func (s *testServer) Keys() *auth.KeyManager {
+	return s.keys
+}
+
+func (s *testServer) Outbox() *outbox.Relay {
+	return nil
+}
+
+func (s *testServer) Mailer() mailer.Mailer {
+	return s.mailer
+}
+
+func (s *testServer) Background() *server.Background {
+	return nil
+}
+
+func newKeys(t *testing.T, secret string) *auth.KeyManager {
+	keys := auth.NewKeyManager()
+	if err := keys.AddKey(auth.NewHMACKey("test", []byte(secret)), true); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func signToken(t *testing.T, keys *auth.KeyManager, userId string) string {
+	signed, _, err := utils.NewAccessToken(&models.User{Id: userId}, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestCheckAuthMiddleware(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+
+	user := &models.User{Id: "user-1", Email: "user@example.com", Password: "hash"}
+	if err := repo.InsertUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := newKeys(t, "secret")
+	revoked, claims, err := utils.NewAccessToken(user, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RevokeToken(context.Background(), claims.Id, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Token firmado correctamente pero sin jti, no se puede revocar y por lo tanto no se acepta
+	withoutId, err := jwt.NewWithClaims(jwt.SigningMethodHS256, models.AppClaims{UserId: user.Id}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Token de verificacion de email firmado con la misma llave
+	verification, err := utils.NewVerificationToken(user, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// La contraseña se cambio despues de emitir el token
+	changedAt := time.Now().Add(time.Minute)
+	resetUser := &models.User{Id: "user-2", Email: "reset@example.com", Password: "hash", PasswordChangedAt: &changedAt}
+	if err := repo.InsertUser(context.Background(), resetUser); err != nil {
+		t.Fatal(err)
+	}
+
+	// La contraseña se cambio en el mismo segundo, un instante despues de emitir el token
+	sameSecondUser := &models.User{Id: "user-3", Email: "same@example.com", Password: "hash"}
+	sameSecond, sameSecondClaims, err := utils.NewAccessToken(sameSecondUser, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameSecondAt := sameSecondClaims.IssuedAtTime().Add(time.Nanosecond)
+	sameSecondUser.PasswordChangedAt = &sameSecondAt
+	if err := repo.InsertUser(context.Background(), sameSecondUser); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un token emitido un instante despues del cambio se acepta aunque sea en el mismo segundo
+	changedUser := &models.User{Id: "user-4", Email: "changed@example.com", Password: "hash"}
+	changed, changedClaims, err := utils.NewAccessToken(changedUser, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedBefore := changedClaims.IssuedAtTime().Add(-time.Nanosecond)
+	changedUser.PasswordChangedAt = &changedBefore
+	if err := repo.InsertUser(context.Background(), changedUser); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un token sin iat_ns del mismo segundo del cambio se rechaza, no se sabe si es anterior
+	legacyUser := &models.User{Id: "user-5", Email: "legacy@example.com", Password: "hash"}
+	legacyClaims := models.AppClaims{UserId: legacyUser.Id, StandardClaims: jwt.StandardClaims{Id: "legacy", IssuedAt: time.Now().Unix()}}
+	legacy, err := keys.Sign(&legacyClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyAt := time.Unix(legacyClaims.IssuedAt, int64(time.Second-1))
+	legacyUser.PasswordChangedAt = &legacyAt
+	if err := repo.InsertUser(context.Background(), legacyUser); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{config: &server.Config{JWTSecret: "secret"}, keys: keys}
+	handler := CheckAuthMiddleware(s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := UserFromContext(r.Context())
+		if !ok || (caller.Id != user.Id && caller.Id != changedUser.Id) {
+			t.Errorf("UserFromContext was incorrect, got %+v expected %s", caller, user.Id)
+		}
+	}))
+
+	tables := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"raw token", signToken(t, keys, user.Id), http.StatusOK},
+		{"bearer token", "Bearer " + signToken(t, keys, user.Id), http.StatusOK},
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong secret", "Bearer " + signToken(t, newKeys(t, "other"), user.Id), http.StatusUnauthorized},
+		{"revoked token", "Bearer " + revoked, http.StatusUnauthorized},
+		{"token without id", "Bearer " + withoutId, http.StatusUnauthorized},
+		{"unknown user", "Bearer " + signToken(t, keys, "missing"), http.StatusUnauthorized},
+		{"verification token", "Bearer " + verification, http.StatusUnauthorized},
+		{"issued before password change", "Bearer " + signToken(t, keys, resetUser.Id), http.StatusUnauthorized},
+		{"issued just before password change", "Bearer " + sameSecond, http.StatusUnauthorized},
+		{"issued just after password change", "Bearer " + changed, http.StatusOK},
+		{"issued without iat_ns in the same second as password change", "Bearer " + legacy, http.StatusUnauthorized},
+	}
+
+	for _, item := range tables {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+		if item.header != "" {
+			r.Header.Set("Authorization", item.header)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != item.status {
+			t.Errorf("%s: got status %d expected %d", item.name, w.Code, item.status)
+		}
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	verifiedAt := time.Now()
+
+	tables := []struct {
+		require bool
+		user    *models.User
+		status  int
+	}{
+		{false, &models.User{Id: "pending"}, http.StatusOK},
+		{true, &models.User{Id: "pending"}, http.StatusForbidden},
+		{true, &models.User{Id: "verified", VerifiedAt: &verifiedAt}, http.StatusOK},
+		{true, nil, http.StatusUnauthorized},
+	}
+
+	for _, item := range tables {
+		s := &testServer{config: &server.Config{RequireVerifiedEmail: item.require}}
+		handler := RequireVerifiedEmail(s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/posts", nil)
+		if item.user != nil {
+			r = r.WithContext(WithUser(r.Context(), item.user))
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != item.status {
+			t.Errorf("RequireVerifiedEmail(%v, %+v) was incorrect, got %d expected %d", item.require, item.user, w.Code, item.status)
+		}
+	}
+}
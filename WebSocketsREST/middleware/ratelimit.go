@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"rest_ws/problem"
+	"rest_ws/ratelimit"
+	"rest_ws/server"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Devuelve la IP del cliente
+// X-Forwarded-For solo se usa con TrustProxy, de lo contrario cualquier cliente podria elegir su IP
+// Se toma la ultima IP de la lista, la que agrego el proxy de confianza
+func ClientIP(s server.Server, r *http.Request) string {
+	if s.Config().TrustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Limita las peticiones de un grupo de rutas con la regla de la configuracion
+// Las peticiones autenticadas se limitan por usuario y las demas por IP, por eso debe aplicarse despues de CheckAuthMiddleware
+// Cada llamada crea su propio limitador, las rutas de un mismo grupo deben compartir el middleware
+func RateLimit(s server.Server, group string) func(http.Handler) http.Handler {
+	limiter := ratelimit.NewLimiter(s.Config().RateLimits[group])
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			key := "ip:" + ClientIP(s, r)
+			if user, ok := UserFromContext(r.Context()); ok {
+				key = "user:" + user.Id
+			}
+
+			result := limiter.Allow(key)
+			if result.Limit > 0 {
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+				w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+			}
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+				problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many requests")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Limita las lecturas y las escrituras de la API con reglas distintas
+func RateLimitAPI(s server.Server) func(http.Handler) http.Handler {
+	reads := RateLimit(s, server.RateLimitRead)
+	writes := RateLimit(s, server.RateLimitWrite)
+
+	return func(next http.Handler) http.Handler {
+		read, write := reads(next), writes(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				read.ServeHTTP(w, r)
+			default:
+				write.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// Las cabeceras usan segundos enteros, se redondea hacia arriba para que el cliente no reintente antes de tiempo
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rest_ws/models"
+	"rest_ws/ratelimit"
+	"rest_ws/server"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	tables := []struct {
+		trustProxy bool
+		forwarded  string
+		expected   string
+	}{
+		{false, "", "192.0.2.1"},
+		{false, "203.0.113.7", "192.0.2.1"},
+		{true, "203.0.113.7", "203.0.113.7"},
+		{true, "10.0.0.1, 203.0.113.7", "203.0.113.7"},
+		{true, "not-an-ip", "192.0.2.1"},
+		{true, "", "192.0.2.1"},
+	}
+
+	for _, item := range tables {
+		s := &testServer{config: &server.Config{TrustProxy: item.trustProxy}}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if item.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", item.forwarded)
+		}
+
+		if got := ClientIP(s, r); got != item.expected {
+			t.Errorf("ClientIP(%v, %q) was incorrect, got %s expected %s", item.trustProxy, item.forwarded, got, item.expected)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := &testServer{config: &server.Config{RateLimits: map[string]ratelimit.Rule{
+		server.RateLimitAuth: {Limit: 2, Window: time.Minute},
+	}}}
+	handler := RateLimit(s, server.RateLimitAuth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tables := []struct {
+		remoteAddr string
+		user       string
+		status     int
+		remaining  string
+	}{
+		{"192.0.2.1:1234", "", http.StatusOK, "1"},
+		{"192.0.2.1:5678", "", http.StatusOK, "0"},
+		{"192.0.2.1:1234", "", http.StatusTooManyRequests, "0"},
+		// Otra IP y un usuario autenticado desde la misma IP tienen su propio limite
+		{"192.0.2.2:1234", "", http.StatusOK, "1"},
+		{"192.0.2.1:1234", "user-1", http.StatusOK, "1"},
+	}
+
+	for i, item := range tables {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = item.remoteAddr
+		if item.user != "" {
+			r = r.WithContext(WithUser(r.Context(), &models.User{Id: item.user}))
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != item.status {
+			t.Errorf("request %d status was incorrect, got %d expected %d", i, w.Code, item.status)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != item.remaining {
+			t.Errorf("request %d RateLimit-Remaining was incorrect, got %s expected %s", i, got, item.remaining)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d RateLimit-Limit was incorrect, got %s expected 2", i, got)
+		}
+		if retryAfter := w.Header().Get("Retry-After"); (item.status == http.StatusTooManyRequests) != (retryAfter == "30") {
+			t.Errorf("request %d Retry-After was incorrect, got %q", i, retryAfter)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rest_ws/database"
+	"strconv"
+)
+
+// Ejecuta el subcomando migrate con la accion indicada en los argumentos
+//
+//	migrate up       -> aplica todas las migraciones pendientes
+//	migrate down [n] -> revierte las ultimas n migraciones (1 por defecto)
+//	migrate status   -> muestra las migraciones aplicadas y pendientes
+func runMigrate(databaseUrl string, args []string) error {
+	if databaseUrl == "" {
+		return errors.New("database url is required")
+	}
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [n]|status")
+	}
+
+	repo, err := database.NewPostgresRepository(databaseUrl)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	migrator, err := repo.Migrator()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range status {
+			applied := "pending"
+			if item.AppliedAt != nil {
+				applied = item.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", item.Version, item.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action %q", args[0])
+	}
+}
@@ -1,9 +1,37 @@
 package models
 
-import "github.com/golang-jwt/jwt"
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
 
 // Se hace una composicion con la estructura jwt.StandardClaims para poder agregar campos personalizados
+// El rol se incluye para que otros servicios puedan autorizar sin consultar la base de datos,
+// pero este servidor siempre usa el rol guardado en el repositorio
+// iat solo tiene precision de segundos, iat_ns guarda el momento exacto de emision
 type AppClaims struct {
-	UserId string `json:"user_id"`
+	UserId       string `json:"user_id"`
+	Role         Role   `json:"role,omitempty"`
+	IssuedAtNano int64  `json:"iat_ns,omitempty"`
+	jwt.StandardClaims
+}
+
+// Momento de emision del token, los tokens anteriores a iat_ns solo tienen el segundo
+func (c *AppClaims) IssuedAtTime() time.Time {
+	if c.IssuedAtNano != 0 {
+		return time.Unix(0, c.IssuedAtNano)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// Audiencia de los tokens de verificacion de email, ningun otro token la tiene
+const VerificationAudience = "email_verification"
+
+// Claims del token que se envia por email para verificar la direccion
+// El id del usuario va en Subject y no en user_id, y el token no tiene jti, asi nunca se acepta como access token
+// El email se incluye para que el token deje de servir si el usuario cambia su direccion
+type VerificationClaims struct {
+	Email string `json:"email"`
 	jwt.StandardClaims
 }
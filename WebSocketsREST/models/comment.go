@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Comentario de un usuario en un post, UpdatedAt es nil hasta la primera edicion
+type Comment struct {
+	Id        string     `json:"id"`
+	PostId    string     `json:"post_id"`
+	UserId    string     `json:"user_id"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Posicion de una pagina en un listado ordenado por (fecha, id)
+// El siguiente elemento es el primero despues de esta posicion en el orden del listado
+// Query identifica el orden y los filtros del listado, un cursor solo sirve para la misma consulta
+type Cursor struct {
+	CreatedAt time.Time
+	Id        string
+	Query     string // Vacio en los listados sin filtros, no puede tener "." ni "|"
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Resume las partes que identifican una consulta en un valor corto para Cursor.Query
+// El cursor no expone las partes y no crece con ellas
+func CursorQuery(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Crea el cursor que apunta despues del elemento
+func NewCursor(createdAt time.Time, id string) *Cursor {
+	return &Cursor{CreatedAt: createdAt.UTC(), Id: id}
+}
+
+// Codifica el cursor como un texto opaco para las urls, los clientes no deben interpretarlo
+// El formato es "fecha|id" o "fecha.query|id", el id va al final porque puede tener cualquier caracter
+func (c *Cursor) Encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixNano(), 10)
+	if c.Query != "" {
+		raw += "." + c.Query
+	}
+	raw += "|" + c.Id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Decodifica un cursor generado por Encode
+func ParseCursor(value string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	head, query := parts[0], ""
+	if i := strings.IndexByte(head, '.'); i >= 0 {
+		head, query = head[:i], head[i+1:]
+		if query == "" {
+			return nil, ErrInvalidCursor
+		}
+	}
+	nanos, err := strconv.ParseInt(head, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := NewCursor(time.Unix(0, nanos), parts[1])
+	cursor.Query = query
+	return cursor, nil
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	createdAt := time.Date(2022, 1, 2, 3, 4, 5, 123456789, time.UTC)
+
+	cursor, err := ParseCursor(NewCursor(createdAt, "post|1").Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.CreatedAt.Equal(createdAt) || cursor.Id != "post|1" {
+		t.Errorf("ParseCursor was incorrect, got %+v expected %v post|1", cursor, createdAt)
+	}
+
+	// La consulta viaja con el cursor
+	scoped := NewCursor(createdAt, "post|1")
+	scoped.Query = "0123abcd"
+	cursor, err = ParseCursor(scoped.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cursor != *scoped {
+		t.Errorf("ParseCursor with query was incorrect, got %+v expected %+v", cursor, scoped)
+	}
+
+	tables := []string{
+		"",
+		"not base64!",
+		"MTIz",         // "123", sin id
+		"YWJjfHBvc3Q",  // "abc|post", fecha invalida
+		"MTIzfA",       // "123|", id vacio
+		"MTIzLnxwb3N0", // "123.|post", consulta vacia
+	}
+
+	for _, value := range tables {
+		if _, err := ParseCursor(value); err != ErrInvalidCursor {
+			t.Errorf("ParseCursor(%q) got error %v expected %v", value, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestPostFilterKey(t *testing.T) {
+	since := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	sinceOtherZone := since.In(time.FixedZone("UTC+1", 3600))
+	until := since.Add(time.Hour)
+
+	base := PostFilter{UserId: "alice", Since: &since}.Key()
+
+	tables := []struct {
+		filter PostFilter
+		same   bool
+	}{
+		{PostFilter{UserId: "alice", Since: &since, Order: SortDesc}, true},
+		{PostFilter{UserId: "alice", Since: &sinceOtherZone}, true},
+		{PostFilter{UserId: "alice", Since: &since, Order: SortAsc}, false},
+		{PostFilter{UserId: "bob", Since: &since}, false},
+		{PostFilter{UserId: "alice"}, false},
+		{PostFilter{UserId: "alice", Until: &since}, false},
+		{PostFilter{UserId: "alice", Since: &since, Until: &until}, false},
+	}
+
+	for _, item := range tables {
+		if got := item.filter.Key() == base; got != item.same {
+			t.Errorf("Key(%+v) == Key(base) was incorrect, got %v expected %v", item.filter, got, item.same)
+		}
+	}
+}
+
+func TestCursorQuery(t *testing.T) {
+	base := CursorQuery("followers", "alice")
+
+	tables := []struct {
+		parts []string
+		same  bool
+	}{
+		{[]string{"followers", "alice"}, true},
+		{[]string{"following", "alice"}, false},
+		{[]string{"followers", "bob"}, false},
+		{[]string{"followers"}, false},
+	}
+
+	for _, item := range tables {
+		if got := CursorQuery(item.parts...) == base; got != item.same {
+			t.Errorf("CursorQuery(%v) == CursorQuery(base) was incorrect, got %v expected %v", item.parts, got, item.same)
+		}
+	}
+
+	// El resumen se guarda en el cursor, no puede tener "." ni "|"
+	if len(base) != 16 || strings.ContainsAny(base, ".|") {
+		t.Errorf("CursorQuery was incorrect, got %q", base)
+	}
+}
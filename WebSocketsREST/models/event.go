@@ -0,0 +1,121 @@
+package models
+
+/*
+	Catalogo de eventos que el servidor envia por WebSockets
+
+	Todos los eventos usan el mismo sobre versionado:
+
+		{
+			"type": "post.updated",
+			"version": 1,
+			"id": "...",              // Id unico del evento, sirve para descartar duplicados
+			"timestamp": "...",       // Momento en el que ocurrio el cambio, en UTC
+			"actor": "...",           // Id del usuario que hizo el cambio
+			"payload": {...}          // Depende del tipo del evento
+		}
+
+	Si el formato de un payload cambia de forma incompatible se incrementa EventVersion
+*/
+
+import (
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// Version del formato de los eventos
+const EventVersion = 1
+
+type EventType string
+
+const (
+	EventPostUpdated EventType = "post.updated" // Payload: PostUpdated
+	EventPostDeleted EventType = "post.deleted" // Payload: PostDeleted
+	EventPostReacted EventType = "post.reacted" // Payload: PostReacted
+	EventUserCreated EventType = "user.created" // Payload: UserCreated
+	EventFeedPost    EventType = "feed.post"    // Payload: FeedPost
+
+	EventCommentCreated EventType = "comment.created" // Payload: CommentCreated
+	EventCommentUpdated EventType = "comment.updated" // Payload: CommentUpdated
+	EventCommentDeleted EventType = "comment.deleted" // Payload: CommentDeleted
+)
+
+// Cada payload del catalogo indica a que tipo de evento pertenece
+type EventPayload interface {
+	EventType() EventType
+}
+
+type Event struct {
+	Type      EventType    `json:"type"`
+	Version   int          `json:"version"`
+	Id        string       `json:"id"`
+	Timestamp time.Time    `json:"timestamp"`
+	Actor     string       `json:"actor,omitempty"`
+	Payload   EventPayload `json:"payload"`
+}
+
+// Crea un evento con un id nuevo, el tipo se toma del payload
+func NewEvent(actor string, payload EventPayload) *Event {
+	return &Event{
+		Type:      payload.EventType(),
+		Version:   EventVersion,
+		Id:        ksuid.New().String(),
+		Timestamp: time.Now().UTC(),
+		Actor:     actor,
+		Payload:   payload,
+	}
+}
+
+// El post completo despues de la actualizacion
+type PostUpdated Post
+
+func (PostUpdated) EventType() EventType { return EventPostUpdated }
+
+type PostDeleted struct {
+	Id     string `json:"id"`
+	UserID string `json:"user_id"`
+}
+
+func (PostDeleted) EventType() EventType { return EventPostDeleted }
+
+// Post nuevo de un usuario seguido, solo se envia a los seguidores del autor
+// Es el unico evento de un post nuevo, nadie puede estar suscrito a su topico antes de crearlo
+type FeedPost Post
+
+func (FeedPost) EventType() EventType { return EventFeedPost }
+
+// Un usuario agrego, cambio o quito su reaccion, Reactions son los totales despues del cambio
+type PostReacted struct {
+	PostId    string         `json:"post_id"`
+	UserId    string         `json:"user_id"`
+	Reaction  ReactionKind   `json:"reaction,omitempty"` // Vacio si el usuario quito su reaccion
+	Reactions ReactionCounts `json:"reactions"`
+}
+
+func (PostReacted) EventType() EventType { return EventPostReacted }
+
+// No incluye el email para no exponerlo a los demas usuarios
+type UserCreated struct {
+	Id   string `json:"id"`
+	Role Role   `json:"role"`
+}
+
+func (UserCreated) EventType() EventType { return EventUserCreated }
+
+// El comentario completo tal como quedo guardado
+type CommentCreated Comment
+
+func (CommentCreated) EventType() EventType { return EventCommentCreated }
+
+// El comentario completo despues de la edicion
+type CommentUpdated Comment
+
+func (CommentUpdated) EventType() EventType { return EventCommentUpdated }
+
+type CommentDeleted struct {
+	Id     string `json:"id"`
+	PostId string `json:"post_id"`
+	UserId string `json:"user_id"`
+}
+
+func (CommentDeleted) EventType() EventType { return EventCommentDeleted }
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewEvent(t *testing.T) {
+	createdAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	post := Post{Id: "post", Content: "hello", CreatedAt: createdAt, UserID: "alice"}
+	comment := Comment{Id: "comment", PostId: "post", UserId: "alice", Content: "hi", CreatedAt: createdAt}
+
+	tables := []struct {
+		payload  EventPayload
+		expected EventType
+		json     string
+	}{
+		{FeedPost(post), EventFeedPost, `{"id":"post","content":"hello","created_at":"2022-01-02T03:04:05Z","user_id":"alice"}`},
+		{PostUpdated(post), EventPostUpdated, `{"id":"post","content":"hello","created_at":"2022-01-02T03:04:05Z","user_id":"alice"}`},
+		{PostDeleted{Id: "post", UserID: "alice"}, EventPostDeleted, `{"id":"post","user_id":"alice"}`},
+		{UserCreated{Id: "bob", Role: RoleUser}, EventUserCreated, `{"id":"bob","role":"user"}`},
+		{PostReacted{PostId: "post", UserId: "bob", Reaction: ReactionLike, Reactions: ReactionCounts{ReactionLike: 2}}, EventPostReacted, `{"post_id":"post","user_id":"bob","reaction":"like","reactions":{"like":2}}`},
+		{CommentCreated(comment), EventCommentCreated, `{"id":"comment","post_id":"post","user_id":"alice","content":"hi","created_at":"2022-01-02T03:04:05Z"}`},
+		{CommentDeleted{Id: "comment", PostId: "post", UserId: "alice"}, EventCommentDeleted, `{"id":"comment","post_id":"post","user_id":"alice"}`},
+	}
+
+	for _, item := range tables {
+		event := NewEvent("alice", item.payload)
+		if event.Type != item.expected || event.Version != EventVersion || event.Id == "" || event.Actor != "alice" {
+			t.Errorf("NewEvent was incorrect, got %+v expected type %s", event, item.expected)
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{"type", "version", "id", "timestamp", "actor", "payload"} {
+			if _, ok := envelope[field]; !ok {
+				t.Errorf("%s envelope is missing %s", item.expected, field)
+			}
+		}
+		if got := string(envelope["payload"]); got != item.json {
+			t.Errorf("%s payload was incorrect, got %s expected %s", item.expected, got, item.json)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// El usuario FollowerId sigue al usuario FolloweeId
+type Follow struct {
+	FollowerId string    `json:"follower_id"`
+	FolloweeId string    `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Intentos fallidos de login de una llave, la llave es "email:<email>" o "ip:<ip>"
+// Los fallos anteriores a la ventana de la politica de bloqueo se olvidan
+type LoginAttempt struct {
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+}
+
+type LoginAuditReason string
+
+const (
+	LoginAuditLockout      LoginAuditReason = "lockout"                // La cuenta o la IP alcanzo el maximo de fallos y se bloqueo, una vez por bloqueo
+	LoginAuditAfterFailure LoginAuditReason = "success_after_failures" // Login correcto despues de varios fallos seguidos
+)
+
+// Registro de auditoria de un login sospechoso
+// El email se guarda aunque no exista la cuenta, UserId solo si existe
+type LoginAudit struct {
+	Id        string           `json:"id"`
+	Email     string           `json:"email"`
+	UserId    string           `json:"user_id,omitempty"`
+	IP        string           `json:"ip"`
+	Reason    LoginAuditReason `json:"reason"`
+	Failures  int              `json:"failures"`
+	CreatedAt time.Time        `json:"created_at"`
+}
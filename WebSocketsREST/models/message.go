@@ -1,6 +1,8 @@
 package models
 
+// Mensajes de control del protocolo de WebSockets, como las respuestas a los comandos de los clientes
+// Los cambios en los datos se envian como Event
 type WebSocketMessage struct {
-	Type    string      `json:"type"`    // Metodo HTTP de la peticion
-	Payload interface{} `json:"payload"` // Payload de la peticion este lleva el contenido del mensaje
+	Type    string      `json:"type"`    // Tipo del mensaje
+	Payload interface{} `json:"payload"` // Contenido del mensaje, depende del tipo
 }
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Evento pendiente de publicar, se guarda en la misma transaccion que el cambio que lo genera
+// El relay del outbox lo publica despues, asi un evento nunca se pierde ni se anuncia un cambio que no se guardo
+type OutboxMessage struct {
+	Id            string          `json:"id"`              // Id del evento
+	Topics        []string        `json:"topics"`          // Topicos del hub en los que se publica
+	Event         json.RawMessage `json:"event"`           // Evento serializado
+	CreatedAt     time.Time       `json:"created_at"`      // Momento en el que se guardo
+	Attempts      int             `json:"attempts"`        // Intentos de publicacion fallidos
+	LastError     string          `json:"last_error"`      // Error del ultimo intento fallido
+	NextAttemptAt time.Time       `json:"next_attempt_at"` // No se intenta publicar antes de este momento
+	PublishedAt   *time.Time      `json:"published_at"`    // Momento en el que se publico, nil si sigue pendiente
+}
+
+// Serializa el evento, si falla el cambio tampoco se guarda
+func NewOutboxMessage(event *Event, topics ...string) (*OutboxMessage, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxMessage{
+		Id:            event.Id,
+		Topics:        topics,
+		Event:         data,
+		CreatedAt:     event.Timestamp,
+		NextAttemptAt: event.Timestamp,
+	}, nil
+}
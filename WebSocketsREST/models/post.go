@@ -3,8 +3,49 @@ package models
 import "time"
 
 type Post struct {
-	Id        string    `json:"id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UserID    string    `json:"user_id"`
+	Id        string         `json:"id"`
+	Content   string         `json:"content"`
+	CreatedAt time.Time      `json:"created_at"`
+	UserID    string         `json:"user_id"`
+	Reactions ReactionCounts `json:"reactions,omitempty"` // Solo lectura, el repositorio la calcula al buscar posts
+}
+
+// Orden de los listados por fecha de creacion
+type SortOrder string
+
+const (
+	SortDesc SortOrder = "desc" // Del mas reciente al mas antiguo, el orden por defecto
+	SortAsc  SortOrder = "asc"  // Del mas antiguo al mas reciente
+)
+
+// Indica si el orden es uno de los ordenes conocidos
+func (o SortOrder) Valid() bool {
+	return o == SortDesc || o == SortAsc
+}
+
+// Filtros del listado de posts, los campos vacios no filtran
+type PostFilter struct {
+	UserId string     // Solo los posts de este autor
+	Since  *time.Time // Solo los posts creados en este momento o despues
+	Until  *time.Time // Solo los posts creados antes de este momento
+	Order  SortOrder  // SortDesc si esta vacio
+}
+
+// Identifica el orden y los filtros para ligar los cursores del listado a esta consulta
+func (f PostFilter) Key() string {
+	order := f.Order
+	if order == "" {
+		order = SortDesc
+	}
+
+	parts := []string{string(order), f.UserId}
+	for _, date := range []*time.Time{f.Since, f.Until} {
+		value := ""
+		if date != nil {
+			value = date.UTC().Format(time.RFC3339Nano)
+		}
+		parts = append(parts, value)
+	}
+
+	return CursorQuery(parts...)
 }
@@ -0,0 +1,29 @@
+package models
+
+// Reaccion de un usuario a un post, cada usuario puede tener una sola reaccion por post
+type ReactionKind string
+
+const (
+	ReactionLike  ReactionKind = "like"  // 👍
+	ReactionLove  ReactionKind = "love"  // ❤️
+	ReactionLaugh ReactionKind = "laugh" // 😂
+	ReactionWow   ReactionKind = "wow"   // 😮
+	ReactionSad   ReactionKind = "sad"   // 😢
+	ReactionAngry ReactionKind = "angry" // 😠
+)
+
+// Reacciones aceptadas, en el orden en el que se muestran
+var ReactionKinds = []ReactionKind{ReactionLike, ReactionLove, ReactionLaugh, ReactionWow, ReactionSad, ReactionAngry}
+
+// Indica si la reaccion es una de las reacciones conocidas
+func (r ReactionKind) Valid() bool {
+	for _, kind := range ReactionKinds {
+		if r == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Cantidad de reacciones de cada tipo de un post, los tipos sin reacciones no se incluyen
+type ReactionCounts map[ReactionKind]int
@@ -0,0 +1,19 @@
+package models
+
+// Rol de un usuario, define que puede hacer sobre los recursos de la API
+type Role string
+
+const (
+	RoleUser      Role = "user"      // Puede administrar sus propios posts
+	RoleModerator Role = "moderator" // Ademas puede eliminar cualquier post
+	RoleAdmin     Role = "admin"     // Ademas puede editar cualquier post y administrar usuarios
+)
+
+// Indica si el rol es uno de los roles conocidos
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
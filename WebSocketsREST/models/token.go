@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Token de refresco, solo se guarda el hash para que una filtracion de la base de datos no permita usarlos
+// Todos los tokens que se generan a partir del mismo login comparten el FamilyId
+type RefreshToken struct {
+	Id         string     `json:"id"`
+	UserId     string     `json:"user_id"`
+	FamilyId   string     `json:"family_id"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy string     `json:"replaced_by,omitempty"` // Id del token que lo reemplazo al rotarlo
+}
+
+// Token de un solo uso para restablecer la contraseña, igual que los de refresco solo se guarda el hash
+type PasswordResetToken struct {
+	Id        string     `json:"id"`
+	UserId    string     `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
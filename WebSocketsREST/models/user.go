@@ -1,7 +1,18 @@
 package models
 
+import "time"
+
 type User struct {
-	Id       string `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Id         string     `json:"id"`
+	Email      string     `json:"email"`
+	Password   string     `json:"password"`
+	Role       Role       `json:"role"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"` // Fecha en la que se verifico el email, nil si no esta verificado
+
+	// Fecha del ultimo cambio de contraseña, los access tokens emitidos antes ya no son validos
+	PasswordChangedAt *time.Time `json:"-"`
+}
+
+func (u *User) Verified() bool {
+	return u.VerifiedAt != nil
 }
@@ -0,0 +1,62 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"rest_ws/models"
+	"rest_ws/repository"
+	"rest_ws/websockets"
+)
+
+// Seguidores que se leen y se publican en cada pagina
+const defaultFeedPageSize = 500
+
+// Entrega los posts nuevos a los seguidores del autor
+// El evento feed.post se guarda en el outbox sin topicos, asi crear un post no depende de cuantos seguidores tiene el autor.
+// Aqui se recorren los seguidores por paginas de pageSize (500 si es 0) y cada pagina se publica en sus topicos privados
+//
+// Si una pagina falla el relay reintenta el evento completo y las paginas anteriores lo reciben otra vez,
+// los clientes lo descartan por su id. Quien empieza a seguir al autor durante el recorrido puede no recibirlo,
+// pero vera el post en /api/v1/feed
+func FeedSink(hub Publisher, pageSize int) Sink {
+	if pageSize <= 0 {
+		pageSize = defaultFeedPageSize
+	}
+
+	return SinkFunc(func(ctx context.Context, message *models.OutboxMessage) error {
+		var event struct {
+			Type    models.EventType `json:"type"`
+			Payload struct {
+				UserID string `json:"user_id"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal(message.Event, &event); err != nil {
+			return err
+		}
+		if event.Type != models.EventFeedPost {
+			return nil
+		}
+
+		var after *models.Cursor
+		for {
+			follows, err := repository.ListFollowers(ctx, event.Payload.UserID, after, pageSize)
+			if err != nil {
+				return err
+			}
+
+			if len(follows) > 0 {
+				topics := make([]string, 0, len(follows))
+				for _, follow := range follows {
+					topics = append(topics, websockets.UserTopic(follow.FollowerId))
+				}
+				hub.PublishAll(topics, json.RawMessage(message.Event))
+			}
+
+			if len(follows) < pageSize {
+				return nil
+			}
+			last := follows[len(follows)-1]
+			after = models.NewCursor(last.CreatedAt, last.FollowerId)
+		}
+	})
+}
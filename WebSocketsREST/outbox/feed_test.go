@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"rest_ws/database"
+	"rest_ws/models"
+	"rest_ws/repository"
+	"rest_ws/websockets"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Publisher de prueba que guarda los topicos de cada publicacion
+type testPublisher struct {
+	calls    [][]string
+	messages []interface{}
+}
+
+func (p *testPublisher) PublishAll(topics []string, message interface{}) {
+	p.calls = append(p.calls, append([]string(nil), topics...))
+	p.messages = append(p.messages, message)
+}
+
+func TestFeedSink(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	repository.SetRepository(repo)
+	ctx := context.Background()
+
+	var expected []string
+	for _, id := range []string{"author", "a", "b", "c", "d", "e", "stranger"} {
+		if err := repo.InsertUser(ctx, &models.User{Id: id, Email: id + "@example.com", Password: "hash"}); err != nil {
+			t.Fatal(err)
+		}
+		if id == "author" || id == "stranger" {
+			continue
+		}
+		if err := repo.Follow(ctx, &models.Follow{FollowerId: id, FolloweeId: "author", CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, websockets.UserTopic(id))
+	}
+
+	post := models.Post{Id: "post", Content: "feed", UserID: "author"}
+	feed, err := models.NewOutboxMessage(models.NewEvent("author", models.FeedPost(post)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := models.NewOutboxMessage(models.NewEvent("author", models.PostUpdated(post)), "posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		name     string
+		message  *models.OutboxMessage
+		pageSize int
+		pages    int
+	}{
+		{"feed post in pages", feed, 2, 3},
+		{"feed post in one page", feed, 0, 1},
+		{"other events", other, 2, 0},
+	}
+
+	for _, item := range tables {
+		publisher := &testPublisher{}
+		if err := FeedSink(publisher, item.pageSize).Publish(ctx, item.message); err != nil {
+			t.Fatal(err)
+		}
+		if len(publisher.calls) != item.pages {
+			t.Errorf("%s: pages were incorrect, got %d expected %d", item.name, len(publisher.calls), item.pages)
+		}
+		if item.pages == 0 {
+			continue
+		}
+
+		// Cada seguidor recibe el evento una sola vez y tal como se guardo
+		var got []string
+		for i, topics := range publisher.calls {
+			got = append(got, topics...)
+			if raw, ok := publisher.messages[i].(json.RawMessage); !ok || string(raw) != string(item.message.Event) {
+				t.Errorf("%s: message was incorrect, got %v", item.name, publisher.messages[i])
+			}
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Errorf("%s: topics were incorrect, got %v expected %v", item.name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package outbox
+
+/*
+	Relay del outbox de eventos
+	Los handlers guardan los eventos en el outbox en la misma transaccion que el cambio que los genera,
+	el relay los lee en segundo plano y los publica en los sinks configurados (el hub de websockets, colas externas, ...)
+
+	La entrega es al menos una vez: un evento se marca como publicado solo despues de que todos los sinks lo aceptan,
+	si alguno falla se reintenta con espera exponencial y si el relay se detiene a mitad se vuelve a publicar
+	al vencer su reserva. Los consumidores deben descartar duplicados con el id del evento
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"rest_ws/models"
+	"rest_ws/repository"
+	"time"
+)
+
+// Destino de los eventos del outbox
+type Sink interface {
+	Publish(ctx context.Context, message *models.OutboxMessage) error
+}
+
+// Permite usar una funcion como Sink
+type SinkFunc func(ctx context.Context, message *models.OutboxMessage) error
+
+func (f SinkFunc) Publish(ctx context.Context, message *models.OutboxMessage) error {
+	return f(ctx, message)
+}
+
+// Publisher es la parte del hub de websockets que usa el relay
+type Publisher interface {
+	PublishAll(topics []string, message interface{})
+}
+
+// Publica los eventos en los topicos del hub, el hub nunca falla al publicar
+// Los eventos sin topicos los entrega otro sink, como FeedSink
+func HubSink(hub Publisher) Sink {
+	return SinkFunc(func(ctx context.Context, message *models.OutboxMessage) error {
+		if len(message.Topics) == 0 {
+			return nil
+		}
+		hub.PublishAll(message.Topics, json.RawMessage(message.Event))
+		return nil
+	})
+}
+
+type Config struct {
+	PollInterval time.Duration // Cada cuanto se buscan eventos pendientes, 1 segundo por defecto
+	BatchSize    int           // Eventos que se reservan en cada busqueda, 100 por defecto
+	Lease        time.Duration // Tiempo que un evento queda reservado para esta instancia, 30 segundos por defecto
+	MaxBackoff   time.Duration // Espera maxima entre reintentos, 5 minutos por defecto
+	Retention    time.Duration // Tiempo que se guardan los eventos publicados, 24 horas por defecto
+}
+
+type Relay struct {
+	config  Config
+	sinks   []Sink
+	wake    chan struct{} // Avisos para buscar eventos sin esperar al intervalo
+	cleanAt time.Time     // Momento de la siguiente limpieza de eventos publicados
+}
+
+func NewRelay(config Config, sinks ...Sink) *Relay {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.Lease <= 0 {
+		config.Lease = 30 * time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Minute
+	}
+	if config.Retention <= 0 {
+		config.Retention = 24 * time.Hour
+	}
+
+	return &Relay{
+		config: config,
+		sinks:  sinks,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Pide al relay que busque eventos sin esperar al siguiente intervalo
+// Los handlers la llaman despues de guardar un cambio para que los eventos se publiquen de inmediato
+func (r *Relay) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Publica los eventos pendientes hasta que se cancela el contexto
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Se publican lotes mientras haya eventos pendientes
+		for {
+			published, err := r.RunOnce(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Println("Outbox relay error: ", err)
+			}
+			if err != nil || published < r.config.BatchSize {
+				break
+			}
+		}
+		r.clean(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// Reserva un lote de eventos pendientes y los publica, devuelve la cantidad de eventos reservados
+func (r *Relay) RunOnce(ctx context.Context) (int, error) {
+	messages, err := repository.ClaimOutboxMessages(ctx, r.config.BatchSize, r.config.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, message := range messages {
+		if err := r.publish(ctx, message); err != nil {
+			retryAt := time.Now().Add(r.backoff(message.Attempts + 1))
+			log.Printf("Outbox event %s failed (attempt %d), retrying at %s: %v", message.Id, message.Attempts+1, retryAt.Format(time.RFC3339), err)
+			if err := repository.MarkOutboxFailed(ctx, message.Id, err.Error(), retryAt); err != nil {
+				return len(messages), err
+			}
+			continue
+		}
+
+		if err := repository.MarkOutboxPublished(ctx, message.Id); err != nil {
+			// El evento se volvera a publicar al vencer la reserva
+			return len(messages), err
+		}
+	}
+
+	return len(messages), nil
+}
+
+// Publica el evento en todos los sinks, se detiene en el primero que falla
+func (r *Relay) publish(ctx context.Context, message *models.OutboxMessage) error {
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Espera exponencial: 1s, 2s, 4s, ... hasta MaxBackoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < r.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.config.MaxBackoff {
+		delay = r.config.MaxBackoff
+	}
+	return delay
+}
+
+// Borra los eventos publicados que superan la retencion, como mucho una vez por minuto
+func (r *Relay) clean(ctx context.Context) {
+	if time.Now().Before(r.cleanAt) {
+		return
+	}
+	r.cleanAt = time.Now().Add(time.Minute)
+
+	if err := repository.DeletePublishedOutboxMessages(ctx, time.Now().Add(-r.config.Retention)); err != nil && ctx.Err() == nil {
+		log.Println("Outbox cleanup error: ", err)
+	}
+}
@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"rest_ws/database"
+	"rest_ws/models"
+	"rest_ws/repository"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Sink de prueba que falla las primeras veces
+type testSink struct {
+	mutex     sync.Mutex
+	failures  int
+	published []string
+}
+
+func (s *testSink) Publish(ctx context.Context, message *models.OutboxMessage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink down")
+	}
+	s.published = append(s.published, message.Id)
+	return nil
+}
+
+func (s *testSink) ids() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.published...)
+}
+
+// Repositorio en memoria con el autor de los posts de prueba
+func setTestRepository(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	if err := repo.InsertUser(context.Background(), &models.User{Id: "alice", Email: "alice@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	repository.SetRepository(repo)
+}
+
+func insertPost(t *testing.T, id string) *models.OutboxMessage {
+	post := models.Post{Id: id, Content: "relay", UserID: "alice"}
+	message, err := models.NewOutboxMessage(models.NewEvent("alice", models.PostUpdated(post)), "posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.InsertPost(context.Background(), &post, message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestRelayPublishes(t *testing.T) {
+	setTestRepository(t)
+	ctx := context.Background()
+
+	sink := &testSink{}
+	relay := NewRelay(Config{}, sink)
+
+	first := insertPost(t, "first")
+	second := insertPost(t, "second")
+
+	if published, err := relay.RunOnce(ctx); err != nil || published != 2 {
+		t.Fatalf("RunOnce was incorrect, got %d %v", published, err)
+	}
+	if ids := sink.ids(); len(ids) != 2 || ids[0] != first.Id || ids[1] != second.Id {
+		t.Errorf("events were published out of order: %v", ids)
+	}
+
+	// Los eventos publicados no se vuelven a publicar
+	if published, err := relay.RunOnce(ctx); err != nil || published != 0 {
+		t.Errorf("published events were claimed again: %d %v", published, err)
+	}
+}
+
+func TestRelayRetries(t *testing.T) {
+	setTestRepository(t)
+	ctx := context.Background()
+
+	sink := &testSink{failures: 1}
+	relay := NewRelay(Config{MaxBackoff: time.Millisecond}, sink)
+
+	message := insertPost(t, "post")
+
+	if _, err := relay.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ids := sink.ids(); len(ids) != 0 {
+		t.Fatalf("failed event was published: %v", ids)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := relay.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ids := sink.ids(); len(ids) != 1 || ids[0] != message.Id {
+		t.Errorf("failed event was not retried: %v", ids)
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	setTestRepository(t)
+
+	published := make(chan string, 1)
+	relay := NewRelay(Config{PollInterval: time.Hour}, SinkFunc(func(ctx context.Context, message *models.OutboxMessage) error {
+		published <- message.Id
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	// Wake publica sin esperar al intervalo
+	message := insertPost(t, "post")
+	relay.Wake()
+
+	select {
+	case id := <-published:
+		if id != message.Id {
+			t.Errorf("Run published %s expected %s", id, message.Id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wake did not publish the event")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop after cancel")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	relay := NewRelay(Config{MaxBackoff: 10 * time.Second})
+
+	tables := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, item := range tables {
+		if got := relay.backoff(item.attempts); got != item.expected {
+			t.Errorf("backoff(%d) was incorrect, got %s expected %s", item.attempts, got, item.expected)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package policy
+
+/*
+	Capa de autorizacion, aqui se define quien puede realizar cada accion sobre cada recurso
+	Los handlers no deben comparar ids ni roles directamente, solo preguntar a esta capa
+
+	Posts:
+		read   -> cualquier usuario autenticado
+		update -> el autor o un admin
+		delete -> el autor, un moderador o un admin
+	Comentarios:
+		read   -> cualquier usuario autenticado
+		update -> el autor o un admin
+		delete -> el autor, el autor del post, un moderador o un admin
+	Usuarios:
+		read   -> el propio usuario o un admin
+		manage -> solo un admin (listar usuarios y cambiar roles)
+*/
+
+import "rest_ws/models"
+
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionManage Action = "manage"
+)
+
+// Indica si el usuario tiene alguno de los roles
+func HasRole(user *models.User, roles ...models.Role) bool {
+	if user == nil {
+		return false
+	}
+	for _, role := range roles {
+		if user.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Indica si el usuario puede realizar la accion sobre el post
+func CanPost(user *models.User, action Action, post *models.Post) bool {
+	if user == nil || post == nil {
+		return false
+	}
+
+	owner := post.UserID == user.Id
+
+	switch action {
+	case ActionRead:
+		return true
+	case ActionUpdate:
+		return owner || HasRole(user, models.RoleAdmin)
+	case ActionDelete:
+		return owner || HasRole(user, models.RoleModerator, models.RoleAdmin)
+	}
+	return false
+}
+
+// Indica si el usuario puede realizar la accion sobre un comentario del post
+// El autor del post puede borrar los comentarios de su post pero no editarlos
+func CanComment(user *models.User, action Action, post *models.Post, comment *models.Comment) bool {
+	if user == nil || post == nil || comment == nil {
+		return false
+	}
+
+	owner := comment.UserId == user.Id
+
+	switch action {
+	case ActionRead:
+		return true
+	case ActionUpdate:
+		return owner || HasRole(user, models.RoleAdmin)
+	case ActionDelete:
+		return owner || post.UserID == user.Id || HasRole(user, models.RoleModerator, models.RoleAdmin)
+	}
+	return false
+}
+
+// Indica si el usuario puede realizar la accion sobre otro usuario
+func CanUser(user *models.User, action Action, target *models.User) bool {
+	if user == nil {
+		return false
+	}
+
+	switch action {
+	case ActionRead:
+		return (target != nil && target.Id == user.Id) || HasRole(user, models.RoleAdmin)
+	case ActionManage:
+		return HasRole(user, models.RoleAdmin)
+	}
+	return false
+}
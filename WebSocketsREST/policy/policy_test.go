@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"rest_ws/models"
+	"testing"
+)
+
+func TestCanPost(t *testing.T) {
+	owner := &models.User{Id: "owner", Role: models.RoleUser}
+	other := &models.User{Id: "other", Role: models.RoleUser}
+	moderator := &models.User{Id: "moderator", Role: models.RoleModerator}
+	admin := &models.User{Id: "admin", Role: models.RoleAdmin}
+	post := &models.Post{Id: "post", UserID: owner.Id}
+
+	tables := []struct {
+		user   *models.User
+		action Action
+		n      bool
+	}{
+		{owner, ActionRead, true},
+		{owner, ActionUpdate, true},
+		{owner, ActionDelete, true},
+		{other, ActionRead, true},
+		{other, ActionUpdate, false},
+		{other, ActionDelete, false},
+		{moderator, ActionUpdate, false},
+		{moderator, ActionDelete, true},
+		{admin, ActionUpdate, true},
+		{admin, ActionDelete, true},
+		{nil, ActionRead, false},
+	}
+
+	for _, item := range tables {
+		if got := CanPost(item.user, item.action, post); got != item.n {
+			t.Errorf("CanPost(%+v, %s) was incorrect, got %t expected %t", item.user, item.action, got, item.n)
+		}
+	}
+}
+
+func TestCanComment(t *testing.T) {
+	author := &models.User{Id: "author", Role: models.RoleUser}
+	postOwner := &models.User{Id: "post-owner", Role: models.RoleUser}
+	other := &models.User{Id: "other", Role: models.RoleUser}
+	moderator := &models.User{Id: "moderator", Role: models.RoleModerator}
+	admin := &models.User{Id: "admin", Role: models.RoleAdmin}
+	post := &models.Post{Id: "post", UserID: postOwner.Id}
+	comment := &models.Comment{Id: "comment", PostId: post.Id, UserId: author.Id}
+
+	tables := []struct {
+		user   *models.User
+		action Action
+		n      bool
+	}{
+		{author, ActionRead, true},
+		{author, ActionUpdate, true},
+		{author, ActionDelete, true},
+		{postOwner, ActionUpdate, false},
+		{postOwner, ActionDelete, true},
+		{other, ActionRead, true},
+		{other, ActionUpdate, false},
+		{other, ActionDelete, false},
+		{moderator, ActionUpdate, false},
+		{moderator, ActionDelete, true},
+		{admin, ActionUpdate, true},
+		{admin, ActionDelete, true},
+		{nil, ActionRead, false},
+	}
+
+	for _, item := range tables {
+		if got := CanComment(item.user, item.action, post, comment); got != item.n {
+			t.Errorf("CanComment(%+v, %s) was incorrect, got %t expected %t", item.user, item.action, got, item.n)
+		}
+	}
+}
+
+func TestCanUser(t *testing.T) {
+	user := &models.User{Id: "user", Role: models.RoleUser}
+	moderator := &models.User{Id: "moderator", Role: models.RoleModerator}
+	admin := &models.User{Id: "admin", Role: models.RoleAdmin}
+
+	tables := []struct {
+		user   *models.User
+		action Action
+		target *models.User
+		n      bool
+	}{
+		{user, ActionRead, user, true},
+		{user, ActionRead, admin, false},
+		{user, ActionManage, user, false},
+		{moderator, ActionManage, user, false},
+		{admin, ActionRead, user, true},
+		{admin, ActionManage, user, true},
+	}
+
+	for _, item := range tables {
+		if got := CanUser(item.user, item.action, item.target); got != item.n {
+			t.Errorf("CanUser(%s, %s, %s) was incorrect, got %t expected %t", item.user.Id, item.action, item.target.Id, got, item.n)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package problem
+
+/*
+	Modelo unico de errores de la API, sigue el formato problem+json del RFC 7807
+
+		HTTP/1.1 404 Not Found
+		Content-Type: application/problem+json
+
+		{
+			"type": "about:blank",
+			"title": "Not Found",
+			"status": 404,
+			"detail": "Post not found",
+			"instance": "/api/v1/posts/123",
+			"code": "not_found",
+			"request_id": "..."
+		}
+
+	Los clientes deben usar code, que no cambia entre versiones, en lugar de detail o title
+	Los errores de validacion incluyen el detalle de cada campo en errors
+	Los errores internos se registran en el log con el request id y al cliente solo se le envia un mensaje generico
+*/
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Cabecera con el id de la peticion, la asigna el servidor a cada peticion
+const RequestIdHeader = "X-Request-Id"
+
+const ContentType = "application/problem+json"
+
+// Codigos estables de error
+const (
+	CodeInvalidRequest     = "invalid_request"     // La peticion no tiene el formato esperado
+	CodeInvalidJSON        = "invalid_json"        // El cuerpo no es un JSON valido
+	CodeValidationFailed   = "validation_failed"   // Uno o mas campos no son validos, ver errors
+	CodePayloadTooLarge    = "payload_too_large"   // El cuerpo de la peticion supera el tamaño maximo
+	CodeUnauthorized       = "unauthorized"        // Falta el token o no es valido
+	CodeInvalidCredentials = "invalid_credentials" // Email o contraseña incorrectos
+	CodeInvalidToken       = "invalid_token"       // El token de refresco, verificacion o restablecimiento no es valido, expiro o se reutilizo
+	CodeForbidden          = "forbidden"           // El usuario no tiene permiso
+	CodeEmailNotVerified   = "email_not_verified"  // La accion requiere que el usuario verifique su email
+	CodeNotFound           = "not_found"           // El recurso o la ruta no existen
+	CodeMethodNotAllowed   = "method_not_allowed"  // La ruta existe pero no acepta el metodo
+	CodeConflict           = "conflict"            // El recurso ya existe o cambio mientras tanto
+	CodeRateLimited        = "rate_limited"        // Demasiadas peticiones, reintentar despues de Retry-After
+	CodeLoginLocked        = "login_locked"        // Demasiados logins fallidos, reintentar despues de Retry-After
+	CodeUnavailable        = "unavailable"         // El servicio no puede atender la peticion en este momento
+	CodeInternal           = "internal_error"      // Error inesperado del servidor, el detalle solo esta en el log
+)
+
+// Error de un campo especifico de la peticion
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestId string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+func New(status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Escribe el problema como respuesta, completando la ruta y el request id de la peticion
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestId == "" {
+		p.RequestId = w.Header().Get(RequestIdHeader)
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Responde con un error sin detalle de campos
+func Error(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	Write(w, r, New(status, code, detail))
+}
+
+// Responde con los errores de validacion de cada campo
+func Validation(w http.ResponseWriter, r *http.Request, errors []FieldError) {
+	p := New(http.StatusUnprocessableEntity, CodeValidationFailed, "One or more fields are invalid")
+	p.Errors = errors
+	Write(w, r, p)
+}
+
+// Registra el error con el request id y responde con un mensaje generico
+// Los errores internos pueden tener detalles de la base de datos que no deben llegar al cliente
+func Internal(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Internal error [%s] %s %s: %v", w.Header().Get(RequestIdHeader), r.Method, r.URL.Path, err)
+	Error(w, r, http.StatusInternalServerError, CodeInternal, "An internal error occurred")
+}
@@ -0,0 +1,58 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/posts/123", nil)
+
+	tables := []struct {
+		name   string
+		write  func(w http.ResponseWriter)
+		status int
+		code   string
+		detail string
+	}{
+		{"Error", func(w http.ResponseWriter) { Error(w, r, http.StatusNotFound, CodeNotFound, "Post not found") }, 404, CodeNotFound, "Post not found"},
+		{"Validation", func(w http.ResponseWriter) {
+			Validation(w, r, []FieldError{{Field: "email", Code: "invalid", Message: "Email is not valid"}})
+		}, 422, CodeValidationFailed, "One or more fields are invalid"},
+		{"Internal", func(w http.ResponseWriter) { Internal(w, r, errors.New("pq: password authentication failed")) }, 500, CodeInternal, "An internal error occurred"},
+	}
+
+	for _, item := range tables {
+		w := httptest.NewRecorder()
+		w.Header().Set(RequestIdHeader, "request-1")
+		item.write(w)
+
+		if w.Code != item.status {
+			t.Errorf("%s status was incorrect, got %d expected %d", item.name, w.Code, item.status)
+		}
+		if got := w.Header().Get("Content-Type"); got != ContentType {
+			t.Errorf("%s content type was incorrect, got %s", item.name, got)
+		}
+		if strings.Contains(w.Body.String(), "pq:") {
+			t.Errorf("%s leaked the internal error: %s", item.name, w.Body.String())
+		}
+
+		var p Problem
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != item.status || p.Code != item.code || p.Detail != item.detail || p.Title != http.StatusText(item.status) {
+			t.Errorf("%s body was incorrect, got %+v", item.name, p)
+		}
+		if p.RequestId != "request-1" || p.Instance != "/api/v1/posts/123" || p.Type != "about:blank" {
+			t.Errorf("%s was missing request data, got %+v", item.name, p)
+		}
+		if item.code == CodeValidationFailed && (len(p.Errors) != 1 || p.Errors[0].Field != "email") {
+			t.Errorf("%s field errors were incorrect, got %+v", item.name, p.Errors)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package ratelimit
+
+/*
+	Limitador de peticiones con el algoritmo token bucket
+	Cada llave (una IP o un usuario) tiene una cubeta con Limit fichas que se rellena a razon de Limit por Window
+	Cada peticion consume una ficha, si la cubeta esta vacia la peticion se rechaza hasta que se rellene una ficha
+	Asi se permiten rafagas cortas de hasta Limit peticiones sin superar el promedio de Limit por Window
+
+	Las cubetas se guardan en memoria, cada instancia del servidor tiene sus propios limites
+*/
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Rule struct {
+	Limit  int           // Peticiones permitidas por ventana y tamaño de la rafaga, negativo desactiva el limite
+	Window time.Duration // Tiempo en el que se rellena la cubeta completa
+}
+
+// Regla que no limita ninguna peticion
+var Unlimited = Rule{Limit: -1}
+
+func (r Rule) Disabled() bool {
+	return r.Limit < 0
+}
+
+func (r Rule) String() string {
+	if r.Disabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", r.Limit, r.Window)
+}
+
+// Lee una regla con el formato "limite/ventana", por ejemplo "10/1m" o "300/1h"
+// "off" desactiva el limite y un texto vacio devuelve la regla cero para usar el valor por defecto
+func ParseRule(value string) (Rule, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Rule{}, nil
+	}
+	if value == "off" {
+		return Unlimited, nil
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return Rule{}, fmt.Errorf("invalid rate limit %q, expected limit/window", value)
+	}
+
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit <= 0 {
+		return Rule{}, fmt.Errorf("invalid rate limit %q, limit must be a positive integer", value)
+	}
+
+	window, err := time.ParseDuration(parts[1])
+	if err != nil || window <= 0 {
+		return Rule{}, fmt.Errorf("invalid rate limit %q, window must be a positive duration", value)
+	}
+
+	return Rule{Limit: limit, Window: window}, nil
+}
+
+// Resultado de una peticion, con los datos para las cabeceras RateLimit
+type Result struct {
+	Allowed    bool
+	Limit      int           // Tamaño de la cubeta
+	Remaining  int           // Fichas que quedan despues de esta peticion
+	Reset      time.Duration // Tiempo hasta que la cubeta este llena otra vez
+	RetryAfter time.Duration // Tiempo hasta la proxima ficha, solo si la peticion se rechazo
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type Limiter struct {
+	rule    Rule
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time // Reloj del limitador, se reemplaza en las pruebas
+	swept   time.Time        // Ultima vez que se eliminaron las cubetas llenas
+}
+
+func NewLimiter(rule Rule) *Limiter {
+	return &Limiter{
+		rule:    rule,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (l *Limiter) Rule() Rule {
+	return l.rule
+}
+
+// Consume una ficha de la cubeta de la llave
+func (l *Limiter) Allow(key string) Result {
+	if l.rule.Disabled() || l.rule.Limit == 0 {
+		return Result{Allowed: true}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	capacity := float64(l.rule.Limit)
+	rate := capacity / l.rule.Window.Seconds() // Fichas por segundo
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		l.buckets[key] = b
+	}
+
+	// Se rellenan las fichas del tiempo transcurrido desde la ultima peticion
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+	}
+	b.updated = now
+
+	result := Result{Limit: l.rule.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((capacity - b.tokens) / rate)
+	return result
+}
+
+// Elimina las cubetas que ya se rellenaron, son iguales a una cubeta nueva
+// Se ejecuta como maximo una vez por ventana para no recorrer el mapa en cada peticion
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.rule.Window {
+		return
+	}
+	l.swept = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.rule.Window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
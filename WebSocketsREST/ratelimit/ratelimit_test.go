@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tables := []struct {
+		value    string
+		expected Rule
+		valid    bool
+	}{
+		{"10/1m", Rule{Limit: 10, Window: time.Minute}, true},
+		{" 300/1h ", Rule{Limit: 300, Window: time.Hour}, true},
+		{"off", Unlimited, true},
+		{"", Rule{}, true},
+		{"10", Rule{}, false},
+		{"0/1m", Rule{}, false},
+		{"-1/1m", Rule{}, false},
+		{"10/minute", Rule{}, false},
+		{"10/0s", Rule{}, false},
+	}
+
+	for _, item := range tables {
+		rule, err := ParseRule(item.value)
+		if (err == nil) != item.valid {
+			t.Errorf("ParseRule(%q) error was incorrect, got %v", item.value, err)
+			continue
+		}
+		if rule != item.expected {
+			t.Errorf("ParseRule(%q) was incorrect, got %v expected %v", item.value, rule, item.expected)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewLimiter(Rule{Limit: 3, Window: 3 * time.Second})
+	limiter.now = func() time.Time { return now }
+
+	tables := []struct {
+		advance    time.Duration
+		key        string
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		// La rafaga completa se permite de inmediato
+		{0, "a", true, 2, 0},
+		{0, "a", true, 1, 0},
+		{0, "a", true, 0, 0},
+		{0, "a", false, 0, time.Second},
+		// Cada llave tiene su propia cubeta
+		{0, "b", true, 2, 0},
+		// Se rellena una ficha por segundo
+		{500 * time.Millisecond, "a", false, 0, 500 * time.Millisecond},
+		{500 * time.Millisecond, "a", true, 0, 0},
+		// La cubeta nunca supera el limite
+		{time.Hour, "a", true, 2, 0},
+	}
+
+	for i, item := range tables {
+		now = now.Add(item.advance)
+		result := limiter.Allow(item.key)
+		if result.Allowed != item.allowed || result.Remaining != item.remaining || result.RetryAfter != item.retryAfter {
+			t.Errorf("request %d was incorrect, got %+v expected allowed %v remaining %d retry after %v",
+				i, result, item.allowed, item.remaining, item.retryAfter)
+		}
+		if result.Limit != 3 {
+			t.Errorf("request %d limit was incorrect, got %d expected 3", i, result.Limit)
+		}
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewLimiter(Rule{Limit: 1, Window: time.Minute})
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow("a")
+	limiter.Allow("b")
+	now = now.Add(2 * time.Minute)
+	limiter.Allow("c")
+
+	if len(limiter.buckets) != 1 {
+		t.Errorf("buckets after sweep were incorrect, got %d expected 1", len(limiter.buckets))
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	limiter := NewLimiter(Unlimited)
+	for i := 0; i < 100; i++ {
+		if !limiter.Allow("a").Allowed {
+			t.Fatalf("disabled limiter rejected request %d", i)
+		}
+	}
+}
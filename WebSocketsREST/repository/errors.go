@@ -0,0 +1,16 @@
+package repository
+
+import "errors"
+
+/*
+	Errores que pueden devolver todas las implementaciones del repositorio
+	Las implementaciones especificas deben traducir sus propios errores a estos
+	para que los handlers no dependan de la base de datos que se utilice
+*/
+
+var (
+	// No existe ningun registro que cumpla con la busqueda
+	ErrNotFound = errors.New("resource not found")
+	// El registro viola una restriccion de unicidad, por ejemplo un email repetido
+	ErrConflict = errors.New("resource already exists")
+)
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"rest_ws/models"
+	"time"
 )
 
 /*  Esta es la implementacion general de la capa de base de datos , se utiliza el patron de diseño Repository
@@ -11,17 +12,64 @@ y no la logica de estos.
 
 Para definir la logica se debe crear una implementacion especifica, la cual tiene que implementar
 todos los metodos de esta interfaz Repository
+
+Cuando no existe el registro buscado se devuelve ErrNotFound y cuando se viola una restriccion
+de unicidad o un CHECK se devuelve ErrConflict, nunca un valor vacio
+
+Los metodos que modifican datos reciben los eventos del outbox que genera el cambio,
+el cambio y sus eventos se guardan en la misma transaccion o no se guarda ninguno
 */
 
+// Genera el evento de un cambio de reaccion con los totales que quedan despues del cambio
+// Se llama dentro de la misma transaccion, solo si la reaccion del usuario cambio
+type ReactionEvent func(counts models.ReactionCounts) (*models.OutboxMessage, error)
+
+// Decide si un intento de login puede continuar con los fallos actuales de cada llave, en el mismo orden
+// Una llave sin fallos dentro de la ventana llega como nil. Si devuelve un error el intento no se cuenta
+type LoginAttemptCheck func(attempts []*models.LoginAttempt) error
+
 type Repository interface {
-	InsertUser(ctx context.Context, user *models.User) error
+	InsertUser(ctx context.Context, user *models.User, events ...*models.OutboxMessage) error
 	FindUserById(ctx context.Context, id string) (*models.User, error)
 	FindUserByEmail(ctx context.Context, email string) (*models.User, error)
-	InsertPost(ctx context.Context, post *models.Post) error
+	UpdateUserRole(ctx context.Context, id string, role models.Role) error
+	VerifyUserEmail(ctx context.Context, id string, at time.Time) error
+	ListUsers(ctx context.Context, page uint64) ([]*models.User, error)
+	InsertPost(ctx context.Context, post *models.Post, events ...*models.OutboxMessage) error
 	GetPostById(ctx context.Context, id string) (*models.Post, error)
-	UpdatePost(ctx context.Context, post *models.Post) error
-	DeletePost(ctx context.Context, id string) error
-	ListPosts(ctx context.Context, page uint64) ([]*models.Post, error)
+	UpdatePost(ctx context.Context, post *models.Post, events ...*models.OutboxMessage) error
+	DeletePost(ctx context.Context, id string, events ...*models.OutboxMessage) error
+	ListPosts(ctx context.Context, filter models.PostFilter, after *models.Cursor, limit int) ([]*models.Post, error)
+	SetReaction(ctx context.Context, postId string, userId string, reaction models.ReactionKind, event ReactionEvent) (models.ReactionCounts, error)
+	DeleteReaction(ctx context.Context, postId string, userId string, event ReactionEvent) (models.ReactionCounts, error)
+	Follow(ctx context.Context, follow *models.Follow) error
+	Unfollow(ctx context.Context, followerId string, followeeId string) error
+	ListFollowers(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error)
+	ListFollowing(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error)
+	ListFeed(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Post, error)
+	InsertComment(ctx context.Context, comment *models.Comment, events ...*models.OutboxMessage) error
+	GetCommentById(ctx context.Context, id string) (*models.Comment, error)
+	UpdateComment(ctx context.Context, comment *models.Comment, events ...*models.OutboxMessage) error
+	DeleteComment(ctx context.Context, id string, events ...*models.OutboxMessage) error
+	ListComments(ctx context.Context, postId string, after *models.Cursor, limit int) ([]*models.Comment, error)
+	InsertRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyId string) error
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	InsertPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error
+	ResetPassword(ctx context.Context, tokenHash string, password string, at time.Time) (string, error)
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxMessage, error)
+	MarkOutboxPublished(ctx context.Context, id string) error
+	MarkOutboxFailed(ctx context.Context, id string, reason string, retryAt time.Time) error
+	DeletePublishedOutboxMessages(ctx context.Context, before time.Time) error
+	GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error)
+	ReserveLoginAttempt(ctx context.Context, keys []string, at time.Time, since time.Time, check LoginAttemptCheck) ([]*models.LoginAttempt, error)
+	ReleaseLoginAttempt(ctx context.Context, key string) error
+	ResetLoginAttempt(ctx context.Context, key string) error
+	InsertLoginAudit(ctx context.Context, audit *models.LoginAudit) error
+	ListLoginAudit(ctx context.Context, page uint64) ([]*models.LoginAudit, error)
 	Close() error
 }
 
@@ -31,8 +79,8 @@ func SetRepository(repo Repository) {
 	implementation = repo
 }
 
-func InsertUser(ctx context.Context, user *models.User) error {
-	return implementation.InsertUser(ctx, user)
+func InsertUser(ctx context.Context, user *models.User, events ...*models.OutboxMessage) error {
+	return implementation.InsertUser(ctx, user, events...)
 }
 
 func FindUserById(ctx context.Context, id string) (*models.User, error) {
@@ -43,22 +91,186 @@ func FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	return implementation.FindUserByEmail(ctx, email)
 }
 
-func InsertPost(ctx context.Context, post *models.Post) error {
-	return implementation.InsertPost(ctx, post)
+func UpdateUserRole(ctx context.Context, id string, role models.Role) error {
+	return implementation.UpdateUserRole(ctx, id, role)
+}
+
+// Marca el email del usuario como verificado, si ya estaba verificado se mantiene la fecha original
+func VerifyUserEmail(ctx context.Context, id string, at time.Time) error {
+	return implementation.VerifyUserEmail(ctx, id, at)
+}
+
+func ListUsers(ctx context.Context, page uint64) ([]*models.User, error) {
+	return implementation.ListUsers(ctx, page)
+}
+
+func InsertPost(ctx context.Context, post *models.Post, events ...*models.OutboxMessage) error {
+	return implementation.InsertPost(ctx, post, events...)
 }
 
 func GetPostById(ctx context.Context, id string) (*models.Post, error) {
 	return implementation.GetPostById(ctx, id)
 }
 
-func UpdatePost(ctx context.Context, post *models.Post) error {
-	return implementation.UpdatePost(ctx, post)
+func UpdatePost(ctx context.Context, post *models.Post, events ...*models.OutboxMessage) error {
+	return implementation.UpdatePost(ctx, post, events...)
+}
+
+func DeletePost(ctx context.Context, id string, events ...*models.OutboxMessage) error {
+	return implementation.DeletePost(ctx, id, events...)
+}
+
+// Lista los posts que cumplen el filtro ordenados por (created_at, id) en el orden del filtro
+// after es la posicion del ultimo post de la pagina anterior, nil para la primera pagina
+func ListPosts(ctx context.Context, filter models.PostFilter, after *models.Cursor, limit int) ([]*models.Post, error) {
+	return implementation.ListPosts(ctx, filter, after, limit)
+}
+
+// Guarda o reemplaza la reaccion del usuario al post y devuelve los totales del post
+// Los cambios de reaccion de un mismo post se aplican de uno en uno, asi los totales siempre coinciden con las reacciones
+// Si el post no existe devuelve ErrNotFound
+func SetReaction(ctx context.Context, postId string, userId string, reaction models.ReactionKind, event ReactionEvent) (models.ReactionCounts, error) {
+	return implementation.SetReaction(ctx, postId, userId, reaction, event)
+}
+
+// Quita la reaccion del usuario al post, si no tenia reaccion solo devuelve los totales
+func DeleteReaction(ctx context.Context, postId string, userId string, event ReactionEvent) (models.ReactionCounts, error) {
+	return implementation.DeleteReaction(ctx, postId, userId, event)
+}
+
+// Seguir a un usuario que ya se sigue no hace nada, si el usuario seguido no existe devuelve ErrNotFound
+// y seguirse a si mismo devuelve ErrConflict
+func Follow(ctx context.Context, follow *models.Follow) error {
+	return implementation.Follow(ctx, follow)
+}
+
+// Dejar de seguir a un usuario que no se sigue no hace nada
+func Unfollow(ctx context.Context, followerId string, followeeId string) error {
+	return implementation.Unfollow(ctx, followerId, followeeId)
+}
+
+// Los listados con cursor van del mas reciente al mas antiguo, after es la posicion del ultimo elemento de la pagina anterior
+// En los listados de seguidores el id del cursor es el del otro usuario
+func ListFollowers(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error) {
+	return implementation.ListFollowers(ctx, userId, after, limit)
+}
+
+func ListFollowing(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Follow, error) {
+	return implementation.ListFollowing(ctx, userId, after, limit)
+}
+
+// Posts de los usuarios que sigue userId, del mas reciente al mas antiguo
+func ListFeed(ctx context.Context, userId string, after *models.Cursor, limit int) ([]*models.Post, error) {
+	return implementation.ListFeed(ctx, userId, after, limit)
+}
+
+// Si el post no existe devuelve ErrNotFound
+func InsertComment(ctx context.Context, comment *models.Comment, events ...*models.OutboxMessage) error {
+	return implementation.InsertComment(ctx, comment, events...)
+}
+
+func GetCommentById(ctx context.Context, id string) (*models.Comment, error) {
+	return implementation.GetCommentById(ctx, id)
+}
+
+// Solo se actualizan el contenido y la fecha de edicion
+func UpdateComment(ctx context.Context, comment *models.Comment, events ...*models.OutboxMessage) error {
+	return implementation.UpdateComment(ctx, comment, events...)
+}
+
+func DeleteComment(ctx context.Context, id string, events ...*models.OutboxMessage) error {
+	return implementation.DeleteComment(ctx, id, events...)
+}
+
+// Lista los comentarios de un post del mas antiguo al mas reciente, despues del cursor si no es nil
+func ListComments(ctx context.Context, postId string, after *models.Cursor, limit int) ([]*models.Comment, error) {
+	return implementation.ListComments(ctx, postId, after, limit)
+}
+
+func InsertRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	return implementation.InsertRefreshToken(ctx, token)
+}
+
+func GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	return implementation.GetRefreshTokenByHash(ctx, hash)
+}
+
+// Revoca el token anterior y guarda el siguiente de forma atomica
+// Si el token anterior ya estaba revocado devuelve ErrConflict, lo que indica que fue reutilizado
+func RotateRefreshToken(ctx context.Context, oldId string, next *models.RefreshToken) error {
+	return implementation.RotateRefreshToken(ctx, oldId, next)
+}
+
+func RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	return implementation.RevokeRefreshTokenFamily(ctx, familyId)
+}
+
+// Agrega el jti de un access token a la lista de tokens revocados hasta que expire
+func RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return implementation.RevokeToken(ctx, jti, expiresAt)
+}
+
+func IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return implementation.IsTokenRevoked(ctx, jti)
+}
+
+// Reserva hasta limit eventos pendientes del outbox en orden de creacion
+// Los eventos reservados no se vuelven a entregar hasta que pase lease, asi varias instancias no publican el mismo
+// Si el relay se detiene antes de marcarlos, se vuelven a publicar al vencer la reserva
+func ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxMessage, error) {
+	return implementation.ClaimOutboxMessages(ctx, limit, lease)
+}
+
+func MarkOutboxPublished(ctx context.Context, id string) error {
+	return implementation.MarkOutboxPublished(ctx, id)
+}
+
+// Registra un intento fallido, el evento se vuelve a intentar a partir de retryAt
+func MarkOutboxFailed(ctx context.Context, id string, reason string, retryAt time.Time) error {
+	return implementation.MarkOutboxFailed(ctx, id, reason, retryAt)
+}
+
+// Borra los eventos publicados antes de before
+func DeletePublishedOutboxMessages(ctx context.Context, before time.Time) error {
+	return implementation.DeletePublishedOutboxMessages(ctx, before)
+}
+
+func InsertPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	return implementation.InsertPasswordResetToken(ctx, token)
+}
+
+// Usa el token de restablecimiento y cambia la contraseña en una sola transaccion, devuelve el id del usuario
+// Tambien invalida los demas tokens de restablecimiento del usuario y revoca todos sus tokens de refresco
+// Si el token no existe, ya se uso o expiro devuelve ErrNotFound
+func ResetPassword(ctx context.Context, tokenHash string, password string, at time.Time) (string, error) {
+	return implementation.ResetPassword(ctx, tokenHash, password, at)
+}
+
+func GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	return implementation.GetLoginAttempt(ctx, key)
+}
+
+// Reserva un intento de login antes de verificar la contraseña. Con las llaves bloqueadas llama a check y,
+// si lo acepta, cuenta el intento como un fallo en cada llave y devuelve los contadores resultantes
+// Asi dos intentos al mismo tiempo nunca ven los mismos fallos. Si el ultimo fallo de una llave
+// es anterior a since su contador vuelve a empezar. Las llaves se bloquean en el orden recibido
+func ReserveLoginAttempt(ctx context.Context, keys []string, at time.Time, since time.Time, check LoginAttemptCheck) ([]*models.LoginAttempt, error) {
+	return implementation.ReserveLoginAttempt(ctx, keys, at, since, check)
+}
+
+// Descuenta un intento reservado que termino bien, el contador nunca baja de cero
+func ReleaseLoginAttempt(ctx context.Context, key string) error {
+	return implementation.ReleaseLoginAttempt(ctx, key)
+}
+
+func ResetLoginAttempt(ctx context.Context, key string) error {
+	return implementation.ResetLoginAttempt(ctx, key)
 }
 
-func DeletePost(ctx context.Context, id string) error {
-	return implementation.DeletePost(ctx, id)
+func InsertLoginAudit(ctx context.Context, audit *models.LoginAudit) error {
+	return implementation.InsertLoginAudit(ctx, audit)
 }
 
-func ListPosts(ctx context.Context, page uint64) ([]*models.Post, error) {
-	return implementation.ListPosts(ctx, page)
+func ListLoginAudit(ctx context.Context, page uint64) ([]*models.LoginAudit, error) {
+	return implementation.ListLoginAudit(ctx, page)
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rest_ws/database"
+	"rest_ws/models"
+	"rest_ws/validation"
+)
+
+// Ejecuta el subcomando role, asigna un rol al usuario con el email indicado
+//
+//	role <email> <user|moderator|admin>
+func runRole(databaseUrl string, args []string) error {
+	if databaseUrl == "" {
+		return errors.New("database url is required")
+	}
+	if len(args) != 2 {
+		return errors.New("usage: role <email> user|moderator|admin")
+	}
+
+	role := models.Role(args[1])
+	if !role.Valid() {
+		return fmt.Errorf("unknown role %q", args[1])
+	}
+
+	repo, err := database.NewPostgresRepository(databaseUrl)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+
+	user, err := repo.FindUserByEmail(ctx, validation.NormalizeEmail(args[0]))
+	if err != nil {
+		return fmt.Errorf("user %s: %w", args[0], err)
+	}
+
+	return repo.UpdateUserRole(ctx, user.Id, role)
+}
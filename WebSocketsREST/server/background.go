@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Tiempo maximo de una tarea en segundo plano, ya no depende de la peticion que la inicio
+const backgroundTimeout = time.Minute
+
+// Tareas que siguen despues de responder una peticion, como enviar un email
+// Pertenecen al servidor y no a la peticion: el apagado las espera antes de cerrar el repositorio
+type Background struct {
+	wait    sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+}
+
+func NewBackground(timeout time.Duration) *Background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Background{ctx: ctx, cancel: cancel, timeout: timeout}
+}
+
+// Ejecuta la tarea en una goroutine, su contexto vence despues de timeout o cuando Wait se rinde
+func (b *Background) Go(fn func(ctx context.Context)) {
+	b.wait.Add(1)
+	go func() {
+		defer b.wait.Done()
+		ctx, cancel := context.WithTimeout(b.ctx, b.timeout)
+		defer cancel()
+		fn(ctx)
+	}()
+}
+
+// Espera a que terminen las tareas en curso
+// Si el contexto se cancela antes, cancela el contexto de las tareas y devuelve el error del contexto
+func (b *Background) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.wait.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
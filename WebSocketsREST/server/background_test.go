@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackgroundWait(t *testing.T) {
+	background := NewBackground(time.Minute)
+
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	background.Go(func(ctx context.Context) {
+		<-release
+		close(finished)
+	})
+
+	// Wait no regresa mientras la tarea sigue en curso
+	waited := make(chan error, 1)
+	go func() {
+		waited <- background.Wait(context.Background())
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned before the task finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Wait was incorrect, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return after the task finished")
+	}
+	<-finished
+}
+
+func TestBackgroundWaitCanceled(t *testing.T) {
+	background := NewBackground(time.Minute)
+
+	canceled := make(chan struct{})
+	background.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(canceled)
+	})
+
+	// Si el apagado se rinde, las tareas que quedan ven su contexto cancelado
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := background.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait was incorrect, got %v expected %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("task context was not canceled")
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Error que devuelve la lectura del cuerpo de una peticion que supera Config.MaxBodyBytes
+// Los handlers lo detectan con errors.Is para responder 413
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// Limita el tamaño del cuerpo de todas las peticiones
+// A diferencia de http.MaxBytesReader el error es un valor conocido que se puede comparar
+func BodyLimitMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &limitedBody{body: r.Body, remaining: limit}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64 // Bytes que aun se pueden leer, negativo si el cuerpo supero el limite
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	// Se lee un byte mas del limite para distinguir un cuerpo del tamaño exacto de uno mas grande
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.body.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), ErrBodyTooLarge
+	}
+	return n, err
+}
+
+func (l *limitedBody) Close() error {
+	return l.body.Close()
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	tables := []struct {
+		body     string
+		tooLarge bool
+	}{
+		{"", false},
+		{"12345", false},
+		{"1234567890", false},
+		{"12345678901", true},
+		{strings.Repeat("x", 10000), true},
+	}
+
+	for _, item := range tables {
+		var data []byte
+		var err error
+		handler := BodyLimitMiddleware(10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, err = io.ReadAll(r.Body)
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(item.body)))
+
+		if got := errors.Is(err, ErrBodyTooLarge); got != item.tooLarge {
+			t.Errorf("body of %d bytes was incorrect, got too large %v expected %v", len(item.body), got, item.tooLarge)
+		}
+		if len(data) > 10 {
+			t.Errorf("body of %d bytes was incorrect, read %d bytes past the limit", len(item.body), len(data))
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"rest_ws/problem"
+
+	"github.com/segmentio/ksuid"
+)
+
+// Asigna un id a cada peticion y lo devuelve en la cabecera X-Request-Id
+// Si el cliente o un proxy ya envian uno valido se reutiliza, asi se puede seguir una peticion entre servicios
+// Los errores incluyen este id para poder buscarlos en el log
+func RequestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(problem.RequestIdHeader)
+		if !validRequestId(id) {
+			id = ksuid.New().String()
+		}
+
+		w.Header().Set(problem.RequestIdHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Solo se aceptan ids cortos con letras, numeros, guiones y puntos para que no se puedan inyectar datos en el log
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rest_ws/problem"
+	"testing"
+)
+
+func TestRequestIdMiddleware(t *testing.T) {
+	handler := RequestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tables := []struct {
+		incoming string
+		reused   bool
+	}{
+		{"", false},
+		{"abc-123_DEF.4", true},
+		{"bad id\nwith newline", false},
+		{string(make([]byte, 65)), false},
+	}
+
+	for _, item := range tables {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if item.incoming != "" {
+			r.Header.Set(problem.RequestIdHeader, item.incoming)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		got := w.Header().Get(problem.RequestIdHeader)
+		if got == "" {
+			t.Errorf("request id was not set for %q", item.incoming)
+		}
+		if (got == item.incoming) != item.reused {
+			t.Errorf("request id for %q was incorrect, got %q", item.incoming, got)
+		}
+	}
+}
@@ -3,11 +3,23 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"rest_ws/auth"
 	"rest_ws/database"
+	"rest_ws/lockout"
+	"rest_ws/mailer"
+	"rest_ws/outbox"
+	"rest_ws/problem"
+	"rest_ws/ratelimit"
 	"rest_ws/repository"
+	"rest_ws/validation"
 	"rest_ws/websockets"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -15,20 +27,112 @@ import (
 
 type Config struct {
 	Port        string // Puerto en el que se va a ejecutar el servidor
-	JWTSecret   string // Clave secreta para la generación de tokens
+	JWTSecret   string // Clave secreta HMAC para la generación de tokens, opcional si se usa JWTKeysDir
 	DatabaseUrl string // Url de la base de datos
+	Driver      string // Implementacion del repositorio: "postgres" (por defecto) o "memory"
+	AutoMigrate bool   // Aplica las migraciones pendientes al iniciar el servidor
+
+	JWTKeysDir     string // Directorio con las llaves PEM (RSA o Ed25519) para firmar y verificar tokens
+	JWTActiveKeyId string // kid de la llave con la que se firman los tokens, por defecto la de mayor kid
+
+	AllowedOrigins []string // Origenes que pueden abrir conexiones de WebSockets, "*" permite cualquiera
+
+	WebSocketSendBuffer   int    // Tamaño de la cola de mensajes de cada conexion de WebSockets
+	WebSocketSlowConsumer string // Politica para las conexiones lentas: "drop_client" (por defecto) o "drop_oldest"
+	WebSocketEventLog     int    // Cantidad de eventos que se guardan para reenviar a los clientes que se reconectan
+	WebSocketBackplane    string // Reparto de mensajes entre instancias: "memory" (por defecto, una sola instancia) o "postgres"
+
+	WebSocketMaxConnections        int // Conexiones de WebSockets abiertas en esta instancia, 10000 por defecto
+	WebSocketMaxConnectionsPerUser int // Conexiones de WebSockets abiertas por usuario, 10 por defecto
+
+	ReadTimeout     time.Duration // Tiempo maximo para leer una peticion, 15 segundos por defecto
+	WriteTimeout    time.Duration // Tiempo maximo para escribir una respuesta, 15 segundos por defecto
+	IdleTimeout     time.Duration // Tiempo que se mantiene abierta una conexion sin peticiones, 60 segundos por defecto
+	ShutdownTimeout time.Duration // Tiempo maximo para apagar el servidor, 30 segundos por defecto
+
+	OutboxPollInterval time.Duration // Cada cuanto se buscan eventos pendientes en el outbox, 1 segundo por defecto
+
+	AccessTokenTTL  time.Duration // Duracion de los access tokens, 15 minutos por defecto
+	RefreshTokenTTL time.Duration // Duracion de los tokens de refresco, 30 dias por defecto
+
+	MaxBodyBytes int64            // Tamaño maximo del cuerpo de una peticion, 1 MiB por defecto
+	Validation   validation.Rules // Reglas de validacion de los datos de las peticiones
+
+	RateLimits map[string]ratelimit.Rule // Limite de peticiones de cada grupo de rutas, los que faltan usan DefaultRateLimits
+	TrustProxy bool                      // Toma la IP del cliente de X-Forwarded-For, solo si el servidor esta detras de un proxy
+
+	LoginLockout lockout.Policy // Esperas y bloqueos despues de varios logins fallidos
+
+	PublicURL            string        // Url publica del servidor para los enlaces de los emails, por defecto http://localhost:<puerto>
+	MailDriver           string        // Envio de emails: "file" (por defecto), "smtp" o "memory"
+	MailDir              string        // Directorio de los emails con el driver "file", "mail" por defecto
+	MailFrom             string        // Remitente de los emails, no-reply@localhost por defecto
+	SMTPAddr             string        // host:puerto del servidor SMTP
+	SMTPUsername         string        // Usuario del servidor SMTP, opcional
+	SMTPPassword         string        // Contraseña del servidor SMTP
+	RequireVerifiedEmail bool          // Solo los usuarios con el email verificado pueden publicar
+	VerificationTokenTTL time.Duration // Duracion de los enlaces de verificacion, 24 horas por defecto
+
+	PasswordResetTokenTTL time.Duration // Duracion de los enlaces para restablecer la contraseña, 1 hora por defecto
+	PasswordResetURL      string        // Pagina del enlace para restablecer la contraseña, recibe ?token=. Por defecto el formulario del servidor en <PublicURL>/password/reset
+
+	PageSize    int // Elementos por pagina de los listados con cursor si el cliente no indica limit, 20 por defecto
+	MaxPageSize int // Maximo de elementos por pagina que puede pedir un cliente, 100 por defecto
 }
 
+const (
+	MailDriverFile   = "file"
+	MailDriverSMTP   = "smtp"
+	MailDriverMemory = "memory"
+)
+
+// Grupos de rutas con su propio limite de peticiones
+const (
+	RateLimitAuth      = "auth"      // signup, login y refresh por IP, protege contra ataques de fuerza bruta
+	RateLimitRead      = "read"      // Lecturas de la API por usuario
+	RateLimitWrite     = "write"     // Escrituras de la API por usuario
+	RateLimitWebSocket = "websocket" // Nuevas conexiones de WebSockets por IP
+)
+
+var DefaultRateLimits = map[string]ratelimit.Rule{
+	RateLimitAuth:      {Limit: 10, Window: time.Minute},
+	RateLimitRead:      {Limit: 300, Window: time.Minute},
+	RateLimitWrite:     {Limit: 60, Window: time.Minute},
+	RateLimitWebSocket: {Limit: 30, Window: time.Minute},
+}
+
+const (
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+const (
+	BackplaneMemory   = "memory"
+	BackplanePostgres = "postgres"
+)
+
 type Server interface {
-	Config() *Config      // Devuelve la configuración del servidor
-	Hub() *websockets.Hub // Devuelve el hub de websockets
+	Config() *Config         // Devuelve la configuración del servidor
+	Hub() *websockets.Hub    // Devuelve el hub de websockets
+	Keys() *auth.KeyManager  // Devuelve las llaves para firmar y verificar tokens
+	Outbox() *outbox.Relay   // Devuelve el relay que publica los eventos del outbox
+	Mailer() mailer.Mailer   // Devuelve el servicio con el que se envian los emails
+	Background() *Background // Devuelve las tareas que siguen despues de responder
 }
 
 // EL broker es la implementación del servidor
 type Broker struct {
-	config *Config
-	router *mux.Router
-	hub    *websockets.Hub
+	config     *Config
+	router     *mux.Router
+	hub        *websockets.Hub
+	keys       *auth.KeyManager
+	outbox     *outbox.Relay
+	mailer     mailer.Mailer
+	background *Background
+
+	mutex  sync.Mutex         // Protege cancel y done
+	cancel context.CancelFunc // Detiene el servidor iniciado con Start
+	done   chan struct{}      // Se cierra cuando Start termina de apagar el servidor
 }
 
 func (b *Broker) Config() *Config {
@@ -39,56 +143,412 @@ func (b *Broker) Hub() *websockets.Hub {
 	return b.hub
 }
 
+func (b *Broker) Keys() *auth.KeyManager {
+	return b.keys
+}
+
+func (b *Broker) Outbox() *outbox.Relay {
+	return b.outbox
+}
+
+func (b *Broker) Mailer() mailer.Mailer {
+	return b.mailer
+}
+
+func (b *Broker) Background() *Background {
+	return b.background
+}
+
 // Crea un nuevo servidor y valida la configuración
 func NewServer(ctx context.Context, config *Config) (*Broker, error) {
 	if config.Port == "" {
 		return nil, errors.New("port is required")
 	}
 
-	if config.JWTSecret == "" {
-		return nil, errors.New("JWT secret is required")
+	if config.JWTSecret == "" && config.JWTKeysDir == "" {
+		return nil, errors.New("JWT secret or keys directory is required")
+	}
+
+	keys, err := newKeyManager(config)
+	if err != nil {
+		return nil, err
 	}
 
-	if config.DatabaseUrl == "" {
-		return nil, errors.New("database url is required")
+	if config.AccessTokenTTL == 0 {
+		config.AccessTokenTTL = 15 * time.Minute
+	}
+
+	if config.RefreshTokenTTL == 0 {
+		config.RefreshTokenTTL = 30 * 24 * time.Hour
+	}
+
+	if config.Driver == "" {
+		config.Driver = DriverPostgres
+	}
+
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = 15 * time.Second
+	}
+
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = 15 * time.Second
+	}
+
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = 60 * time.Second
+	}
+
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 30 * time.Second
+	}
+
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = 1 << 20
+	}
+
+	config.Validation = config.Validation.WithDefaults()
+	config.LoginLockout = config.LoginLockout.WithDefaults()
+
+	// Se copia el mapa para no modificar el del llamador
+	rateLimits := map[string]ratelimit.Rule{}
+	for group, rule := range DefaultRateLimits {
+		rateLimits[group] = rule
+	}
+	for group, rule := range config.RateLimits {
+		if rule != (ratelimit.Rule{}) {
+			rateLimits[group] = rule
+		}
+	}
+	config.RateLimits = rateLimits
+
+	if config.PublicURL == "" {
+		config.PublicURL = "http://localhost:" + config.Port
+	}
+	config.PublicURL = strings.TrimSuffix(config.PublicURL, "/")
+
+	if config.VerificationTokenTTL == 0 {
+		config.VerificationTokenTTL = 24 * time.Hour
+	}
+
+	if config.PasswordResetTokenTTL == 0 {
+		config.PasswordResetTokenTTL = time.Hour
+	}
+
+	if config.PasswordResetURL == "" {
+		config.PasswordResetURL = config.PublicURL + "/password/reset"
+	}
+	// El enlace se envia por email, tiene que ser una url completa
+	if parsed, err := url.Parse(config.PasswordResetURL); err != nil || !parsed.IsAbs() {
+		return nil, fmt.Errorf("invalid password reset url %q", config.PasswordResetURL)
+	}
+
+	if config.MaxPageSize <= 0 {
+		config.MaxPageSize = 100
+	}
+	if config.PageSize <= 0 {
+		config.PageSize = 20
+	}
+	if config.PageSize > config.MaxPageSize {
+		config.PageSize = config.MaxPageSize
 	}
 
+	mail, err := newMailer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.WebSocketMaxConnections == 0 {
+		config.WebSocketMaxConnections = 10000
+	}
+
+	if config.WebSocketMaxConnectionsPerUser == 0 {
+		config.WebSocketMaxConnectionsPerUser = 10
+	}
+
+	switch websockets.SlowConsumerPolicy(config.WebSocketSlowConsumer) {
+	case "", websockets.DropClient, websockets.DropOldest:
+	default:
+		return nil, fmt.Errorf("unknown slow consumer policy %q", config.WebSocketSlowConsumer)
+	}
+
+	if config.WebSocketBackplane == "" {
+		config.WebSocketBackplane = BackplaneMemory
+	}
+
+	switch config.WebSocketBackplane {
+	case BackplaneMemory:
+	case BackplanePostgres:
+		if config.DatabaseUrl == "" {
+			return nil, errors.New("database url is required for the postgres backplane")
+		}
+	default:
+		return nil, fmt.Errorf("unknown websocket backplane %q", config.WebSocketBackplane)
+	}
+
+	switch config.Driver {
+	case DriverPostgres:
+		if config.DatabaseUrl == "" {
+			return nil, errors.New("database url is required")
+		}
+	case DriverMemory:
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", config.Driver)
+	}
+
+	hub := websockets.NewHub(websockets.HubConfig{
+		AllowedOrigins: config.AllowedOrigins,
+		SendBuffer:     config.WebSocketSendBuffer,
+		SlowConsumer:   websockets.SlowConsumerPolicy(config.WebSocketSlowConsumer),
+		EventLogSize:   config.WebSocketEventLog,
+
+		MaxConnections:        config.WebSocketMaxConnections,
+		MaxConnectionsPerUser: config.WebSocketMaxConnectionsPerUser,
+	})
+
 	broker := &Broker{
-		config: config,
-		router: mux.NewRouter(),
-		hub:    websockets.NewHub(),
+		config:     config,
+		router:     mux.NewRouter(),
+		hub:        hub,
+		keys:       keys,
+		outbox:     outbox.NewRelay(outbox.Config{PollInterval: config.OutboxPollInterval}, outbox.HubSink(hub), outbox.FeedSink(hub, 0)),
+		mailer:     mail,
+		background: NewBackground(backgroundTimeout),
 	}
 
 	return broker, nil
 
 }
 
-// Inicializa el broker del servidor
-func (b *Broker) Start(binder func(s Server, r *mux.Router)) {
+// Crea el servicio de emails indicado en la configuracion
+func newMailer(config *Config) (mailer.Mailer, error) {
+	if config.MailDriver == "" {
+		config.MailDriver = MailDriverFile
+	}
+	if config.MailFrom == "" {
+		config.MailFrom = "no-reply@localhost"
+	}
+
+	switch config.MailDriver {
+	case MailDriverFile:
+		if config.MailDir == "" {
+			config.MailDir = "mail"
+		}
+		return &mailer.FileMailer{Dir: config.MailDir, From: config.MailFrom}, nil
+	case MailDriverSMTP:
+		if config.SMTPAddr == "" {
+			return nil, errors.New("smtp address is required for the smtp mail driver")
+		}
+		return &mailer.SMTPMailer{
+			Addr:     config.SMTPAddr,
+			From:     config.MailFrom,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+		}, nil
+	case MailDriverMemory:
+		return mailer.NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", config.MailDriver)
+	}
+}
+
+// Crea el administrador de llaves a partir de la configuracion
+// El secreto HMAC se mantiene para verificar los tokens ya emitidos, pero si hay llaves asimetricas se firma con ellas
+func newKeyManager(config *Config) (*auth.KeyManager, error) {
+	keys := auth.NewKeyManager()
+
+	if config.JWTSecret != "" {
+		if err := keys.AddKey(auth.NewHMACKey("hs256", []byte(config.JWTSecret)), config.JWTKeysDir == ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.JWTKeysDir != "" {
+		if err := keys.LoadDir(config.JWTKeysDir, config.JWTActiveKeyId); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// Inicia el servidor y bloquea hasta que se cancela el contexto, se llama a Shutdown o el servidor HTTP falla
+// Al terminar apaga todo en orden: deja de aceptar peticiones y espera las que estan en curso,
+// espera las tareas en segundo plano, detiene el relay del outbox, cierra las conexiones de WebSockets, el backplane y el repositorio
+func (b *Broker) Start(ctx context.Context, binder func(s Server, r *mux.Router)) error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	b.mutex.Lock()
+	b.cancel, b.done = cancel, done
+	b.mutex.Unlock()
 
 	// Se crea el router
 	b.router = mux.NewRouter()
 	// Se relaciona el router con el broker
 	binder(b, b.router)
-	// Se habilita el CORS
-	handler := cors.Default().Handler(b.router)
+	// Las rutas que no existen tambien responden con el modelo de errores de la API
+	b.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Route not found")
+	})
+	b.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Method not allowed")
+	})
+	// Se habilita el CORS, se asigna un id a cada peticion y se limita el tamaño del cuerpo
+	handler := cors.Default().Handler(RequestIdMiddleware(BodyLimitMiddleware(b.config.MaxBodyBytes)(b.router)))
 
 	// Aqui se registra la implmentación específica de la base de datos
-	repo, err := database.NewPostgresRepository(b.config.DatabaseUrl)
+	repo, err := b.newRepository()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer repo.Close()
 
 	// A la implmentacion general del repositorio se le asigna la implementación específica
 	repository.SetRepository(repo)
 
+	// Se conecta el hub con las demas instancias del servidor
+	backplane, err := b.newBackplane()
+	if err != nil {
+		repo.Close()
+		return err
+	}
+
+	if err := b.hub.SetBackplane(backplane); err != nil {
+		backplane.Close()
+		repo.Close()
+		return err
+	}
+
 	// Se inicia el hub de websockets
 	go b.hub.Run()
 
+	// Se inicia el relay que publica los eventos guardados en el outbox
+	// Tiene su propio contexto para seguir publicando los eventos de las peticiones que terminan durante el apagado
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	relayDone := make(chan struct{})
+	go func() {
+		b.outbox.Run(relayCtx)
+		close(relayDone)
+	}()
+
 	// Se inicia el servidor
-	log.Println("Server started on port", b.Config().Port)
-	if err := http.ListenAndServe(":"+b.Config().Port, handler); err != nil {
-		log.Fatal("Server failed to start", err)
+	server := &http.Server{
+		Addr:              ":" + b.config.Port,
+		Handler:           handler,
+		ReadTimeout:       b.config.ReadTimeout,
+		ReadHeaderTimeout: b.config.ReadTimeout,
+		WriteTimeout:      b.config.WriteTimeout,
+		IdleTimeout:       b.config.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Println("Server started on port", b.config.Port)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	var result error
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			result = err
+		}
+	}
+
+	log.Println("Shutting down server")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), b.config.ShutdownTimeout)
+	defer cancelShutdown()
+
+	// Se guarda el primer error, los demas solo se registran
+	check := func(step string, err error) {
+		if err == nil {
+			return
+		}
+		if result == nil {
+			result = fmt.Errorf("%s: %w", step, err)
+			return
+		}
+		log.Printf("%s: %v", step, err)
+	}
+
+	// Los WebSockets ya no pertenecen al servidor HTTP, Shutdown no los espera
+	check("http server", server.Shutdown(shutdownCtx))
+
+	// Ya no llegan peticiones que inicien tareas, las que quedan todavia pueden usar el repositorio y el outbox
+	check("background tasks", b.background.Wait(shutdownCtx))
+
+	stopRelay()
+	select {
+	case <-relayDone:
+	case <-shutdownCtx.Done():
+		check("outbox relay", shutdownCtx.Err())
+	}
+
+	check("websocket hub", b.hub.Shutdown(shutdownCtx))
+	check("backplane", backplane.Close())
+	check("repository", repo.Close())
+
+	return result
+}
+
+// Detiene el servidor iniciado con Start y espera a que termine de apagarse o a que se cancele el contexto
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.mutex.Lock()
+	cancel, done := b.cancel, b.done
+	b.mutex.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Crea la implementacion especifica del repositorio segun el driver configurado
+func (b *Broker) newRepository() (repository.Repository, error) {
+	switch b.config.Driver {
+	case DriverMemory:
+		return database.NewMemoryRepository(), nil
+	default:
+		repo, err := database.NewPostgresRepository(b.config.DatabaseUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		// Si las migraciones fallan se cierra el pool de conexiones que ya se abrio
+		if b.config.AutoMigrate {
+			migrator, err := repo.Migrator()
+			if err != nil {
+				repo.Close()
+				return nil, err
+			}
+			if err := migrator.Up(context.Background()); err != nil {
+				repo.Close()
+				return nil, err
+			}
+		}
+
+		return repo, nil
+	}
+}
+
+// Crea el backplane con el que el hub reparte sus mensajes entre las instancias del servidor
+func (b *Broker) newBackplane() (websockets.Backplane, error) {
+	switch b.config.WebSocketBackplane {
+	case BackplanePostgres:
+		return database.NewPostgresBackplane(b.config.DatabaseUrl)
+	default:
+		return websockets.NewMemoryBackplane(), nil
 	}
 }
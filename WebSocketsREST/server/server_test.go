@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestBroker(t *testing.T, port string) *Broker {
+	broker, err := NewServer(context.Background(), &Config{
+		Port:            port,
+		JWTSecret:       "secret",
+		Driver:          DriverMemory,
+		ShutdownTimeout: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker
+}
+
+func TestStartShutdown(t *testing.T) {
+	broker := newTestBroker(t, "0")
+
+	result := make(chan error, 1)
+	go func() {
+		result <- broker.Start(context.Background(), func(s Server, r *mux.Router) {})
+	}()
+
+	// Se espera a que Start registre su contexto
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		broker.mutex.Lock()
+		started := broker.cancel != nil
+		broker.mutex.Unlock()
+		if started || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := broker.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown was incorrect, got %v", err)
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Start was incorrect, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+}
+
+func TestStartCanceledContext(t *testing.T) {
+	broker := newTestBroker(t, "0")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := broker.Start(ctx, func(s Server, r *mux.Router) {}); err != nil {
+		t.Errorf("Start was incorrect, got %v", err)
+	}
+}
+
+func TestStartReturnsListenError(t *testing.T) {
+	// El puerto ya esta ocupado, Start debe devolver el error en lugar de terminar el proceso
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	broker := newTestBroker(t, strconv.Itoa(listener.Addr().(*net.TCPAddr).Port))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- broker.Start(context.Background(), func(s Server, r *mux.Router) {})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Start should fail when the port is in use")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return the listen error")
+	}
+}
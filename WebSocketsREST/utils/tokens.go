@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"rest_ws/auth"
+	"rest_ws/models"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/segmentio/ksuid"
+)
+
+// Genera un access token firmado para el usuario
+// Cada token tiene un id unico (jti) para poder revocarlo antes de que expire
+func NewAccessToken(user *models.User, keys *auth.KeyManager, ttl time.Duration) (string, *models.AppClaims, error) {
+	jti, err := ksuid.NewRandom()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &models.AppClaims{
+		UserId:       user.Id,
+		Role:         user.Role,
+		IssuedAtNano: now.UnixNano(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+
+	signed, err := keys.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return signed, claims, nil
+}
+
+// Genera el token firmado que se envia por email para verificar la direccion del usuario
+func NewVerificationToken(user *models.User, keys *auth.KeyManager, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return keys.Sign(&models.VerificationClaims{
+		Email: user.Email,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.Id,
+			Audience:  models.VerificationAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	})
+}
+
+// Valida la firma, la expiracion y la audiencia de un token de verificacion
+func ParseVerificationToken(tokenString string, keys *auth.KeyManager) (*models.VerificationClaims, error) {
+	claims := &models.VerificationClaims{}
+	if _, err := keys.Parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyAudience(models.VerificationAudience, true) || claims.Subject == "" || claims.Email == "" {
+		return nil, errors.New("invalid verification token")
+	}
+	return claims, nil
+}
+
+// Genera un token de refresco opaco y el hash que se guarda en el repositorio
+func NewRefreshToken() (string, string, error) {
+	return NewOpaqueToken()
+}
+
+// Genera un token aleatorio de 256 bits y su hash, se usa para los tokens de refresco y de restablecimiento de contraseña
+func NewOpaqueToken() (string, string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buffer)
+	return token, HashToken(token), nil
+}
+
+// Devuelve el hash SHA-256 de un token opaco
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"rest_ws/auth"
 	"rest_ws/models"
 	"rest_ws/repository"
 	"strings"
@@ -11,13 +13,23 @@ import (
 )
 
 // Esta función se encarga de obtener el token de la cabecera de la petición y validarlo
-func GetTokenFromHeader(r *http.Request, secret string) (*jwt.Token, error) {
+func GetTokenFromHeader(r *http.Request, keys *auth.KeyManager) (*jwt.Token, error) {
+	return ParseToken(TokenFromHeader(r), keys)
+}
+
+// Devuelve el token de la cabecera Authorization sin validarlo
+// Se acepta tanto el token solo como el formato "Bearer <token>"
+func TokenFromHeader(r *http.Request) string {
 	tokenString := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(tokenString) > len("Bearer ") && strings.EqualFold(tokenString[:len("Bearer ")], "Bearer ") {
+		tokenString = strings.TrimSpace(tokenString[len("Bearer "):])
+	}
+	return tokenString
+}
 
-	// Se parsea el token
-	token, err := jwt.ParseWithClaims(tokenString, &models.AppClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+// Parsea y valida un token, el administrador de llaves solo acepta los algoritmos de las llaves configuradas
+func ParseToken(tokenString string, keys *auth.KeyManager) (*jwt.Token, error) {
+	token, err := keys.Parse(tokenString, &models.AppClaims{})
 	if err != nil {
 		return nil, err
 	}
@@ -26,11 +38,11 @@ func GetTokenFromHeader(r *http.Request, secret string) (*jwt.Token, error) {
 }
 
 // Esta función se encarga de obtener el los datos del usuario en función de las claims del token
-func GetUserIdFromToken(r *http.Request, token *jwt.Token) (*models.User, error) {
+func GetUserIdFromToken(ctx context.Context, token *jwt.Token) (*models.User, error) {
 
 	// Se valida las claims del token
 	if claims, ok := token.Claims.(*models.AppClaims); ok && token.Valid {
-		user, err := repository.FindUserById(r.Context(), claims.UserId)
+		user, err := repository.FindUserById(ctx, claims.UserId)
 		return user, err
 	} else {
 		return nil, errors.New("invalid token")
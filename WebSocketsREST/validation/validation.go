@@ -0,0 +1,178 @@
+package validation
+
+/*
+	Reglas de validacion de los datos que envian los clientes
+	Cada regla agrega los errores al Validator con el nombre del campo, asi el cliente recibe
+	todos los errores de una vez en el campo errors de la respuesta
+
+	Codigos de error de los campos:
+		required  -> el campo es obligatorio
+		invalid   -> el formato no es valido
+		too_short -> el valor es mas corto que el minimo
+		too_long  -> el valor es mas largo que el maximo
+		too_weak  -> la contraseña no cumple con la politica
+*/
+
+import (
+	"fmt"
+	"net/mail"
+	"rest_ws/problem"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	CodeRequired = "required"
+	CodeInvalid  = "invalid"
+	CodeTooShort = "too_short"
+	CodeTooLong  = "too_long"
+	CodeTooWeak  = "too_weak"
+)
+
+// Longitud maxima de un email segun el RFC 5321
+const MaxEmailLength = 254
+
+// bcrypt ignora todo lo que pasa de 72 bytes, una contraseña mas larga daria una falsa sensacion de seguridad
+const maxPasswordBytes = 72
+
+type Rules struct {
+	PasswordMinLength  int // Cantidad minima de caracteres de la contraseña, 8 por defecto
+	PasswordMinClasses int // Tipos de caracteres distintos (minusculas, mayusculas, digitos, simbolos), 2 por defecto
+	PostMaxLength      int // Cantidad maxima de caracteres del contenido de un post, 5000 por defecto
+	CommentMaxLength   int // Cantidad maxima de caracteres de un comentario, 2000 por defecto
+}
+
+// Completa las reglas que no se configuraron con los valores por defecto
+func (r Rules) WithDefaults() Rules {
+	if r.PasswordMinLength <= 0 {
+		r.PasswordMinLength = 8
+	}
+	if r.PasswordMinClasses <= 0 {
+		r.PasswordMinClasses = 2
+	}
+	if r.PasswordMinClasses > 4 {
+		r.PasswordMinClasses = 4
+	}
+	if r.PostMaxLength <= 0 {
+		r.PostMaxLength = 5000
+	}
+	if r.CommentMaxLength <= 0 {
+		r.CommentMaxLength = 2000
+	}
+	return r
+}
+
+// Acumula los errores de los campos de una peticion
+type Validator struct {
+	errors []problem.FieldError
+}
+
+func (v *Validator) Add(field string, code string, message string) {
+	v.errors = append(v.errors, problem.FieldError{Field: field, Code: code, Message: message})
+}
+
+func (v *Validator) Valid() bool {
+	return len(v.errors) == 0
+}
+
+func (v *Validator) Errors() []problem.FieldError {
+	return v.errors
+}
+
+// Normaliza un email: sin espacios alrededor y en minusculas
+// Asi "Ana@Example.com " y "ana@example.com" son la misma cuenta
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Valida un email ya normalizado, solo se acepta la direccion sin nombre ni comentarios
+func (v *Validator) Email(field string, email string) {
+	if email == "" {
+		v.Add(field, CodeRequired, "Email is required")
+		return
+	}
+	if len(email) > MaxEmailLength {
+		v.Add(field, CodeTooLong, fmt.Sprintf("Email must be at most %d characters", MaxEmailLength))
+		return
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		v.Add(field, CodeInvalid, "Email is not a valid address")
+		return
+	}
+
+	// Se exige un dominio con al menos un punto, "user@localhost" no es un email valido para la API
+	at := strings.LastIndex(email, "@")
+	if domain := email[at+1:]; !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		v.Add(field, CodeInvalid, "Email is not a valid address")
+	}
+}
+
+// Valida una contraseña nueva contra la politica configurada
+func (v *Validator) Password(field string, password string, rules Rules) {
+	if password == "" {
+		v.Add(field, CodeRequired, "Password is required")
+		return
+	}
+	if utf8.RuneCountInString(password) < rules.PasswordMinLength {
+		v.Add(field, CodeTooShort, fmt.Sprintf("Password must be at least %d characters", rules.PasswordMinLength))
+		return
+	}
+	if len(password) > maxPasswordBytes {
+		v.Add(field, CodeTooLong, fmt.Sprintf("Password must be at most %d bytes", maxPasswordBytes))
+		return
+	}
+
+	if passwordClasses(password) < rules.PasswordMinClasses {
+		v.Add(field, CodeTooWeak, fmt.Sprintf("Password must combine at least %d of: lowercase letters, uppercase letters, digits and symbols", rules.PasswordMinClasses))
+	}
+}
+
+// Cuenta los tipos de caracteres distintos de la contraseña
+func passwordClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsDigit(c):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// Valida que un texto no este vacio y no supere max caracteres
+func (v *Validator) Text(field string, value string, max int) {
+	if !utf8.ValidString(value) {
+		v.Add(field, CodeInvalid, "Value must be valid UTF-8")
+		return
+	}
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, CodeRequired, "Value is required")
+		return
+	}
+	if utf8.RuneCountInString(value) > max {
+		v.Add(field, CodeTooLong, fmt.Sprintf("Value must be at most %d characters", max))
+	}
+}
+
+// Valida que un texto no supere max bytes
+func (v *Validator) MaxLength(field string, value string, max int) {
+	if len(value) > max {
+		v.Add(field, CodeTooLong, fmt.Sprintf("Value must be at most %d characters", max))
+	}
+}
+
+// Valida que un campo obligatorio no este vacio
+func (v *Validator) Required(field string, value string) {
+	if value == "" {
+		v.Add(field, CodeRequired, "Value is required")
+	}
+}
@@ -0,0 +1,107 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+// Devuelve el codigo del primer error o "" si el valor es valido
+func firstCode(v *Validator) string {
+	if v.Valid() {
+		return ""
+	}
+	return v.Errors()[0].Code
+}
+
+func TestEmail(t *testing.T) {
+	tables := []struct {
+		email    string
+		expected string
+	}{
+		{"ana@example.com", ""},
+		{"ana.maria+tag@mail.example.co", ""},
+		{"", CodeRequired},
+		{"ana", CodeInvalid},
+		{"ana@", CodeInvalid},
+		{"@example.com", CodeInvalid},
+		{"ana@localhost", CodeInvalid},
+		{"ana@example.", CodeInvalid},
+		{"Ana <ana@example.com>", CodeInvalid},
+		{"ana@@example.com", CodeInvalid},
+		{strings.Repeat("a", 250) + "@example.com", CodeTooLong},
+	}
+
+	for _, item := range tables {
+		v := &Validator{}
+		v.Email("email", item.email)
+		if got := firstCode(v); got != item.expected {
+			t.Errorf("Email(%q) was incorrect, got %q expected %q", item.email, got, item.expected)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	if got := NormalizeEmail("  Ana@Example.COM "); got != "ana@example.com" {
+		t.Errorf("NormalizeEmail was incorrect, got %s", got)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	rules := Rules{}.WithDefaults()
+
+	tables := []struct {
+		password string
+		expected string
+	}{
+		{"correct-horse", ""},
+		{"Password", ""},
+		{"12345678a", ""},
+		{"", CodeRequired},
+		{"Ab1", CodeTooShort},
+		{"password", CodeTooWeak},
+		{"12345678", CodeTooWeak},
+		{strings.Repeat("a1", 40), CodeTooLong},
+	}
+
+	for _, item := range tables {
+		v := &Validator{}
+		v.Password("password", item.password, rules)
+		if got := firstCode(v); got != item.expected {
+			t.Errorf("Password(%q) was incorrect, got %q expected %q", item.password, got, item.expected)
+		}
+	}
+
+	// La politica es configurable
+	v := &Validator{}
+	v.Password("password", "Password1", Rules{PasswordMinLength: 12, PasswordMinClasses: 3})
+	if got := firstCode(v); got != CodeTooShort {
+		t.Errorf("configured min length was ignored, got %q", got)
+	}
+	v = &Validator{}
+	v.Password("password", "password-long", Rules{PasswordMinLength: 8, PasswordMinClasses: 3})
+	if got := firstCode(v); got != CodeTooWeak {
+		t.Errorf("configured min classes was ignored, got %q", got)
+	}
+}
+
+func TestText(t *testing.T) {
+	tables := []struct {
+		value    string
+		expected string
+	}{
+		{"hello", ""},
+		{strings.Repeat("ñ", 10), ""},
+		{"", CodeRequired},
+		{"   \n", CodeRequired},
+		{strings.Repeat("ñ", 11), CodeTooLong},
+		{"\xff", CodeInvalid},
+	}
+
+	for _, item := range tables {
+		v := &Validator{}
+		v.Text("content", item.value, 10)
+		if got := firstCode(v); got != item.expected {
+			t.Errorf("Text(%q) was incorrect, got %q expected %q", item.value, got, item.expected)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package websockets
+
+/*
+	El backplane reparte los mensajes del hub entre todas las instancias del servidor
+	Cada hub entrega los mensajes a sus propios clientes y ademas los publica en el backplane,
+	los demas nodos los reciben y los entregan a los clientes que tienen conectados
+
+	Implementaciones:
+		MemoryBackplane           -> en el mismo proceso, para un solo nodo y para pruebas
+		database.PostgresBackplane -> LISTEN/NOTIFY de PostgresSQL, para varias instancias
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+type Backplane interface {
+	// Publica un mensaje para todos los nodos suscritos, incluido el que lo envia
+	Publish(ctx context.Context, data []byte) error
+	// Registra la funcion que recibe los mensajes publicados por cualquier nodo
+	Subscribe(handler func(data []byte)) error
+	Close() error
+}
+
+const (
+	kindBroadcast = "broadcast"
+	kindTopic     = "topic"
+	kindUser      = "user"
+)
+
+// Cantidad de ids recientes que se recuerdan para descartar mensajes duplicados
+const seenCapacity = 4096
+
+// Mensaje que viaja por el backplane
+type envelope struct {
+	Id      string          `json:"id"`                // Id unico del mensaje, para descartar duplicados
+	Node    string          `json:"node"`              // Nodo que lo publico, ese nodo ya lo entrego a sus clientes
+	Kind    string          `json:"kind"`              // broadcast, topic o user
+	Targets []string        `json:"targets,omitempty"` // Topicos o usuario destino
+	Data    json.RawMessage `json:"data"`              // Mensaje ya serializado para los clientes
+}
+
+// Recuerda los ultimos ids recibidos en un buffer circular
+type seenSet struct {
+	mutex sync.Mutex
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+func newSeenSet(capacity int) *seenSet {
+	return &seenSet{
+		ids:   map[string]bool{},
+		order: make([]string, capacity),
+	}
+}
+
+// Devuelve true si el id ya se habia visto, si no lo registra
+func (s *seenSet) check(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ids[id] {
+		return true
+	}
+
+	if old := s.order[s.next]; old != "" {
+		delete(s.ids, old)
+	}
+	s.order[s.next] = id
+	s.next = (s.next + 1) % len(s.order)
+	s.ids[id] = true
+	return false
+}
+
+// Conecta el hub a un backplane, a partir de ese momento sus mensajes llegan a todos los nodos
+func (h *Hub) SetBackplane(backplane Backplane) error {
+	h.backplane = backplane
+	return backplane.Subscribe(h.receive)
+}
+
+// Publica en el backplane un mensaje que ya se entrego a los clientes locales
+func (h *Hub) forward(kind string, targets []string, data []byte) {
+	if h.backplane == nil {
+		return
+	}
+
+	message, err := json.Marshal(envelope{
+		Id:      ksuid.New().String(),
+		Node:    h.node,
+		Kind:    kind,
+		Targets: targets,
+		Data:    data,
+	})
+	if err != nil {
+		log.Println("Could not marshal envelope: ", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.backplane.Publish(ctx, message); err != nil {
+		log.Println("Could not publish to backplane: ", err)
+	}
+}
+
+// Recibe un mensaje del backplane y lo entrega a los clientes locales
+// Los mensajes propios se ignoran porque ya se entregaron al publicarlos
+func (h *Hub) receive(data []byte) {
+	var message envelope
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Println("Invalid backplane message: ", err)
+		return
+	}
+
+	if message.Node == h.node || h.seen.check(message.Id) {
+		return
+	}
+
+	h.deliverLocal(message.Kind, message.Targets, message.Data, nil)
+}
+
+// Backplane en memoria, reparte los mensajes entre los hubs del mismo proceso
+type MemoryBackplane struct {
+	mutex    sync.RWMutex
+	handlers []func(data []byte)
+}
+
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{}
+}
+
+func (m *MemoryBackplane) Publish(ctx context.Context, data []byte) error {
+	m.mutex.RLock()
+	handlers := m.handlers
+	m.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(data)
+	}
+	return nil
+}
+
+func (m *MemoryBackplane) Subscribe(handler func(data []byte)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Se crea un nuevo slice para no modificar el que puede estar recorriendo Publish
+	handlers := make([]func(data []byte), 0, len(m.handlers)+1)
+	m.handlers = append(append(handlers, m.handlers...), handler)
+	return nil
+}
+
+func (m *MemoryBackplane) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.handlers = nil
+	return nil
+}
@@ -0,0 +1,139 @@
+package websockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Crea dos hubs conectados por el mismo backplane, como dos instancias del servidor
+func TestBackplaneFanOut(t *testing.T) {
+	backplane := NewMemoryBackplane()
+
+	first, firstServer := newTestHub(t, HubConfig{})
+	second, secondServer := newTestHub(t, HubConfig{})
+	if err := first.SetBackplane(backplane); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SetBackplane(backplane); err != nil {
+		t.Fatal(err)
+	}
+
+	local, _, err := dial(t, firstServer, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, _, err := dial(t, secondServer, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, first, "alice", 1)
+	waitForUser(t, second, "alice", 1)
+
+	tables := []struct {
+		name    string
+		publish func()
+	}{
+		{"Broadcast", func() { first.Broadcast(map[string]string{"type": "Broadcast"}, nil) }},
+		{"SendToUser", func() { first.SendToUser("alice", map[string]string{"type": "SendToUser"}) }},
+	}
+
+	// Cada nodo numera los eventos con su propia secuencia
+	for i, item := range tables {
+		item.publish()
+		expected := fmt.Sprintf(`{"seq":%d,"type":"%s"}`, i+1, item.name)
+		for _, conn := range []*websocket.Conn{local, remote} {
+			if got := readMessage(t, conn); got != expected {
+				t.Errorf("%s was incorrect, got %s expected %s", item.name, got, expected)
+			}
+		}
+	}
+
+	// El nodo que publica no recibe su propio mensaje de vuelta, solo lo entrega una vez
+	local.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := local.ReadMessage(); err == nil {
+		t.Errorf("message was delivered twice: %s", data)
+	}
+}
+
+func TestBackplaneTopics(t *testing.T) {
+	backplane := NewMemoryBackplane()
+
+	first, _ := newTestHub(t, HubConfig{})
+	second, secondServer := newTestHub(t, HubConfig{})
+	first.SetBackplane(backplane)
+	second.SetBackplane(backplane)
+
+	conn, _, err := dial(t, secondServer, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, second, "alice", 1)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","payload":{"topic":"posts"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	readMessage(t, conn)
+
+	first.Publish("posts:123", map[string]string{"type": "other topic"})
+	first.Publish("posts", map[string]string{"type": "post"})
+	if got := readMessage(t, conn); got != `{"seq":2,"type":"post"}` {
+		t.Errorf("Publish was incorrect, got %s", got)
+	}
+}
+
+func TestBackplaneDuplicates(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{})
+
+	conn, _, err := dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "alice", 1)
+
+	message, err := json.Marshal(envelope{Id: "1", Node: "other", Kind: kindUser, Targets: []string{"alice"}, Data: []byte(`{"type":"once"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := json.Marshal(envelope{Id: "2", Node: hub.node, Kind: kindUser, Targets: []string{"alice"}, Data: []byte(`{"type":"own"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// El mismo mensaje recibido dos veces solo se entrega una y los mensajes propios se ignoran
+	hub.receive(message)
+	hub.receive(message)
+	hub.receive(own)
+
+	if got := readMessage(t, conn); got != `{"seq":1,"type":"once"}` {
+		t.Errorf("receive was incorrect, got %s", got)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := conn.ReadMessage(); err == nil {
+		t.Errorf("duplicate message was delivered: %s", data)
+	}
+}
+
+func TestSeenSetEviction(t *testing.T) {
+	seen := newSeenSet(2)
+
+	tables := []struct {
+		id       string
+		expected bool
+	}{
+		{"a", false},
+		{"a", true},
+		{"b", false},
+		{"c", false},
+		{"a", false},
+		{"c", true},
+	}
+
+	for _, item := range tables {
+		if got := seen.check(item.id); got != item.expected {
+			t.Errorf("check(%s) was incorrect, got %t expected %t", item.id, got, item.expected)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package websockets
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Registra en el hub un cliente cuya goroutine Write nunca se inicia, su cola nunca se vacia
+// Simula un navegador bloqueado de forma determinista
+func newStalledClient(t *testing.T, hub *Hub, userId string) *Client {
+	sockets := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		socket, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sockets <- socket
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client := NewClient(hub, <-sockets, userId)
+	hub.OnConnect(client)
+	return client
+}
+
+func isConnected(hub *Hub, client *Client) bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return hub.clients[client]
+}
+
+func TestSlowClientDoesNotStallBroadcast(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{SendBuffer: 8, SlowConsumer: DropClient})
+
+	fast, _, err := dial(t, server, "?token=valid-fast", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "fast", 1)
+
+	slow := newStalledClient(t, hub, "slow")
+
+	for i := 0; i < 50; i++ {
+		done := make(chan bool)
+		go func(i int) {
+			hub.Broadcast(map[string]int{"n": i}, nil)
+			close(done)
+		}(i)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Broadcast %d blocked on a slow client", i)
+		}
+
+		if got, expected := readMessage(t, fast), fmt.Sprintf(`{"seq":%d,"n":%d}`, i+1, i); got != expected {
+			t.Fatalf("fast client got %s expected %s", got, expected)
+		}
+	}
+
+	if isConnected(hub, slow) {
+		t.Errorf("slow client should have been evicted")
+	}
+	if !isConnected(hub, hubClient(t, hub, "fast")) {
+		t.Errorf("fast client should still be connected")
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	hub, _ := newTestHub(t, HubConfig{SendBuffer: 4, SlowConsumer: DropOldest})
+	slow := newStalledClient(t, hub, "slow")
+
+	for i := 0; i < 10; i++ {
+		hub.Broadcast(map[string]int{"n": i}, nil)
+	}
+
+	if !isConnected(hub, slow) {
+		t.Fatalf("slow client should not be evicted with DropOldest")
+	}
+
+	// La cola conserva los mensajes mas recientes en orden
+	for i := 6; i < 10; i++ {
+		select {
+		case data := <-slow.outbound:
+			if expected := fmt.Sprintf(`{"seq":%d,"n":%d}`, i+1, i); string(data) != expected {
+				t.Errorf("queued message was incorrect, got %s expected %s", data, expected)
+			}
+		default:
+			t.Fatalf("queue has fewer messages than expected")
+		}
+	}
+}
+
+func TestHeartbeatEvictsUnresponsiveClient(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{PongWait: 300 * time.Millisecond, PingPeriod: 100 * time.Millisecond})
+
+	// El cliente responde a los pings solo mientras lee del socket
+	alive, _, err := dial(t, server, "?token=valid-alive", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, _, err := dial(t, server, "?token=valid-dead", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForUser(t, hub, "alive", 1)
+	waitForUser(t, hub, "dead", 1)
+
+	// Sin pongs el plazo de lectura expira y el hub lo desregistra
+	waitForUser(t, hub, "dead", 0)
+	time.Sleep(500 * time.Millisecond)
+	waitForUser(t, hub, "alive", 1)
+}
+
+func hubClient(t *testing.T, hub *Hub, userId string) *Client {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for client := range hub.users[userId] {
+		return client
+	}
+	t.Fatalf("user %s has no connections", userId)
+	return nil
+}
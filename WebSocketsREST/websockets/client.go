@@ -1,33 +1,149 @@
 package websockets
 
-import "github.com/gorilla/websocket"
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/segmentio/ksuid"
+)
+
+// Tamaño maximo de los mensajes que puede enviar un cliente
+const maxMessageSize = 4096
 
 type Client struct {
 	hub      *Hub            // El hub al que pertenece el cliente
-	id       string          // El id del cliente
+	id       string          // El id unico de la conexion
+	userId   string          // El id del usuario autenticado dueño de la conexion
 	socket   *websocket.Conn // La conexión websocket
-	outbound chan []byte     // Canal para enviar mensajes al cliente
+	outbound chan []byte     // Cola acotada de mensajes para enviar al cliente
+	topics   map[string]bool // Topicos a los que esta suscrito, protegido por el mutex del hub
+	lastAck  string          // Id del ultimo mensaje que el cliente confirmo, protegido por el mutex del hub
+	reserved bool            // Tiene un lugar reservado en el hub hasta que se registra, protegido por el mutex del hub
+
+	mutex  sync.Mutex // Protege el envio a la cola y su cierre
+	closed bool       // Indica si la cola ya se cerro
 }
 
-func NewClient(hub *Hub, socket *websocket.Conn) *Client {
+func NewClient(hub *Hub, socket *websocket.Conn, userId string) *Client {
 	return &Client{
 		hub:      hub,
+		id:       ksuid.New().String(),
+		userId:   userId,
 		socket:   socket,
-		outbound: make(chan []byte),
+		outbound: make(chan []byte, hub.config.SendBuffer),
+		topics:   map[string]bool{},
+	}
+}
+
+// Devuelve el id del usuario autenticado dueño de la conexion
+func (c *Client) UserId() string {
+	return c.userId
+}
+
+// Encola un mensaje sin bloquear nunca al llamador
+// Si la cola esta llena se aplica la politica del hub: con DropOldest se descarta el mensaje mas antiguo,
+// con DropClient se devuelve false para que el hub desconecte al cliente
+func (c *Client) send(data []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return true
+	}
+
+	select {
+	case c.outbound <- data:
+		return true
+	default:
+	}
+
+	if c.hub.config.SlowConsumer == DropClient {
+		return false
+	}
+
+	// La goroutine Write puede vaciar la cola al mismo tiempo, por eso ninguna operacion bloquea
+	select {
+	case <-c.outbound:
+	default:
+	}
+	select {
+	case c.outbound <- data:
+	default:
+	}
+	return true
+}
+
+// Cierra la cola, la goroutine Write envia el mensaje de cierre y termina
+// Se puede llamar mas de una vez
+func (c *Client) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.outbound)
 	}
 }
 
+// Lee los mensajes entrantes del cliente y los ejecuta como comandos
+// Cuando la conexion se cierra, falla o deja de responder a los pings se desregistra el cliente del hub
+func (c *Client) Read() {
+	defer func() {
+		// Despues de apagar el hub Run ya no atiende el canal
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+			c.hub.OnDisconnect(c)
+		}
+	}()
+
+	c.socket.SetReadLimit(maxMessageSize)
+	c.socket.SetReadDeadline(time.Now().Add(c.hub.config.PongWait))
+	// Cada pong extiende el plazo de lectura, si el cliente deja de responder la lectura falla
+	c.socket.SetPongHandler(func(string) error {
+		return c.socket.SetReadDeadline(time.Now().Add(c.hub.config.PongWait))
+	})
+
+	for {
+		_, data, err := c.socket.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("Client read error: ", err)
+			}
+			return
+		}
+		c.hub.handleCommand(c, data)
+	}
+}
+
+// Escribe los mensajes de la cola y envia pings periodicos al cliente
+// Es la unica goroutine que escribe en el socket
 func (c *Client) Write() {
+	ticker := time.NewTicker(c.hub.config.PingPeriod)
+	defer func() {
+		ticker.Stop()
+		// Al cerrar el socket la goroutine Read falla y desregistra al cliente
+		c.socket.Close()
+	}()
 
-	// De manera indefinida, se leen los mensajes del canal outbound
 	for {
 		select {
 		case message, ok := <-c.outbound:
+			c.socket.SetWriteDeadline(time.Now().Add(c.hub.config.WriteWait))
 			if !ok {
 				c.socket.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			c.socket.WriteMessage(websocket.TextMessage, message)
+			if err := c.socket.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.socket.SetWriteDeadline(time.Now().Add(c.hub.config.WriteWait))
+			if err := c.socket.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,146 @@
+package websockets
+
+/*
+	Registro de los eventos enviados por el hub para reenviarlos a los clientes que se reconectan
+
+	Cada evento lleva un numero de secuencia creciente en el campo "seq" del mensaje:
+
+		{"seq": 42, "type": "post", "payload": {...}}
+
+	La secuencia es propia de cada instancia del hub y se identifica con su epoch, que cambia al reiniciar.
+	Al reconectarse el cliente vuelve a suscribirse a sus topicos y envia la ultima secuencia que recibio:
+
+		{"type": "resume", "payload": {"epoch": "...", "seq": 42}} -> se reenvian los eventos perdidos y
+		                                                             {"type": "resumed", "payload": {"epoch": "...", "seq": 50, "replayed": 3}}
+
+	Si el epoch no coincide o los eventos perdidos ya no estan en el registro se responde
+	{"type": "resync", "payload": {"epoch": "...", "seq": 50}} y el cliente debe volver a cargar todo por REST
+*/
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Cantidad de eventos que se guardan por defecto para reenviar
+const defaultEventLogSize = 1024
+
+var errResyncRequired = errors.New("events are no longer available")
+
+type event struct {
+	seq     uint64
+	kind    string   // broadcast, topic o user
+	targets []string // Topicos o usuario destino
+	data    []byte   // Mensaje serializado, ya incluye la secuencia
+}
+
+// Buffer circular con los ultimos eventos, protegido por el mutex del hub
+type eventLog struct {
+	events []event
+	next   int    // Posicion donde se escribe el siguiente evento
+	count  int    // Cantidad de eventos guardados
+	seq    uint64 // Secuencia del ultimo evento
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]event, size)}
+}
+
+// Asigna la siguiente secuencia al mensaje y lo guarda, descartando el mas antiguo si el registro esta lleno
+func (l *eventLog) append(kind string, targets []string, data []byte) event {
+	l.seq++
+	item := event{seq: l.seq, kind: kind, targets: targets, data: withSeq(data, l.seq)}
+
+	l.events[l.next] = item
+	l.next = (l.next + 1) % len(l.events)
+	if l.count < len(l.events) {
+		l.count++
+	}
+	return item
+}
+
+// Devuelve los eventos posteriores a seq en orden
+// Si seq es posterior al ultimo evento o ya se descartaron eventos que el cliente no recibio devuelve errResyncRequired
+func (l *eventLog) since(seq uint64) ([]event, error) {
+	if seq > l.seq {
+		return nil, errResyncRequired
+	}
+
+	missed := int(l.seq - seq)
+	if missed > l.count {
+		return nil, errResyncRequired
+	}
+
+	events := make([]event, 0, missed)
+	for i := l.count - missed; i < l.count; i++ {
+		events = append(events, l.events[(l.next-l.count+i+len(l.events))%len(l.events)])
+	}
+	return events, nil
+}
+
+// Agrega el campo seq al inicio del mensaje
+// Los mensajes que no son objetos JSON se envian sin secuencia
+func withSeq(data []byte, seq uint64) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+
+	stamped := make([]byte, 0, len(data)+24)
+	stamped = append(stamped, `{"seq":`...)
+	stamped = strconv.AppendUint(stamped, seq, 10)
+	if string(data) != "{}" {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, data[1:]...)
+}
+
+// Indica si el cliente recibe el evento
+func (c *Client) wants(item event) bool {
+	switch item.kind {
+	case kindTopic:
+		for _, topic := range item.targets {
+			if c.topics[topic] {
+				return true
+			}
+		}
+		return false
+	case kindUser:
+		return len(item.targets) > 0 && c.userId == item.targets[0]
+	default:
+		return true
+	}
+}
+
+// Reenvia al cliente los eventos posteriores a seq que le corresponden
+// Se ejecuta con el mutex del hub tomado, asi los eventos nuevos se encolan despues de los reenviados
+// Si no caben todos en la cola del cliente se pide un resync en lugar de desconectarlo
+func (h *Hub) replay(client *Client, epoch string, seq uint64) (ResumePayload, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	position := ResumePayload{Epoch: h.node, Seq: h.events.seq}
+	if epoch != h.node {
+		return position, errResyncRequired
+	}
+
+	events, err := h.events.since(seq)
+	if err != nil {
+		return position, err
+	}
+
+	var pending [][]byte
+	for _, item := range events {
+		if client.wants(item) {
+			pending = append(pending, item.data)
+		}
+	}
+	if len(pending) > cap(client.outbound)-len(client.outbound) {
+		return position, errResyncRequired
+	}
+
+	for _, data := range pending {
+		client.send(data)
+	}
+	position.Replayed = len(pending)
+	return position, nil
+}
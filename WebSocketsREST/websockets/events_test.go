@@ -0,0 +1,123 @@
+package websockets
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWithSeq(t *testing.T) {
+	tables := []struct {
+		data     string
+		expected string
+	}{
+		{`{"type":"post"}`, `{"seq":7,"type":"post"}`},
+		{`{}`, `{"seq":7}`},
+		{`[1,2]`, `[1,2]`},
+		{`"text"`, `"text"`},
+	}
+
+	for _, item := range tables {
+		if got := string(withSeq([]byte(item.data), 7)); got != item.expected {
+			t.Errorf("withSeq(%s) was incorrect, got %s expected %s", item.data, got, item.expected)
+		}
+	}
+}
+
+func TestEventLogSince(t *testing.T) {
+	log := newEventLog(3)
+	for i := 0; i < 5; i++ {
+		log.append(kindBroadcast, nil, []byte(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+
+	tables := []struct {
+		seq    uint64
+		events int
+		err    error
+	}{
+		{5, 0, nil},
+		{4, 1, nil},
+		{2, 3, nil},
+		{1, 0, errResyncRequired},
+		{0, 0, errResyncRequired},
+		{6, 0, errResyncRequired},
+	}
+
+	for _, item := range tables {
+		events, err := log.since(item.seq)
+		if err != item.err || len(events) != item.events {
+			t.Errorf("since(%d) was incorrect, got %d events and %v expected %d events and %v", item.seq, len(events), err, item.events, item.err)
+			continue
+		}
+		for i, event := range events {
+			if event.seq != item.seq+uint64(i)+1 {
+				t.Errorf("since(%d) returned seq %d at %d", item.seq, event.seq, i)
+			}
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{EventLogSize: 4})
+
+	send := func(conn *websocket.Conn, message string) {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, _, err := dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "alice", 1)
+	send(conn, `{"type":"subscribe","payload":{"topic":"posts"}}`)
+	readMessage(t, conn)
+
+	hub.Publish("posts", map[string]int{"n": 1})
+	if got := readMessage(t, conn); got != `{"seq":1,"n":1}` {
+		t.Fatalf("Publish was incorrect, got %s", got)
+	}
+
+	// Mientras el cliente esta desconectado se pierde un evento de su topico y otro que no le corresponde
+	conn.Close()
+	waitForUser(t, hub, "alice", 0)
+	hub.Publish("posts", map[string]int{"n": 2})
+	hub.SendToUser("bob", map[string]int{"n": 3})
+
+	conn, _, err = dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "alice", 1)
+	send(conn, `{"type":"subscribe","payload":{"topic":"posts"}}`)
+	readMessage(t, conn)
+
+	send(conn, fmt.Sprintf(`{"type":"resume","payload":{"epoch":"%s","seq":1}}`, hub.node))
+	if got := readMessage(t, conn); got != `{"seq":2,"n":2}` {
+		t.Errorf("replayed event was incorrect, got %s", got)
+	}
+	expected := fmt.Sprintf(`{"type":"resumed","payload":{"epoch":"%s","seq":3,"replayed":1}}`, hub.node)
+	if got := readMessage(t, conn); got != expected {
+		t.Errorf("resume was incorrect, got %s expected %s", got, expected)
+	}
+
+	// Otro epoch o eventos que ya no estan en el registro requieren recargar todo
+	for i := 0; i < 4; i++ {
+		hub.SendToUser("bob", map[string]int{"n": 4 + i})
+	}
+	resync := fmt.Sprintf(`{"type":"resync","payload":{"epoch":"%s","seq":7}}`, hub.node)
+	tables := []string{
+		`{"type":"resume"}`,
+		`{"type":"resume","payload":{"epoch":"other","seq":1}}`,
+		fmt.Sprintf(`{"type":"resume","payload":{"epoch":"%s","seq":2}}`, hub.node),
+		fmt.Sprintf(`{"type":"resume","payload":{"epoch":"%s","seq":9}}`, hub.node),
+	}
+	for _, request := range tables {
+		send(conn, request)
+		if got := readMessage(t, conn); got != resync {
+			t.Errorf("%s was incorrect, got %s expected %s", request, got, resync)
+		}
+	}
+}
@@ -1,54 +1,255 @@
 package websockets
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"rest_ws/problem"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/segmentio/ksuid"
 )
 
-// Actualiza la conexión del cliente a una que soporte WebSockets
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+var errInvalidAuthMessage = errors.New("first message must be an auth message with a token")
+
+// Valida un token y devuelve el id del usuario dueño del token
+// El hub no conoce como se firman los tokens, el servidor le inyecta esta funcion
+type Authenticator func(ctx context.Context, token string) (string, error)
+
+// Politica que se aplica cuando la cola de un cliente esta llena
+type SlowConsumerPolicy string
+
+const (
+	DropClient SlowConsumerPolicy = "drop_client" // Se desconecta al cliente, al reconectar puede pedir lo que perdio
+	DropOldest SlowConsumerPolicy = "drop_oldest" // Se descarta el mensaje mas antiguo de su cola
+)
+
+type HubConfig struct {
+	AllowedOrigins []string      // Origenes permitidos, "*" permite cualquiera y vacio solo el mismo origen
+	AuthTimeout    time.Duration // Tiempo para enviar el token en el primer mensaje, 10 segundos por defecto
+
+	SendBuffer   int                // Tamaño de la cola de mensajes de cada cliente, 256 por defecto
+	SlowConsumer SlowConsumerPolicy // Que hacer cuando la cola de un cliente se llena, DropClient por defecto
+	WriteWait    time.Duration      // Tiempo maximo para escribir un mensaje, 10 segundos por defecto
+	PongWait     time.Duration      // Tiempo maximo sin recibir un pong, 60 segundos por defecto
+	PingPeriod   time.Duration      // Cada cuanto se envia un ping, debe ser menor que PongWait
+
+	EventLogSize int // Cantidad de eventos que se guardan para reenviar al reconectar, 1024 por defecto
+
+	MaxConnections        int // Conexiones abiertas en el hub, 0 sin limite
+	MaxConnectionsPerUser int // Conexiones abiertas de un mismo usuario, 0 sin limite
 }
 
 type Hub struct {
-	clients    []*Client    // Lista de clientes conectados
-	register   chan *Client // Canal para registrar nuevos clientes
-	unregister chan *Client // Canal para desconectar clientes
-	mutex      *sync.Mutex  // Mutex para proteger la lista de clientes
+	clients      map[*Client]bool            // Clientes conectados
+	users        map[string]map[*Client]bool // Clientes conectados agrupados por usuario
+	topics       map[string]map[*Client]bool // Clientes suscritos agrupados por topico
+	register     chan *Client                // Canal para registrar nuevos clientes
+	unregister   chan *Client                // Canal para desconectar clientes
+	mutex        *sync.Mutex                 // Mutex para proteger los mapas de clientes
+	upgrader     websocket.Upgrader          // Actualiza la conexión del cliente a una que soporte WebSockets
+	authenticate Authenticator               // Valida los tokens de las conexiones
+	config       HubConfig
+	node         string        // Id de este nodo en el backplane
+	backplane    Backplane     // Reparte los mensajes entre las instancias del servidor, nil si solo hay una
+	seen         *seenSet      // Ids de los mensajes recibidos del backplane
+	events       *eventLog     // Ultimos eventos enviados, protegido por el mutex
+	done         chan struct{} // Se cierra al apagar el hub, detiene Run y rechaza nuevas conexiones
+	shutdown     sync.Once
+	pending      int            // Conexiones autenticadas que todavia no se registran, protegido por el mutex
+	pendingUsers map[string]int // Conexiones pendientes de cada usuario, protegido por el mutex
+}
+
+// Mensaje con el que un cliente se autentica cuando no puede enviar el token en la cabecera ni en la url
+// {"type": "auth", "payload": {"token": "..."}}
+type authMessage struct {
+	Type    string `json:"type"`
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
 }
 
-func NewHub() *Hub {
+func NewHub(config HubConfig) *Hub {
+	if config.AuthTimeout == 0 {
+		config.AuthTimeout = 10 * time.Second
+	}
+	if config.SendBuffer <= 0 {
+		config.SendBuffer = 256
+	}
+	if config.SlowConsumer == "" {
+		config.SlowConsumer = DropClient
+	}
+	if config.WriteWait == 0 {
+		config.WriteWait = 10 * time.Second
+	}
+	if config.PongWait == 0 {
+		config.PongWait = 60 * time.Second
+	}
+	if config.PingPeriod == 0 || config.PingPeriod >= config.PongWait {
+		config.PingPeriod = config.PongWait * 9 / 10
+	}
+	if config.EventLogSize <= 0 {
+		config.EventLogSize = defaultEventLogSize
+	}
+
 	return &Hub{
-		clients:    []*Client{},
+		clients:    map[*Client]bool{},
+		users:      map[string]map[*Client]bool{},
+		topics:     map[string]map[*Client]bool{},
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		mutex:      &sync.Mutex{},
+		upgrader:   websocket.Upgrader{CheckOrigin: checkOrigin(config.AllowedOrigins)},
+		config:     config,
+		node:       ksuid.New().String(),
+		seen:       newSeenSet(seenCapacity),
+		events:     newEventLog(config.EventLogSize),
+		done:       make(chan struct{}),
+
+		pendingUsers: map[string]int{},
+	}
+}
+
+// Asigna la funcion con la que se validan los tokens, sin ella se rechazan todas las conexiones
+func (h *Hub) SetAuthenticator(authenticate Authenticator) {
+	h.authenticate = authenticate
+}
+
+// Sin origenes configurados se usa la validacion de gorilla, que solo acepta el mismo origen
+// Las peticiones sin cabecera Origin no vienen de un navegador y se aceptan
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, item := range allowed {
+			if item == "*" || strings.EqualFold(item, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Obtiene el token de la cabecera Authorization o del parametro token de la url
+// Los navegadores no permiten enviar cabeceras al abrir un WebSocket, por eso se acepta la url
+func tokenFromRequest(r *http.Request) string {
+	token := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(token) > len("Bearer ") && strings.EqualFold(token[:len("Bearer ")], "Bearer ") {
+		token = strings.TrimSpace(token[len("Bearer "):])
+	}
+	if token == "" {
+		token = r.URL.Query().Get("token")
 	}
+	return token
 }
 
 func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
+	if h.authenticate == nil {
+		problem.Error(w, r, http.StatusServiceUnavailable, problem.CodeUnavailable, "WebSocket authentication is not configured")
+		return
+	}
+
+	if h.closed() {
+		problem.Error(w, r, http.StatusServiceUnavailable, problem.CodeUnavailable, "Server is shutting down")
+		return
+	}
+
+	// Con el hub lleno no vale la pena autenticar ni actualizar la conexion
+	if h.full() {
+		limitError(w, r, errHubFull)
+		return
+	}
+
+	// Si el token viene en la peticion se valida y se reserva el lugar antes de actualizar la conexion
+	var userId string
+	if token := tokenFromRequest(r); token != "" {
+		var err error
+		userId, err = h.authenticate(r.Context(), token)
+		if err != nil {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Invalid token")
+			return
+		}
+		if err := h.reserve(userId); err != nil {
+			limitError(w, r, err)
+			return
+		}
+	}
+
 	// Se actualiza la conexión a una que soporte WebSockets
-	socket, err := upgrader.Upgrade(w, r, nil)
+	socket, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		// Upgrade ya respondio al cliente con el error
 		log.Println(err)
-		http.Error(w, "Could not open websocket connection", http.StatusBadRequest)
+		if userId != "" {
+			h.unreserve(userId)
+		}
+		return
+	}
+
+	// Si no venia el token, el primer mensaje debe ser el de autenticacion
+	if userId == "" {
+		userId, err = h.authenticateFirstMessage(r.Context(), socket)
+		if err != nil {
+			closeSocket(socket, websocket.ClosePolicyViolation, "authentication required")
+			return
+		}
+		if err := h.reserve(userId); err != nil {
+			closeLimited(socket, err)
+			return
+		}
 	}
 
 	// Se crea un nuevo cliente y se registra en el canal de registro del hub
-	client := NewClient(h, socket)
-	h.register <- client
-	// Se ejecuta como goroutine la función que lee los mensajes del entrantes del cliente
+	// Si el hub se apago mientras tanto se cierra la conexion
+	client := NewClient(h, socket, userId)
+	client.reserved = true
+	select {
+	case h.register <- client:
+	case <-h.done:
+		h.unreserve(userId)
+		closeSocket(socket, websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+	// Se ejecutan como goroutines las funciones que leen los comandos del cliente y escriben los mensajes salientes
 	go client.Write()
+	go client.Read()
+}
+
+func (h *Hub) authenticateFirstMessage(ctx context.Context, socket *websocket.Conn) (string, error) {
+	socket.SetReadDeadline(time.Now().Add(h.config.AuthTimeout))
+	defer socket.SetReadDeadline(time.Time{})
+
+	var message authMessage
+	if err := socket.ReadJSON(&message); err != nil {
+		return "", err
+	}
+	if message.Type != "auth" || message.Payload.Token == "" {
+		return "", errInvalidAuthMessage
+	}
+
+	return h.authenticate(ctx, message.Payload.Token)
+}
+
+// Envia un mensaje de cierre y cierra la conexion
+func closeSocket(socket *websocket.Conn, code int, reason string) {
+	socket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	socket.Close()
 }
 
 // Se inicia la escucha de los canales de registro y desconexión de clientes del hub
+// Termina cuando se apaga el hub con Shutdown
 func (h *Hub) Run() {
 	for {
 		select {
@@ -56,53 +257,228 @@ func (h *Hub) Run() {
 			h.OnConnect(client)
 		case client := <-h.unregister:
 			h.OnDisconnect(client)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *Hub) closed() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Apaga el hub: detiene Run, rechaza nuevas conexiones y envia un mensaje de cierre a todos los clientes
+// Espera a que los clientes cierren su conexion hasta que se cancela el contexto, entonces cierra las que quedan
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.shutdown.Do(func() {
+		close(h.done)
+	})
+
+	h.mutex.Lock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mutex.Unlock()
+
+	// WriteControl se puede llamar al mismo tiempo que la goroutine Write escribe
+	for _, client := range clients {
+		client.socket.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(h.config.WriteWait))
+	}
+
+	// Los clientes responden al cierre y su goroutine Read los quita del hub
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		h.mutex.Lock()
+		remaining := len(h.clients)
+		h.mutex.Unlock()
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, client := range clients {
+				client.socket.Close()
+				h.remove(client)
+			}
+			return ctx.Err()
+		case <-ticker.C:
 		}
 	}
 }
 
-// Cuando un cliente se conecta, se agrega a la lista de clientes del hub
-// Se utiliza el mutex para proteger la lista de lectura y escritura concurrente
+// Cuando un cliente se conecta, se agrega a los clientes del hub
+// Se utiliza el mutex para proteger los mapas de lectura y escritura concurrente
 func (h *Hub) OnConnect(client *Client) {
-	log.Println("Client connected: ", client.socket.RemoteAddr())
+	log.Println("Client connected: ", client.socket.RemoteAddr(), client.userId)
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
-	// Se establece el id del cliente como la dirección IP del socket
-	client.id = client.socket.RemoteAddr().String()
-	// Se agrega el cliente a la lista de clientes del hub
-	h.clients = append(h.clients, client)
+
+	// La conexion ocupa el lugar que reservo al autenticarse
+	if client.reserved {
+		h.release(client.userId)
+		client.reserved = false
+	}
+
+	h.clients[client] = true
+	if h.users[client.userId] == nil {
+		h.users[client.userId] = map[*Client]bool{}
+	}
+	h.users[client.userId][client] = true
 }
 
-// Cuando un cliente se desconecta, se agrega a la lista de clientes del hub
-// Se utiliza el mutex para proteger la lista de lectura y escritura concurrente
+// Cuando un cliente se desconecta, se elimina de los clientes del hub
+// Se utiliza el mutex para proteger los mapas de lectura y escritura concurrente
 func (h *Hub) OnDisconnect(client *Client) {
-	log.Println("Client disconnected: ", client.socket.RemoteAddr())
+	log.Println("Client disconnected: ", client.socket.RemoteAddr(), client.userId)
 	client.socket.Close()
 
+	h.remove(client)
+}
+
+// Quita al cliente de todos los mapas del hub y cierra su cola
+// Al cerrar la cola la goroutine Write envia el mensaje de cierre y termina
+func (h *Hub) remove(client *Client) {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
+	delete(h.clients, client)
+	removeFrom(h.users, client.userId, client)
+	for topic := range client.topics {
+		removeFrom(h.topics, topic, client)
+	}
+	h.mutex.Unlock()
+
+	client.close()
+}
 
-	// Se busca el cliente en la lista de clientes del hub
-	// TODO: No seria necesario buscar el cliente, si se utiliza un map
-	for i, c := range h.clients {
-		if c.id == client.id {
-			h.clients = append(h.clients[:i], h.clients[i+1:]...)
-			break
+// Desconecta a un cliente que no consume sus mensajes a tiempo
+func (h *Hub) evict(client *Client) {
+	log.Println("Slow client evicted: ", client.socket.RemoteAddr(), client.userId)
+	h.remove(client)
+}
+
+// Quita al cliente de un grupo y elimina el grupo si queda vacio
+func removeFrom(groups map[string]map[*Client]bool, key string, client *Client) {
+	if group, ok := groups[key]; ok {
+		delete(group, client)
+		if len(group) == 0 {
+			delete(groups, key)
 		}
 	}
 }
 
-// Se envía un mensaje a todos los clientes del hub
-func (h *Hub) Broadcast(message interface{}, ignore *Client) {
+// Suscribe al cliente a un topico
+func (h *Hub) Subscribe(client *Client, topic string) error {
+	if err := authorizeTopic(client, topic); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !client.topics[topic] && len(client.topics) >= maxTopicsPerClient {
+		return errTooManyTopics
+	}
 
-	data, _ := json.Marshal(message)
+	client.topics[topic] = true
+	if h.topics[topic] == nil {
+		h.topics[topic] = map[*Client]bool{}
+	}
+	h.topics[topic][client] = true
+	return nil
+}
 
+// Cancela la suscripcion del cliente a un topico
+func (h *Hub) Unsubscribe(client *Client, topic string) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	// Se recorre la lista de clientes del hub
-	for _, client := range h.clients {
-		if client != ignore {
-			client.outbound <- data
+	delete(client.topics, topic)
+	removeFrom(h.topics, topic, client)
+}
+
+// Se envía un mensaje a los clientes suscritos a un topico, en este nodo y en los demas
+func (h *Hub) Publish(topic string, message interface{}) {
+	h.dispatch(kindTopic, []string{topic}, message, nil)
+}
+
+// Se envía un mensaje a los clientes suscritos a cualquiera de los topicos
+// Los clientes suscritos a varios de ellos lo reciben una sola vez
+func (h *Hub) PublishAll(topics []string, message interface{}) {
+	h.dispatch(kindTopic, topics, message, nil)
+}
+
+// Se envía un mensaje a todos los clientes del hub, en este nodo y en los demas
+func (h *Hub) Broadcast(message interface{}, ignore *Client) {
+	h.dispatch(kindBroadcast, nil, message, ignore)
+}
+
+// Se envía un mensaje a todas las conexiones de un usuario, en este nodo y en los demas
+func (h *Hub) SendToUser(userId string, message interface{}) {
+	h.dispatch(kindUser, []string{userId}, message, nil)
+}
+
+// Entrega el mensaje a los clientes locales y lo reenvia al backplane para los demas nodos
+func (h *Hub) dispatch(kind string, targets []string, message interface{}, ignore *Client) {
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Println("Could not marshal message: ", err)
+		return
+	}
+
+	h.deliverLocal(kind, targets, data, ignore)
+	h.forward(kind, targets, data)
+}
+
+// Entrega el mensaje solo a los clientes conectados a este nodo
+// El evento se registra con su secuencia y se encola sin soltar el mutex, asi todos los clientes lo reciben en el mismo orden
+// Los clientes cuya cola esta llena se desconectan despues de soltarlo, sin bloquear nunca
+func (h *Hub) deliverLocal(kind string, targets []string, data []byte, ignore *Client) {
+
+	var slow []*Client
+
+	h.mutex.Lock()
+	item := h.events.append(kind, targets, data)
+	for client := range h.recipients(kind, targets) {
+		if client != ignore && !client.send(item.data) {
+			slow = append(slow, client)
+		}
+	}
+	h.mutex.Unlock()
+
+	for _, client := range slow {
+		h.evict(client)
+	}
+}
+
+// Devuelve los clientes que reciben un evento, se llama con el mutex tomado
+func (h *Hub) recipients(kind string, targets []string) map[*Client]bool {
+	switch {
+	case kind == kindBroadcast:
+		return h.clients
+	case len(targets) == 0:
+		return nil
+	case kind == kindUser:
+		return h.users[targets[0]]
+	case len(targets) == 1:
+		return h.topics[targets[0]]
+	}
+
+	// Union de los suscriptores de varios topicos, cada cliente aparece una sola vez
+	clients := map[*Client]bool{}
+	for _, topic := range targets {
+		for client := range h.topics[topic] {
+			clients[client] = true
 		}
 	}
+	return clients
 }
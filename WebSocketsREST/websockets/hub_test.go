@@ -0,0 +1,314 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Autenticador de prueba, el token es el id del usuario
+func testAuthenticator(ctx context.Context, token string) (string, error) {
+	if strings.HasPrefix(token, "valid-") {
+		return strings.TrimPrefix(token, "valid-"), nil
+	}
+	return "", errors.New("invalid token")
+}
+
+func newTestHub(t *testing.T, config HubConfig) (*Hub, *httptest.Server) {
+	hub := NewHub(config)
+	hub.SetAuthenticator(testAuthenticator)
+	go hub.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+	t.Cleanup(server.Close)
+	return hub, server
+}
+
+func dial(t *testing.T, server *httptest.Server, query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + query
+	conn, response, err := websocket.DefaultDialer.Dial(url, header)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, response, err
+}
+
+// Espera a que el hub tenga registradas las conexiones del usuario
+func waitForUser(t *testing.T, hub *Hub, userId string, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		hub.mutex.Lock()
+		count := len(hub.users[userId])
+		hub.mutex.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("user %s did not get %d connections", userId, n)
+}
+
+func TestHandleWebSocketAuthentication(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{AuthTimeout: time.Second})
+
+	// Token en la cabecera
+	_, _, err := dial(t, server, "", http.Header{"Authorization": {"Bearer valid-header"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "header", 1)
+
+	// Token en la url
+	_, _, err = dial(t, server, "?token=valid-query", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "query", 1)
+
+	// Token en el primer mensaje
+	conn, _, err := dial(t, server, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(map[string]interface{}{"type": "auth", "payload": map[string]string{"token": "valid-message"}}); err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "message", 1)
+
+	// Token invalido, no se actualiza la conexion
+	_, response, err := dial(t, server, "?token=wrong", nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("invalid token should be rejected with 401, got %v", err)
+	}
+
+	// Primer mensaje sin token, el servidor cierra la conexion
+	conn, _, err = dial(t, server, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "ping"}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("expected policy violation close, got %v", err)
+	}
+}
+
+func TestHandleWebSocketOrigin(t *testing.T) {
+	_, server := newTestHub(t, HubConfig{AllowedOrigins: []string{"https://app.example.com"}})
+
+	_, _, err := dial(t, server, "?token=valid-user", http.Header{"Origin": {"https://app.example.com"}})
+	if err != nil {
+		t.Errorf("allowed origin was rejected: %v", err)
+	}
+
+	_, response, err := dial(t, server, "?token=valid-user", http.Header{"Origin": {"https://evil.example.com"}})
+	if err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("disallowed origin should be rejected with 403, got %v", err)
+	}
+}
+
+func TestSendToUser(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{})
+
+	first, _, err := dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := dial(t, server, "?token=valid-bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "alice", 2)
+	waitForUser(t, hub, "bob", 1)
+
+	hub.SendToUser("alice", map[string]string{"type": "hello"})
+
+	for _, conn := range []*websocket.Conn{first, second} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != `{"seq":1,"type":"hello"}` {
+			t.Errorf("SendToUser was incorrect, got %s", data)
+		}
+	}
+
+	other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := other.ReadMessage(); err == nil {
+		t.Errorf("SendToUser delivered to another user: %s", data)
+	}
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestProtocol(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{})
+
+	conn, _, err := dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "alice", 1)
+
+	tables := []struct {
+		request  string
+		response string
+	}{
+		{`{"type":"ping"}`, `{"type":"pong","payload":null}`},
+		{`{"type":"subscribe","payload":{"topic":"posts"}}`, `{"type":"subscribed","payload":{"topic":"posts"}}`},
+		{`{"type":"subscribe","payload":{"topic":"posts:123"}}`, `{"type":"subscribed","payload":{"topic":"posts:123"}}`},
+		{`{"type":"subscribe","payload":{"topic":"user:alice"}}`, `{"type":"subscribed","payload":{"topic":"user:alice"}}`},
+		{`{"type":"subscribe","payload":{"topic":"user:bob"}}`, `{"type":"error","payload":{"message":"forbidden topic"}}`},
+		{`{"type":"subscribe","payload":{"topic":"secrets"}}`, `{"type":"error","payload":{"message":"unknown topic"}}`},
+		{`{"type":"subscribe"}`, `{"type":"error","payload":{"message":"topic is required"}}`},
+		{`{"type":"dance"}`, `{"type":"error","payload":{"message":"unknown command dance"}}`},
+		{`not json`, `{"type":"error","payload":{"message":"invalid message"}}`},
+		{`{"type":"unsubscribe","payload":{"topic":"posts:123"}}`, `{"type":"unsubscribed","payload":{"topic":"posts:123"}}`},
+	}
+
+	for _, item := range tables {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(item.request)); err != nil {
+			t.Fatal(err)
+		}
+		if got := readMessage(t, conn); got != item.response {
+			t.Errorf("%s was incorrect, got %s expected %s", item.request, got, item.response)
+		}
+	}
+
+	// Solo se reciben los topicos suscritos
+	hub.Publish("posts:123", map[string]string{"type": "unsubscribed topic"})
+	hub.Publish("posts", map[string]string{"type": "post"})
+	if got := readMessage(t, conn); got != `{"seq":2,"type":"post"}` {
+		t.Errorf("Publish was incorrect, got %s", got)
+	}
+}
+
+func TestPublishAll(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{})
+
+	conn, _, err := dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "alice", 1)
+
+	for _, topic := range []string{"posts", "posts:123"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","payload":{"topic":"`+topic+`"}}`)); err != nil {
+			t.Fatal(err)
+		}
+		readMessage(t, conn)
+	}
+
+	// Suscrito a los dos topicos, recibe el mensaje una sola vez
+	hub.PublishAll([]string{"posts", "posts:123"}, map[string]string{"type": "post"})
+	hub.PublishAll([]string{"posts:456"}, map[string]string{"type": "other"})
+	hub.Publish("posts", map[string]string{"type": "next"})
+
+	if got := readMessage(t, conn); got != `{"seq":1,"type":"post"}` {
+		t.Errorf("PublishAll was incorrect, got %s", got)
+	}
+	if got := readMessage(t, conn); got != `{"seq":3,"type":"next"}` {
+		t.Errorf("PublishAll delivered twice or to another topic, got %s", got)
+	}
+}
+
+func TestDisconnectUnregisters(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{})
+
+	conn, _, err := dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "alice", 1)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","payload":{"topic":"posts"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	readMessage(t, conn)
+
+	conn.Close()
+	waitForUser(t, hub, "alice", 0)
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if len(hub.clients) != 0 || len(hub.topics) != 0 {
+		t.Errorf("disconnected client was not cleaned up: %d clients, %d topics", len(hub.clients), len(hub.topics))
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	hub := NewHub(HubConfig{})
+	hub.SetAuthenticator(testAuthenticator)
+	stopped := make(chan bool)
+	go func() {
+		hub.Run()
+		close(stopped)
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+	t.Cleanup(server.Close)
+
+	conn, _, err := dial(t, server, "?token=valid-alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForUser(t, hub, "alice", 1)
+
+	// El cliente lee en segundo plano, al recibir el cierre gorilla responde y cierra la conexion
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := hub.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown was incorrect, got %v", err)
+	}
+
+	select {
+	case err := <-closed:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("expected going away close, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not receive a close frame")
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop after Shutdown")
+	}
+
+	// Las conexiones nuevas se rechazan
+	_, response, err := dial(t, server, "?token=valid-alice", nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("connection after shutdown should be rejected with 503, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package websockets
+
+import (
+	"errors"
+	"net/http"
+	"rest_ws/problem"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	Limites de conexiones del hub
+	Una conexion reserva su lugar despues de autenticarse y antes de registrarse, asi las conexiones
+	que se estan abriendo al mismo tiempo no pueden superar el limite
+*/
+
+var (
+	errHubFull   = errors.New("too many connections")
+	errUserLimit = errors.New("too many connections for this user")
+)
+
+// Reserva un lugar para una conexion del usuario, se libera en OnConnect o con unreserve si la conexion falla
+func (h *Hub) reserve(userId string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.config.MaxConnections > 0 && len(h.clients)+h.pending >= h.config.MaxConnections {
+		return errHubFull
+	}
+	if h.config.MaxConnectionsPerUser > 0 && len(h.users[userId])+h.pendingUsers[userId] >= h.config.MaxConnectionsPerUser {
+		return errUserLimit
+	}
+
+	h.pending++
+	h.pendingUsers[userId]++
+	return nil
+}
+
+func (h *Hub) unreserve(userId string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.release(userId)
+}
+
+// Libera la reserva, se debe llamar con el mutex tomado
+func (h *Hub) release(userId string) {
+	h.pending--
+	if h.pendingUsers[userId]--; h.pendingUsers[userId] <= 0 {
+		delete(h.pendingUsers, userId)
+	}
+}
+
+// Indica si el hub ya no acepta conexiones, se usa para rechazar antes de autenticar
+func (h *Hub) full() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.config.MaxConnections > 0 && len(h.clients)+h.pending >= h.config.MaxConnections
+}
+
+// Responde el error de reserve antes de actualizar la conexion
+func limitError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errUserLimit) {
+		problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many connections for this user")
+		return
+	}
+	problem.Error(w, r, http.StatusServiceUnavailable, problem.CodeUnavailable, "Too many connections")
+}
+
+// Cierra una conexion ya actualizada con el error de reserve
+func closeLimited(socket *websocket.Conn, err error) {
+	if errors.Is(err, errUserLimit) {
+		closeSocket(socket, websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+	closeSocket(socket, websocket.CloseTryAgainLater, err.Error())
+}
@@ -0,0 +1,78 @@
+package websockets
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnectionLimits(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{MaxConnections: 3, MaxConnectionsPerUser: 2})
+
+	tables := []struct {
+		query  string
+		status int // 0 si la conexion se acepta
+	}{
+		{"?token=valid-a", 0},
+		{"?token=valid-a", 0},
+		{"?token=valid-a", http.StatusTooManyRequests},
+		{"?token=valid-b", 0},
+		{"?token=valid-c", http.StatusServiceUnavailable},
+	}
+
+	var first *websocket.Conn
+	for _, item := range tables {
+		conn, response, err := dial(t, server, item.query, nil)
+		if item.status == 0 {
+			if err != nil {
+				t.Fatalf("dial %s failed: %v", item.query, err)
+			}
+			if first == nil {
+				first = conn
+			}
+			continue
+		}
+		if err == nil || response == nil || response.StatusCode != item.status {
+			t.Fatalf("dial %s was incorrect, got %v expected status %d", item.query, response, item.status)
+		}
+	}
+
+	// Al cerrar una conexion se libera su lugar
+	waitForUser(t, hub, "a", 2)
+	first.Close()
+	waitForUser(t, hub, "a", 1)
+	if _, _, err := dial(t, server, "?token=valid-c", nil); err != nil {
+		t.Errorf("dial after a disconnect failed: %v", err)
+	}
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.pending != 0 || len(hub.pendingUsers) != 0 {
+		t.Errorf("reservations were not released, got %d pending", hub.pending)
+	}
+}
+
+func TestConnectionLimitsFirstMessageAuth(t *testing.T) {
+	hub, server := newTestHub(t, HubConfig{MaxConnectionsPerUser: 1})
+
+	for i, expected := range []bool{true, false} {
+		conn, _, err := dial(t, server, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteJSON(map[string]interface{}{"type": "auth", "payload": map[string]string{"token": "valid-a"}}); err != nil {
+			t.Fatal(err)
+		}
+		if expected {
+			waitForUser(t, hub, "a", 1)
+			continue
+		}
+
+		// La segunda conexion se autentica pero se cierra porque el usuario ya tiene su maximo
+		_, _, err = conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			t.Errorf("connection %d was incorrect, got %v expected a policy violation close", i, err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package websockets
+
+/*
+	Protocolo de los mensajes que envian los clientes, todos usan el formato de models.WebSocketMessage
+
+		{"type": "subscribe",   "payload": {"topic": "posts"}}   -> {"type": "subscribed",   "payload": {"topic": "posts"}}
+		{"type": "unsubscribe", "payload": {"topic": "posts"}}   -> {"type": "unsubscribed", "payload": {"topic": "posts"}}
+		{"type": "ping"}                                         -> {"type": "pong"}
+		{"type": "ack",         "payload": {"id": "..."}}        -> sin respuesta, registra el ultimo mensaje recibido
+		{"type": "resume",      "payload": {"epoch": "...", "seq": 42}}
+		                                                         -> reenvia los eventos perdidos y {"type": "resumed", ...}
+		                                                            o {"type": "resync", ...} si hay que recargar todo
+
+	El detalle de las secuencias y de resume esta en events.go
+
+	Los comandos invalidos se responden con {"type": "error", "payload": {"message": "..."}}
+
+	Topicos disponibles:
+		posts        -> todos los posts
+		posts:{id}   -> un post especifico y sus comentarios
+		users        -> usuarios nuevos
+		user:{id}    -> mensajes privados de un usuario, solo ese usuario puede suscribirse
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"rest_ws/models"
+	"strings"
+)
+
+const (
+	CommandSubscribe   = "subscribe"
+	CommandUnsubscribe = "unsubscribe"
+	CommandPing        = "ping"
+	CommandAck         = "ack"
+	CommandResume      = "resume"
+
+	ReplySubscribed   = "subscribed"
+	ReplyUnsubscribed = "unsubscribed"
+	ReplyPong         = "pong"
+	ReplyResumed      = "resumed"
+	ReplyResync       = "resync"
+	ReplyError        = "error"
+)
+
+const (
+	PostsTopic = "posts"
+	UsersTopic = "users"
+)
+
+// Cantidad maxima de topicos a los que se puede suscribir una conexion
+const maxTopicsPerClient = 100
+
+var (
+	errUnknownTopic   = errors.New("unknown topic")
+	errForbiddenTopic = errors.New("forbidden topic")
+	errTooManyTopics  = errors.New("too many subscriptions")
+)
+
+// Mensaje entrante, el payload se decodifica segun el tipo del comando
+type command struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type TopicPayload struct {
+	Topic string `json:"topic"`
+}
+
+type AckPayload struct {
+	Id string `json:"id"`
+}
+
+type ResumePayload struct {
+	Epoch    string `json:"epoch"`
+	Seq      uint64 `json:"seq"`
+	Replayed int    `json:"replayed,omitempty"` // Cantidad de eventos reenviados, solo en resumed
+}
+
+type ErrorPayload struct {
+	Message string `json:"message"`
+}
+
+// Devuelve el topico de un post especifico
+func PostTopic(id string) string {
+	return "posts:" + id
+}
+
+// Devuelve el topico privado de un usuario
+func UserTopic(id string) string {
+	return "user:" + id
+}
+
+// Valida que el topico exista y que el cliente pueda suscribirse
+func authorizeTopic(client *Client, topic string) error {
+	switch {
+	case topic == PostsTopic, topic == UsersTopic:
+		return nil
+	case strings.HasPrefix(topic, "posts:") && len(topic) > len("posts:"):
+		return nil
+	case strings.HasPrefix(topic, "user:") && len(topic) > len("user:"):
+		if topic != UserTopic(client.userId) {
+			return errForbiddenTopic
+		}
+		return nil
+	}
+	return errUnknownTopic
+}
+
+// Ejecuta un comando recibido de un cliente
+func (h *Hub) handleCommand(client *Client, data []byte) {
+	var cmd command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		client.reply(ReplyError, ErrorPayload{Message: "invalid message"})
+		return
+	}
+
+	switch cmd.Type {
+	case CommandSubscribe, CommandUnsubscribe:
+		var payload TopicPayload
+		if err := json.Unmarshal(cmd.Payload, &payload); err != nil || payload.Topic == "" {
+			client.reply(ReplyError, ErrorPayload{Message: "topic is required"})
+			return
+		}
+
+		if cmd.Type == CommandUnsubscribe {
+			h.Unsubscribe(client, payload.Topic)
+			client.reply(ReplyUnsubscribed, payload)
+			return
+		}
+
+		if err := h.Subscribe(client, payload.Topic); err != nil {
+			client.reply(ReplyError, ErrorPayload{Message: err.Error()})
+			return
+		}
+		client.reply(ReplySubscribed, payload)
+
+	case CommandPing:
+		client.reply(ReplyPong, nil)
+
+	case CommandAck:
+		var payload AckPayload
+		if err := json.Unmarshal(cmd.Payload, &payload); err != nil || payload.Id == "" {
+			client.reply(ReplyError, ErrorPayload{Message: "id is required"})
+			return
+		}
+		h.mutex.Lock()
+		client.lastAck = payload.Id
+		h.mutex.Unlock()
+
+	case CommandResume:
+		// Sin payload solo se pide la posicion actual, la respuesta siempre es resync
+		var payload ResumePayload
+		if err := json.Unmarshal(cmd.Payload, &payload); len(cmd.Payload) > 0 && err != nil {
+			client.reply(ReplyError, ErrorPayload{Message: "invalid resume payload"})
+			return
+		}
+		position, err := h.replay(client, payload.Epoch, payload.Seq)
+		if err != nil {
+			client.reply(ReplyResync, position)
+			return
+		}
+		client.reply(ReplyResumed, position)
+
+	default:
+		client.reply(ReplyError, ErrorPayload{Message: "unknown command " + cmd.Type})
+	}
+}
+
+// Envia una respuesta a un comando solo al cliente que lo envio
+func (c *Client) reply(kind string, payload interface{}) {
+	data, err := json.Marshal(models.WebSocketMessage{Type: kind, Payload: payload})
+	if err != nil {
+		return
+	}
+	if !c.send(data) {
+		c.hub.evict(c)
+	}
+}